@@ -53,6 +53,35 @@ func (e PanicError) Error() string {
 	return fmt.Sprintf("PanicError: %v", e.Cause)
 }
 
+// StatesError is an Error and Cause matched by its Name in Retry and Catch ErrorEquals,
+// e.g. one of the predefined States.* errors like States.BranchFailed, or an error thrown by a Task
+type StatesError struct {
+	Name  string
+	Cause string
+}
+
+func (e StatesError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Name, e.Cause)
+}
+
+// ErrorType returns the Name used to match the error
+func (e StatesError) ErrorType() string {
+	return e.Name
+}
+
+// FailError is the Error and Cause of a Fail state
+type FailError struct {
+	StatesError
+}
+
+func (e FailError) Error() string {
+	cause := e.Cause
+	if cause == "" {
+		cause = "Undefined"
+	}
+	return fmt.Sprintf("Fail State with Cause: %v", cause)
+}
+
 //
 // Specific Deploy/Release errors
 //
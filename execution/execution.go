@@ -195,6 +195,32 @@ func (e *Execution) GetStateDetails(sfnc sfniface.SFNAPI) (*StateDetails, error)
 	return &sd, nil
 }
 
+// GetHistory returns every event of the execution, oldest first,
+// unlike GetStateDetails which only reads the most recent events
+func GetHistory(sfnc sfniface.SFNAPI, executionArn *string) ([]*sfn.HistoryEvent, error) {
+	events := []*sfn.HistoryEvent{}
+
+	var nextToken *string
+	for {
+		history_out, err := sfnc.GetExecutionHistory(&sfn.GetExecutionHistoryInput{
+			ExecutionArn: executionArn,
+			MaxResults:   to.Int64p(1000),
+			NextToken:    nextToken,
+		})
+
+		if err != nil {
+			return nil, err
+		}
+
+		events = append(events, history_out.Events...)
+
+		if history_out.NextToken == nil {
+			return events, nil
+		}
+		nextToken = history_out.NextToken
+	}
+}
+
 // WaitForExecution allows another application to wait for the execution to finish
 // and process output as it comes in for usability
 func (e *Execution) WaitForExecution(sfnc sfniface.SFNAPI, sleep int, fn ExecutionWaiter) {
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+)
+
+// heartbeatKey stores the heartbeat function in a context.Context
+type heartbeatKey struct{}
+
+// WithHeartbeat returns a Context that handlers can send heartbeats through
+func WithHeartbeat(ctx context.Context, heartbeat func() error) context.Context {
+	return context.WithValue(ctx, heartbeatKey{}, heartbeat)
+}
+
+// Heartbeat tells the state machine a long running handler is still working,
+// extending its HeartbeatSeconds deadline
+func Heartbeat(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("Heartbeat Error: nil context")
+	}
+
+	heartbeat, ok := ctx.Value(heartbeatKey{}).(func() error)
+	if !ok {
+		return fmt.Errorf("Heartbeat Error: context has no heartbeat")
+	}
+
+	return heartbeat()
+}
@@ -0,0 +1,426 @@
+package jsonpath
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// filterSelector selects the elements of an array, or values of an object, for which test is true
+type filterSelector struct {
+	test filterExpr
+}
+
+type filterExpr interface {
+	eval(current interface{}, root interface{}) bool
+	String() string
+}
+
+// logicalExpr is a && b or a || b
+type logicalExpr struct {
+	op          string
+	left, right filterExpr
+}
+
+type notExpr struct {
+	expr filterExpr
+}
+
+// existsExpr is true if the path selects any value, e.g. [?(@.x)]
+type existsExpr struct {
+	path *queryOperand
+}
+
+type comparisonExpr struct {
+	op          string
+	left, right operand
+}
+
+// operand is a value compared in a filter, ok is false if a path selects nothing
+type operand interface {
+	value(current interface{}, root interface{}) (interface{}, bool)
+	String() string
+}
+
+// queryOperand is a path relative to the current value "@" or the root "$"
+type queryOperand struct {
+	relative bool
+	segments []*segment
+}
+
+type literalOperand struct {
+	literal interface{}
+}
+
+func (f filterSelector) selectFrom(selected []interface{}, value interface{}, root interface{}) []interface{} {
+	for _, child := range children(value) {
+		if f.test.eval(child, root) {
+			selected = append(selected, child)
+		}
+	}
+	return selected
+}
+
+func (f filterSelector) String() string {
+	return "?(" + f.test.String() + ")"
+}
+
+// PARSER
+
+// parseFilter reads the expression after "?", with or without parentheses around it
+func (p *pathParser) parseFilter() (selector, error) {
+	inFilter := p.inFilter
+	p.inFilter = true
+	defer func() { p.inFilter = inFilter }()
+
+	test, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	return filterSelector{test: test}, nil
+}
+
+func (p *pathParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.skipSpace(); strings.HasPrefix(p.rest(), "||"); p.skipSpace() {
+		p.pos += 2
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "||", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *pathParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.skipSpace(); strings.HasPrefix(p.rest(), "&&"); p.skipSpace() {
+		p.pos += 2
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalExpr{op: "&&", left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func (p *pathParser) parseUnary() (filterExpr, error) {
+	p.skipSpace()
+
+	switch {
+	case p.peek() == '!' && !strings.HasPrefix(p.rest(), "!="):
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notExpr{expr: expr}, nil
+	case p.peek() == '(':
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(')', ")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+var comparisonOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func (p *pathParser) parseComparison() (filterExpr, error) {
+	start := p.pos
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	op := ""
+	for _, o := range comparisonOps {
+		if strings.HasPrefix(p.rest(), o) {
+			op = o
+			break
+		}
+	}
+
+	if op == "" {
+		// Without a comparison the operand must be a path that exists
+		query, ok := left.(*queryOperand)
+		if !ok {
+			p.pos = start
+			return nil, p.errorf("expected a path or comparison")
+		}
+		return &existsExpr{path: query}, nil
+	}
+	p.pos += len(op)
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, side := range []operand{left, right} {
+		if query, ok := side.(*queryOperand); ok && !query.definite() {
+			p.pos = start
+			return nil, p.errorf("cannot compare %v, it may select many values", query)
+		}
+	}
+
+	return &comparisonExpr{op: op, left: left, right: right}, nil
+}
+
+func (p *pathParser) parseOperand() (operand, error) {
+	p.skipSpace()
+	c := p.peek()
+
+	switch {
+	case c == '@' || c == '$':
+		p.pos++
+		segments, err := p.parseSegments()
+		if err != nil {
+			return nil, err
+		}
+		return &queryOperand{relative: c == '@', segments: segments}, nil
+	case c == '\'' || c == '"':
+		str, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return &literalOperand{literal: str}, nil
+	case c == '-' || isDigit(c):
+		return p.parseNumber()
+	case p.done():
+		return nil, p.errorf("expected value, got end of path")
+	}
+
+	for _, keyword := range []string{"true", "false", "null"} {
+		if strings.HasPrefix(p.rest(), keyword) {
+			p.pos += len(keyword)
+			var literal interface{}
+			if keyword != "null" {
+				literal = keyword == "true"
+			}
+			return &literalOperand{literal: literal}, nil
+		}
+	}
+
+	return nil, p.errorf("unexpected %q in filter", string(c))
+}
+
+func (p *pathParser) parseNumber() (operand, error) {
+	start := p.pos
+	for !p.done() && strings.IndexByte("+-.eE0123456789", p.expr[p.pos]) >= 0 {
+		p.pos++
+	}
+
+	number, err := strconv.ParseFloat(p.expr[start:p.pos], 64)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("bad number")
+	}
+
+	return &literalOperand{literal: number}, nil
+}
+
+// EVALUATION
+
+func (e *logicalExpr) eval(current interface{}, root interface{}) bool {
+	if e.op == "&&" {
+		return e.left.eval(current, root) && e.right.eval(current, root)
+	}
+	return e.left.eval(current, root) || e.right.eval(current, root)
+}
+
+func (e *logicalExpr) String() string {
+	return fmt.Sprintf("%v %v %v", groupString(e.left, e.op), e.op, groupString(e.right, e.op))
+}
+
+// groupString puts parentheses around a || inside a &&, and any logical expression after !
+func groupString(expr filterExpr, op string) string {
+	if logical, ok := expr.(*logicalExpr); ok && (op == "!" || logical.op != op) {
+		return "(" + expr.String() + ")"
+	}
+	return expr.String()
+}
+
+func (e *notExpr) eval(current interface{}, root interface{}) bool {
+	return !e.expr.eval(current, root)
+}
+
+func (e *notExpr) String() string {
+	return "!" + groupString(e.expr, "!")
+}
+
+func (e *existsExpr) eval(current interface{}, root interface{}) bool {
+	return len(e.path.nodes(current, root)) > 0
+}
+
+func (e *existsExpr) String() string {
+	return e.path.String()
+}
+
+func (e *comparisonExpr) eval(current interface{}, root interface{}) bool {
+	left, leftOK := e.left.value(current, root)
+	right, rightOK := e.right.value(current, root)
+
+	// A path that selects nothing only equals another that selects nothing
+	if !leftOK || !rightOK {
+		switch e.op {
+		case "==":
+			return leftOK == rightOK
+		case "!=":
+			return leftOK != rightOK
+		}
+		return false
+	}
+
+	switch e.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	order, ok := compare(left, right)
+	if !ok {
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	}
+	return order >= 0
+}
+
+func (e *comparisonExpr) String() string {
+	return fmt.Sprintf("%v %v %v", e.left, e.op, e.right)
+}
+
+// nodes returns the values the path selects
+func (q *queryOperand) nodes(current interface{}, root interface{}) []interface{} {
+	values := []interface{}{root}
+	if q.relative {
+		values = []interface{}{current}
+	}
+
+	for _, seg := range q.segments {
+		values = seg.apply(values, root)
+	}
+	return values
+}
+
+func (q *queryOperand) definite() bool {
+	for _, seg := range q.segments {
+		if !seg.definite() {
+			return false
+		}
+	}
+	return true
+}
+
+func (q *queryOperand) value(current interface{}, root interface{}) (interface{}, bool) {
+	nodes := q.nodes(current, root)
+	if len(nodes) == 0 {
+		return nil, false
+	}
+	return nodes[0], true
+}
+
+func (q *queryOperand) String() string {
+	if q.relative {
+		return "@" + segmentsString(q.segments)
+	}
+	return "$" + segmentsString(q.segments)
+}
+
+func (l *literalOperand) value(_ interface{}, _ interface{}) (interface{}, bool) {
+	return l.literal, true
+}
+
+func (l *literalOperand) String() string {
+	switch literal := l.literal.(type) {
+	case string:
+		return quote(literal)
+	case float64:
+		return strconv.FormatFloat(literal, 'g', -1, 64)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%v", l.literal)
+}
+
+// equal compares JSON values, numbers of any Go type are equal if they have the same value
+func equal(a interface{}, b interface{}) bool {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two numbers or two strings, ok is false for any other values
+func compare(a interface{}, b interface{}) (int, bool) {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+func number(value interface{}) (float64, bool) {
+	switch n := value.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	}
+	return 0, false
+}
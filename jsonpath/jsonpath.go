@@ -1,4 +1,4 @@
-// Simple Implementation of JSON Path for state machine
+// Implementation of JSON Path for state machine
 package jsonpath
 
 import (
@@ -6,8 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -27,15 +25,29 @@ nil for JSON null
 var NOT_FOUND_ERROR = errors.New("JSON Path Not Found")
 
 type Path struct {
-	path []string
+	path     []string   // the notation of each segment, e.g. "a", "[0]" or "..b"
+	segments []*segment // parsed from the path
+	null     bool       // null paths discard data, otherwise they act like "$"
+}
+
+// NullPath returns the Path for a JSON null e.g. "ResultPath": null
+func NullPath() *Path {
+	return &Path{path: []string{}, null: true}
 }
 
 // NewPath takes string returns JSONPath Object
 func NewPath(path_string string) (*Path, error) {
-	path := Path{}
-	path_array, err := ParsePathString(path_string)
-	path.path = path_array
-	return &path, err
+	segments, err := parsePath(path_string)
+	path := newPath(segments)
+	return path, err
+}
+
+func newPath(segments []*segment) *Path {
+	path := &Path{path: []string{}, segments: segments}
+	for _, seg := range segments {
+		path.path = append(path.path, seg.String())
+	}
+	return path
 }
 
 // UnmarshalJSON makes a path out of a json string
@@ -47,29 +59,99 @@ func (path *Path) UnmarshalJSON(b []byte) error {
 		return err
 	}
 
-	path_array, err := ParsePathString(path_string)
+	parsed, err := NewPath(path_string)
 
 	if err != nil {
 		return err
 	}
 
-	path.path = path_array
+	*path = *parsed
 	return nil
 }
 
 // MarshalJSON converts path to json string
 func (path *Path) MarshalJSON() ([]byte, error) {
-	if len(path.path) == 0 {
-		return json.Marshal("$")
+	if path.null {
+		return []byte("null"), nil
 	}
+
 	return json.Marshal(path.String())
 }
 
+// IsRoot returns true if the path is "$", a nil path is also the root
+func (path *Path) IsRoot() bool {
+	return path == nil || len(path.segments) == 0
+}
+
+// IsNull returns true if the path was defined as null
+func (path *Path) IsNull() bool {
+	return path != nil && path.null
+}
+
+// IsDefinite returns true if the path selects at most one value, i.e. it has only names and single indices.
+// Get returns a list of the values an indefinite path selects, and only a definite path can be Set.
+func (path *Path) IsDefinite() bool {
+	if path == nil {
+		return true
+	}
+
+	for _, seg := range path.segments {
+		if !seg.definite() {
+			return false
+		}
+	}
+	return true
+}
+
+// CanSet returns true if Set would not overwrite a value that is not an object
+func (path *Path) CanSet(input interface{}) bool {
+	if path.IsRoot() {
+		return true
+	}
+
+	if !path.IsDefinite() {
+		return false
+	}
+
+	data := input
+	for _, seg := range path.segments {
+		switch sel := seg.selectors[0].(type) {
+		case nameSelector:
+			switch data.(type) {
+			case nil:
+				return true
+			case map[string]interface{}:
+				data = data.(map[string]interface{})[string(sel)]
+			default:
+				return false
+			}
+		case indexSelector:
+			array, ok := data.([]interface{})
+			if !ok {
+				return false
+			}
+
+			i, ok := sel.normalize(len(array))
+			if !ok {
+				return false
+			}
+			data = array[i]
+		}
+	}
+
+	return true
+}
+
+// String returns the path in a notation it can be parsed from, e.g. "$.a['b c'][0]"
 func (path *Path) String() string {
-	return fmt.Sprintf("$.%v", strings.Join(path.path[:], "."))
+	if path == nil {
+		return "$"
+	}
+	return "$" + segmentsString(path.segments)
 }
 
-// ParsePathString parses a path string
+// ParsePathString parses a simple path string by splitting it on ".", e.g. "$.a[0]" is ["a[0]"].
+// Use ParseSegments for paths with brackets, descent or filters
 func ParsePathString(path_string string) ([]string, error) {
 	// must start with $.<value> otherwise empty path
 	if path_string == "" || path_string[0:1] != "$" ||
@@ -101,6 +183,16 @@ func ParsePathString(path_string string) ([]string, error) {
 	return path_array, nil
 }
 
+// ParseSegments parses a path string into the notation of each segment, e.g. "$.a[0]" is ["a", "[0]"], see Path.String
+func ParseSegments(path_string string) ([]string, error) {
+	path, err := NewPath(path_string)
+	if err != nil {
+		return nil, err
+	}
+
+	return path.path, nil
+}
+
 // PUBLIC METHODS
 
 // GetTime returns Time from Path
@@ -202,24 +294,26 @@ func (path *Path) GetMap(input interface{}) (output map[string]interface{}, err
 	return output, nil
 }
 
-// Get returns interface from Path
+// Get returns the value at a definite Path, or the list of values an indefinite Path selects
 func (path *Path) Get(input interface{}) (value interface{}, err error) {
 	if path == nil {
 		return input, nil // Default is $
 	}
-	return recursiveGet(input, path.path)
-}
 
-// Set sets a Value in a map with Path
-func (path *Path) Set(input interface{}, value interface{}) (output map[string]interface{}, err error) {
-	var set_path []string
-	if path == nil {
-		set_path = []string{} // default "$"
-	} else {
-		set_path = path.path
+	if !path.IsDefinite() {
+		values := []interface{}{input}
+		for _, seg := range path.segments {
+			values = seg.apply(values, input)
+		}
+		return values, nil
 	}
 
-	if len(set_path) == 0 {
+	return definiteGet(input, path.segments)
+}
+
+// Set returns input with value at Path, copying the maps and arrays along Path instead of changing input
+func (path *Path) Set(input interface{}, value interface{}) (output map[string]interface{}, err error) {
+	if path.IsRoot() {
 		// The output is the value
 		switch value.(type) {
 		case map[string]interface{}:
@@ -229,82 +323,109 @@ func (path *Path) Set(input interface{}, value interface{}) (output map[string]i
 			return nil, fmt.Errorf("Cannot Set value %q type %q in root JSON path $", value, reflect.TypeOf(value))
 		}
 	}
-	return recursiveSet(input, value, set_path), nil
-}
-
-// PRIVATE METHODS
 
-func recursiveSet(data interface{}, value interface{}, path []string) (output map[string]interface{}) {
-	var data_map map[string]interface{}
+	if !path.IsDefinite() {
+		return nil, fmt.Errorf("Cannot Set value in JSON path %v that may select many values", path)
+	}
 
-	switch data.(type) {
-	case map[string]interface{}:
-		data_map = data.(map[string]interface{})
-	default:
-		// Overwrite current data with new map
-		// this will work for nil as well
-		data_map = make(map[string]interface{})
+	if _, ok := path.segments[0].selectors[0].(nameSelector); !ok {
+		return nil, fmt.Errorf("Cannot Set value in JSON path %v, $ must be an object", path)
 	}
 
-	if len(path) == 1 {
-		data_map[path[0]] = value
-	} else {
-		data_map[path[0]] = recursiveSet(data_map[path[0]], value, path[1:])
+	set, err := recursiveSet(input, value, path.segments)
+	if err != nil {
+		return nil, err
 	}
 
-	return data_map
+	return set.(map[string]interface{}), nil
 }
 
-func recursiveGet(data interface{}, path []string) (interface{}, error) {
-	if len(path) == 0 {
-		return data, nil
-	}
+// PRIVATE METHODS
 
-	if data == nil {
-		return nil, NOT_FOUND_ERROR
+func recursiveSet(data interface{}, value interface{}, segments []*segment) (interface{}, error) {
+	if len(segments) == 0 {
+		return value, nil
 	}
 
-	var indexed bool
-	var currentPath string
-	var currentIndex int
-	var err error
+	switch sel := segments[0].selectors[0].(type) {
+	case indexSelector:
+		array, ok := data.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Cannot Set value at [%v], not an array", int(sel))
+		}
+
+		i, ok := sel.normalize(len(array))
+		if !ok {
+			return nil, fmt.Errorf("Cannot Set value at [%v], index out of range", int(sel))
+		}
 
-	if strings.Contains(path[0], "[") && strings.Contains(path[0], "]") {
-		indexed = true
-		// Handle slice indexing
-		re := regexp.MustCompile(`\[(.*?)\]`)
-		indices := re.FindAllString(path[0], -1)
-		// TODO: handle multiple indices
-		indexStr := indices[0]
-		indexStr = strings.Trim(indexStr, "[")
-		indexStr = strings.Trim(indexStr, "]")
-		currentIndex, err = strconv.Atoi(indexStr)
+		set, err := recursiveSet(array[i], value, segments[1:])
 		if err != nil {
-			return data, fmt.Errorf("Error: %v while indexing: %v", err, path[0])
+			return nil, err
 		}
-		currentPath = strings.Split(path[0], "[")[0]
-	} else {
-		currentPath = path[0]
-	}
 
-	switch data.(type) {
-	case map[string]interface{}:
-		var (
-			value interface{}
-			ok    bool
-		)
+		// Copy so the input, which may be shared, is never changed
+		copied := append([]interface{}{}, array...)
+		copied[i] = set
+		return copied, nil
+	default:
+		name := string(sel.(nameSelector))
 
-		value, ok = data.(map[string]interface{})[currentPath]
-		if !ok {
-			return data, fmt.Errorf("JSON path not found: %v", path[0])
+		var data_map map[string]interface{}
+		switch data.(type) {
+		case map[string]interface{}:
+			// Copy so the input, which may be shared, is never changed
+			data_map = make(map[string]interface{}, len(data.(map[string]interface{}))+1)
+			for k, v := range data.(map[string]interface{}) {
+				data_map[k] = v
+			}
+		default:
+			// Overwrite current data with new map
+			// this will work for nil as well
+			data_map = make(map[string]interface{})
 		}
 
-		if indexed {
-			value = reflect.ValueOf(value).Index(currentIndex).Interface()
+		set, err := recursiveSet(data_map[name], value, segments[1:])
+		if err != nil {
+			return nil, err
 		}
 
-		return recursiveGet(value, path[1:])
-	default:
-		return data, NOT_FOUND_ERROR
+		data_map[name] = set
+		return data_map, nil
+	}
+}
+
+func definiteGet(data interface{}, segments []*segment) (interface{}, error) {
+	for _, seg := range segments {
+		if data == nil {
+			return nil, NOT_FOUND_ERROR
+		}
+
+		switch sel := seg.selectors[0].(type) {
+		case nameSelector:
+			data_map, ok := data.(map[string]interface{})
+			if !ok {
+				return nil, NOT_FOUND_ERROR
+			}
+
+			value, ok := data_map[string(sel)]
+			if !ok {
+				return nil, fmt.Errorf("JSON path not found: %v", string(sel))
+			}
+			data = value
+		case indexSelector:
+			array, ok := asArray(data)
+			if !ok {
+				return nil, NOT_FOUND_ERROR
+			}
+
+			i, ok := sel.normalize(len(array))
+			if !ok {
+				return nil, fmt.Errorf("JSON path not found: [%v]", int(sel))
+			}
+			data = array[i]
+		}
 	}
+
+	return data, nil
 }
@@ -1,6 +1,7 @@
 package jsonpath
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -122,3 +123,88 @@ func Test_JSONPath_GetString(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, *out, test)
 }
+
+var storeJSON = `{
+  "store": {
+    "book": [
+      { "title": "A", "price": 8, "tags": ["x"] },
+      { "title": "B", "price": 12 },
+      { "title": "C", "price": 5, "isbn": "1" }
+    ],
+    "bicycle": { "price": 20 }
+  },
+  "limit": 10,
+  "key.with.dots": 1,
+  "a b": 2
+}`
+
+func getStore(t *testing.T, raw string) interface{} {
+	var store interface{}
+	assert.NoError(t, json.Unmarshal([]byte(storeJSON), &store))
+
+	path, err := NewPath(raw)
+	assert.NoError(t, err)
+
+	out, err := path.Get(store)
+	assert.NoError(t, err)
+
+	return out
+}
+
+func Test_JSONPath_Get_Brackets(t *testing.T) {
+	assert.Equal(t, 1.0, getStore(t, "$['key.with.dots']"))
+	assert.Equal(t, 2.0, getStore(t, `$["a b"]`))
+	assert.Equal(t, "B", getStore(t, "$['store']['book'][1]['title']"))
+}
+
+func Test_JSONPath_Get_Indices(t *testing.T) {
+	assert.Equal(t, "A", getStore(t, "$.store.book[0].title"))
+	assert.Equal(t, "C", getStore(t, "$.store.book[-1].title"))
+	assert.Equal(t, []interface{}{"A", "C"}, getStore(t, "$.store.book[0,-1].title"))
+}
+
+func Test_JSONPath_Get_Index_NotFound(t *testing.T) {
+	path, err := NewPath("$.a[3]")
+	assert.NoError(t, err)
+
+	_, err = path.Get(map[string]interface{}{"a": []interface{}{1, 2}})
+	assert.Equal(t, "JSON path not found: [3]", err.Error())
+
+	_, err = path.Get(map[string]interface{}{"a": "not an array"})
+	assert.Equal(t, NOT_FOUND_ERROR, err)
+}
+
+func Test_JSONPath_Get_Slices(t *testing.T) {
+	assert.Equal(t, []interface{}{"B", "C"}, getStore(t, "$.store.book[1:].title"))
+	assert.Equal(t, []interface{}{"A"}, getStore(t, "$.store.book[:1].title"))
+	assert.Equal(t, []interface{}{"B", "C"}, getStore(t, "$.store.book[-2:].title"))
+	assert.Equal(t, []interface{}{"A", "C"}, getStore(t, "$.store.book[::2].title"))
+	assert.Equal(t, []interface{}{"C", "B", "A"}, getStore(t, "$.store.book[::-1].title"))
+	assert.Equal(t, []interface{}{}, getStore(t, "$.store.book[5:].title"))
+}
+
+func Test_JSONPath_Get_Wildcards(t *testing.T) {
+	assert.Equal(t, []interface{}{8.0, 12.0, 5.0}, getStore(t, "$.store.book[*].price"))
+
+	// Object values are in key order
+	assert.Equal(t, []interface{}{20.0, 8.0, 12.0, 5.0}, getStore(t, "$.store.*..price"))
+}
+
+func Test_JSONPath_Get_Recursive_Descent(t *testing.T) {
+	assert.Equal(t, []interface{}{20.0, 8.0, 12.0, 5.0}, getStore(t, "$..price"))
+	assert.Equal(t, []interface{}{"x"}, getStore(t, "$..tags[0]"))
+	assert.Equal(t, []interface{}{}, getStore(t, "$..missing"))
+}
+
+func Test_JSONPath_Get_Filters(t *testing.T) {
+	assert.Equal(t, []interface{}{"A", "C"}, getStore(t, "$.store.book[?(@.price < 10)].title"))
+	assert.Equal(t, []interface{}{"B"}, getStore(t, "$.store.book[?(@.price >= 10 && @.title != 'A')].title"))
+	assert.Equal(t, []interface{}{"A", "B"}, getStore(t, "$.store.book[?(@.title == 'A' || !(@.price < 10))].title"))
+	assert.Equal(t, []interface{}{"C"}, getStore(t, "$.store.book[?(@.isbn)].title"))
+	assert.Equal(t, []interface{}{"A"}, getStore(t, "$.store.book[?(@.tags[0] == 'x')].title"))
+	assert.Equal(t, []interface{}{"B"}, getStore(t, "$.store.book[?(@.price > $.limit)].title"))
+	assert.Equal(t, []interface{}{"A", "B", "C"}, getStore(t, "$..book[?(@.title > 'A' || @.price == 8)].title"))
+
+	// Filters also select from the values of an object
+	assert.Equal(t, []interface{}{map[string]interface{}{"price": 20.0}}, getStore(t, "$.store[?(@.price)]"))
+}
@@ -55,3 +55,100 @@ func Test_JSONPath_Parsing(t *testing.T) {
 	assert.Equal(t, pathstr.path[1], "b")
 	assert.Equal(t, pathstr.path[2], "c")
 }
+
+func Test_JSONPath_Parse_Segments(t *testing.T) {
+	out, err := ParseSegments(`$.a['b.c'][0,-1]..d[*][?(@.e > 1)]`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "['b.c']", "[0,-1]", "..d", "*", "[?(@.e > 1)]"}, out)
+
+	// ParsePathString only splits on "."
+	out, err = ParsePathString("$.a[0]")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a[0]"}, out)
+
+	out, err = ParseSegments("$.a[0]")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "[0]"}, out)
+}
+
+func Test_JSONPath_String_RoundTrip(t *testing.T) {
+	paths := map[string]string{
+		"$":                                  "$",
+		"$.a.b":                              "$.a.b",
+		"$['key.with.dots']":                 "$['key.with.dots']",
+		`$["a b"]`:                           "$['a b']",
+		`$['it\'s']`:                         `$['it\'s']`,
+		"$['plain']":                         "$.plain",
+		"$.a[0,-1]":                          "$.a[0,-1]",
+		"$.a[1:5:2]":                         "$.a[1:5:2]",
+		"$.a[:2]":                            "$.a[:2]",
+		"$.a[::-1]":                          "$.a[::-1]",
+		"$.a[*]":                             "$.a.*",
+		"$.a.*":                              "$.a.*",
+		"$..a":                               "$..a",
+		"$..[0]":                             "$..[0]",
+		"$..*":                               "$..*",
+		"$.a[?(@.x>1)]":                      "$.a[?(@.x > 1)]",
+		"$.a[?@.x == 'y']":                   "$.a[?(@.x == 'y')]",
+		`$.a[?(@.x && (@.y || !@.z))]`:       "$.a[?(@.x && (@.y || !@.z))]",
+		`$.a[?(@['b c'] != "d" || @.e<=2)]`:  "$.a[?(@['b c'] != 'd' || @.e <= 2)]",
+		"$.a[?(@.price < $.limit)].name":     "$.a[?(@.price < $.limit)].name",
+		"$.a[?(@.b == true && @.c == null)]": "$.a[?(@.b == true && @.c == null)]",
+	}
+
+	for raw, str := range paths {
+		path, err := NewPath(raw)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, str, path.String(), raw)
+
+		marshalled, err := json.Marshal(path)
+		assert.NoError(t, err)
+
+		var unmarshalled Path
+		assert.NoError(t, json.Unmarshal(marshalled, &unmarshalled), raw)
+		assert.Equal(t, str, unmarshalled.String(), raw)
+	}
+}
+
+func Test_JSONPath_Parse_Errors(t *testing.T) {
+	errors := map[string]string{
+		"a.b":            `Bad JSON path at 0 in "a.b": must start with $`,
+		"$.":             `Bad JSON path at 2 in "$.": expected name after .`,
+		"$..":            `Bad JSON path at 3 in "$..": expected name, * or [ after ..`,
+		"$.a[":           `Bad JSON path at 4 in "$.a[": expected selector, got end of path`,
+		"$.a[0":          `Bad JSON path at 5 in "$.a[0": expected ] to close [`,
+		"$.a[0 1]":       `Bad JSON path at 6 in "$.a[0 1]": expected , or ] but got "1"`,
+		"$['a":           `Bad JSON path at 2 in "$['a": unterminated string`,
+		"$.a[1:2:0]":     `Bad JSON path at 8 in "$.a[1:2:0]": slice step cannot be 0`,
+		"$.a[?(@.x >)]":  `Bad JSON path at 11 in "$.a[?(@.x >)]": unexpected ")" in filter`,
+		"$.a[?(@.x > 1]": `Bad JSON path at 13 in "$.a[?(@.x > 1]": expected ), got "]"`,
+		"$.a[?(1)]":      `Bad JSON path at 6 in "$.a[?(1)]": expected a path or comparison`,
+		"$.a[?(@.b[*] > 1)]": `Bad JSON path at 6 in "$.a[?(@.b[*] > 1)]": ` +
+			`cannot compare @.b.*, it may select many values`,
+		"$.{{a}}": `Bad JSON path at 0 in "$.{{a}}": contains string interpolation`,
+	}
+
+	for raw, message := range errors {
+		_, err := NewPath(raw)
+		if assert.Error(t, err, raw) {
+			assert.Equal(t, message, err.Error())
+		}
+	}
+}
+
+func Test_JSONPath_IsDefinite(t *testing.T) {
+	for raw, definite := range map[string]bool{
+		"$":           true,
+		"$.a['b'][0]": true,
+		"$.a[-1]":     true,
+		"$.a[0,1]":    false,
+		"$.a[0:1]":    false,
+		"$.a.*":       false,
+		"$..a":        false,
+		"$.a[?(@.b)]": false,
+	} {
+		path, err := NewPath(raw)
+		assert.NoError(t, err)
+		assert.Equal(t, definite, path.IsDefinite(), raw)
+	}
+}
@@ -75,3 +75,70 @@ func Test_JSONPath_Set_Overwrite(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, "s", out)
 }
+
+func Test_JSONPath_CanSet(t *testing.T) {
+	path, err := NewPath("$.a.b")
+	assert.NoError(t, err)
+
+	assert.True(t, path.CanSet(map[string]interface{}{}))
+	assert.True(t, path.CanSet(map[string]interface{}{"a": map[string]interface{}{"b": "overwritten"}}))
+	assert.False(t, path.CanSet(map[string]interface{}{"a": "not an object"}))
+	assert.False(t, path.CanSet("not an object"))
+
+	assert.True(t, NullPath().CanSet("anything"))
+}
+
+func Test_JSONPath_NullPath(t *testing.T) {
+	path := NullPath()
+	assert.True(t, path.IsNull())
+	assert.True(t, path.IsRoot())
+
+	raw, err := path.MarshalJSON()
+	assert.NoError(t, err)
+	assert.Equal(t, "null", string(raw))
+
+	var nilPath *Path
+	assert.False(t, nilPath.IsNull())
+}
+
+func Test_JSONPath_Set_Does_Not_Change_Input(t *testing.T) {
+	inner := map[string]interface{}{"b": "c"}
+	test := map[string]interface{}{"a": inner}
+
+	path, err := NewPath("$.a.d")
+	assert.NoError(t, err)
+
+	setted, err := path.Set(test, "e")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": "c", "d": "e"}}, setted)
+	assert.Equal(t, map[string]interface{}{"b": "c"}, inner)
+}
+
+func Test_JSONPath_Set_Index(t *testing.T) {
+	items := []interface{}{"x", map[string]interface{}{"b": "c"}}
+	test := map[string]interface{}{"a": items}
+
+	path, err := NewPath("$.a[-1]['d e']")
+	assert.NoError(t, err)
+
+	setted, err := path.Set(test, "f")
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"x", map[string]interface{}{"b": "c", "d e": "f"}}, setted["a"])
+	assert.Equal(t, []interface{}{"x", map[string]interface{}{"b": "c"}}, items)
+
+	path, err = NewPath("$.a[2]")
+	assert.NoError(t, err)
+
+	_, err = path.Set(test, "f")
+	assert.Error(t, err)
+	assert.False(t, path.CanSet(test))
+}
+
+func Test_JSONPath_Set_Indefinite(t *testing.T) {
+	path, err := NewPath("$.a[*]")
+	assert.NoError(t, err)
+
+	_, err = path.Set(map[string]interface{}{"a": []interface{}{1}}, "b")
+	assert.Error(t, err)
+	assert.False(t, path.CanSet(map[string]interface{}{}))
+}
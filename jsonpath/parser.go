@@ -0,0 +1,317 @@
+package jsonpath
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The subset of JSONPath allowed by the Amazon States Language:
+//
+//	$.a.b             child names
+//	$['a.b']["c d"]   quoted names
+//	$.a[0,-1]         indices, negative from the end
+//	$.a[1:5:2]        slices [start:end:step]
+//	$.a[*] $.a.*      wildcards
+//	$..a $..[0]       recursive descent
+//	$.a[?(@.x > 1)]   filters with == != < <= > >= && || ! and parentheses
+
+type pathParser struct {
+	expr     string
+	pos      int
+	inFilter bool // names end at operators and spaces inside a filter
+}
+
+func (p *pathParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Bad JSON path at %v in %q: %v", p.pos, p.expr, fmt.Sprintf(format, args...))
+}
+
+func (p *pathParser) rest() string {
+	return p.expr[p.pos:]
+}
+
+func (p *pathParser) done() bool {
+	return p.pos >= len(p.expr)
+}
+
+func (p *pathParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.expr[p.pos]
+}
+
+func (p *pathParser) skipSpace() {
+	for !p.done() && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t' || p.expr[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+// expect consumes c or fails with what was expected
+func (p *pathParser) expect(c byte, what string) error {
+	p.skipSpace()
+	if p.peek() != c {
+		if p.done() {
+			return p.errorf("expected %v, got end of path", what)
+		}
+		return p.errorf("expected %v, got %q", what, string(p.peek()))
+	}
+	p.pos++
+	return nil
+}
+
+// parsePath parses a whole path string starting with "$"
+func parsePath(expr string) ([]*segment, error) {
+	p := &pathParser{expr: expr}
+
+	// required by string interpolation
+	if strings.Contains(expr, "{{") && strings.Contains(expr, "}}") {
+		return nil, p.errorf("contains string interpolation")
+	}
+
+	if p.peek() != '$' {
+		return nil, p.errorf("must start with $")
+	}
+	p.pos++
+
+	segments, err := p.parseSegments()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.done() {
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+
+	return segments, nil
+}
+
+// parseSegments reads segments until one cannot start
+func (p *pathParser) parseSegments() ([]*segment, error) {
+	segments := []*segment{}
+
+	for {
+		var seg *segment
+		var err error
+
+		switch {
+		case strings.HasPrefix(p.rest(), ".."):
+			p.pos += 2
+			seg, err = p.parseDescendant()
+		case p.peek() == '.':
+			p.pos++
+			seg, err = p.parseDotSegment()
+		case p.peek() == '[':
+			seg, err = p.parseBrackets()
+		default:
+			return segments, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, seg)
+	}
+}
+
+// parseDescendant reads what follows "..", a name, * or brackets
+func (p *pathParser) parseDescendant() (*segment, error) {
+	var seg *segment
+	var err error
+
+	switch {
+	case p.peek() == '[':
+		seg, err = p.parseBrackets()
+	case p.done() || p.peek() == '.':
+		return nil, p.errorf("expected name, * or [ after ..")
+	default:
+		seg, err = p.parseDotSegment()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	seg.descendant = true
+	return seg, nil
+}
+
+// parseDotSegment reads what follows ".", a name or *
+func (p *pathParser) parseDotSegment() (*segment, error) {
+	if p.peek() == '*' {
+		p.pos++
+		return &segment{selectors: []selector{wildcardSelector{}}}, nil
+	}
+
+	start := p.pos
+	for !p.done() && !p.endsName(p.expr[p.pos]) {
+		p.pos++
+	}
+
+	if p.pos == start {
+		return nil, p.errorf("expected name after .")
+	}
+
+	return &segment{selectors: []selector{nameSelector(p.expr[start:p.pos])}}, nil
+}
+
+// endsName is true for the characters that end a name in dot notation
+func (p *pathParser) endsName(c byte) bool {
+	if c == '.' || c == '[' {
+		return true
+	}
+	return p.inFilter && strings.IndexByte(" \t\n]),=!<>&|", c) >= 0
+}
+
+// parseBrackets reads a comma separated list of selectors in [], or a filter
+func (p *pathParser) parseBrackets() (*segment, error) {
+	p.pos++ // [
+	seg := &segment{}
+
+	for {
+		p.skipSpace()
+
+		sel, err := p.parseSelector()
+		if err != nil {
+			return nil, err
+		}
+		seg.selectors = append(seg.selectors, sel)
+
+		p.skipSpace()
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return seg, nil
+		default:
+			if p.done() {
+				return nil, p.errorf("expected ] to close [")
+			}
+			return nil, p.errorf("expected , or ] but got %q", string(p.peek()))
+		}
+	}
+}
+
+func (p *pathParser) parseSelector() (selector, error) {
+	c := p.peek()
+
+	switch {
+	case c == '\'' || c == '"':
+		name, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return nameSelector(name), nil
+	case c == '*':
+		p.pos++
+		return wildcardSelector{}, nil
+	case c == '?':
+		p.pos++
+		return p.parseFilter()
+	case c == ':' || c == '-' || isDigit(c):
+		return p.parseIndexOrSlice()
+	case p.done():
+		return nil, p.errorf("expected selector, got end of path")
+	}
+
+	return nil, p.errorf("unexpected %q in []", string(c))
+}
+
+// parseString reads a single or double quoted string, \', \", \\ and \/ are unescaped
+func (p *pathParser) parseString() (string, error) {
+	start := p.pos
+	quote := p.expr[p.pos]
+	p.pos++
+
+	var str strings.Builder
+	for !p.done() {
+		c := p.expr[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.expr):
+			next := p.expr[p.pos+1]
+			switch next {
+			case '\'', '"', '\\', '/':
+				str.WriteByte(next)
+			default:
+				return "", p.errorf("unknown escape \\%v", string(next))
+			}
+			p.pos += 2
+		case c == quote:
+			p.pos++
+			return str.String(), nil
+		default:
+			str.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	p.pos = start
+	return "", p.errorf("unterminated string")
+}
+
+// parseIndexOrSlice reads an index e.g. -1 or a slice e.g. 1:5:2, each part of a slice is optional
+func (p *pathParser) parseIndexOrSlice() (selector, error) {
+	start, err := p.parseOptionalInt()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.peek() != ':' {
+		if start == nil {
+			return nil, p.errorf("expected index")
+		}
+		return indexSelector(*start), nil
+	}
+	p.pos++
+
+	slice := sliceSelector{start: start}
+	if slice.end, err = p.parseOptionalInt(); err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.peek() == ':' {
+		p.pos++
+		stepPos := p.pos
+		if slice.step, err = p.parseOptionalInt(); err != nil {
+			return nil, err
+		}
+		if slice.step != nil && *slice.step == 0 {
+			p.pos = stepPos
+			return nil, p.errorf("slice step cannot be 0")
+		}
+	}
+
+	return slice, nil
+}
+
+func (p *pathParser) parseOptionalInt() (*int, error) {
+	p.skipSpace()
+	start := p.pos
+
+	if p.peek() == '-' {
+		p.pos++
+	}
+	for !p.done() && isDigit(p.expr[p.pos]) {
+		p.pos++
+	}
+
+	if p.pos == start {
+		return nil, nil
+	}
+
+	str := p.expr[start:p.pos]
+	i, err := strconv.Atoi(str)
+	if err != nil {
+		p.pos = start
+		return nil, p.errorf("bad index %q", str)
+	}
+
+	return &i, nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
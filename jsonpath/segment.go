@@ -0,0 +1,268 @@
+package jsonpath
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// segment selects values from each value it is applied to,
+// a descendant segment ("..") also from all of their descendants
+type segment struct {
+	descendant bool
+	selectors  []selector
+}
+
+type selector interface {
+	// selectFrom appends the values selected from value to selected, root is the whole document for filters
+	selectFrom(selected []interface{}, value interface{}, root interface{}) []interface{}
+	String() string
+}
+
+type nameSelector string
+
+type indexSelector int
+
+type sliceSelector struct {
+	start, end, step *int
+}
+
+type wildcardSelector struct{}
+
+// definite is true for a single name or index, it selects at most one value
+func (seg *segment) definite() bool {
+	if seg.descendant || len(seg.selectors) != 1 {
+		return false
+	}
+
+	switch seg.selectors[0].(type) {
+	case nameSelector, indexSelector:
+		return true
+	}
+	return false
+}
+
+// apply returns the values selected from each of values
+func (seg *segment) apply(values []interface{}, root interface{}) []interface{} {
+	if seg.descendant {
+		values = descendants(values)
+	}
+
+	selected := []interface{}{}
+	for _, value := range values {
+		for _, sel := range seg.selectors {
+			selected = sel.selectFrom(selected, value, root)
+		}
+	}
+	return selected
+}
+
+// String returns the notation of the segment, e.g. "a", "*", "['a b']", "[0,1]" or "..a"
+func (seg *segment) String() string {
+	notation := ""
+	if len(seg.selectors) == 1 {
+		switch sel := seg.selectors[0].(type) {
+		case nameSelector:
+			if isPlainName(string(sel)) {
+				notation = string(sel)
+			}
+		case wildcardSelector:
+			notation = "*"
+		}
+	}
+
+	if notation == "" {
+		strs := []string{}
+		for _, sel := range seg.selectors {
+			strs = append(strs, sel.String())
+		}
+		notation = "[" + strings.Join(strs, ",") + "]"
+	}
+
+	if seg.descendant {
+		return ".." + notation
+	}
+	return notation
+}
+
+// segmentsString joins the notations of segments, names and * after a "."
+func segmentsString(segments []*segment) string {
+	var b strings.Builder
+	for _, seg := range segments {
+		notation := seg.String()
+		if !strings.HasPrefix(notation, "[") && !strings.HasPrefix(notation, "..") {
+			b.WriteByte('.')
+		}
+		b.WriteString(notation)
+	}
+	return b.String()
+}
+
+// isPlainName is true if name can be written in dot notation anywhere, including in a filter
+func isPlainName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, " \t\n.[]()'\"=!<>&|,*{}\\")
+}
+
+// descendants returns values and all values nested in them, parents before their children
+func descendants(values []interface{}) []interface{} {
+	all := []interface{}{}
+	for _, value := range values {
+		all = append(all, value)
+		all = append(all, descendants(children(value))...)
+	}
+	return all
+}
+
+// children returns the elements of an array or the values of an object ordered by key
+func children(value interface{}) []interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		values := make([]interface{}, 0, len(m))
+		for _, k := range keys {
+			values = append(values, m[k])
+		}
+		return values
+	}
+
+	array, _ := asArray(value)
+	return array
+}
+
+// asArray returns the elements of a JSON array, or any Go slice
+func asArray(value interface{}) ([]interface{}, bool) {
+	if array, ok := value.([]interface{}); ok {
+		return array, true
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return nil, false
+	}
+
+	array := make([]interface{}, v.Len())
+	for i := range array {
+		array[i] = v.Index(i).Interface()
+	}
+	return array, true
+}
+
+// SELECTORS
+
+func (name nameSelector) selectFrom(selected []interface{}, value interface{}, _ interface{}) []interface{} {
+	if m, ok := value.(map[string]interface{}); ok {
+		if v, ok := m[string(name)]; ok {
+			return append(selected, v)
+		}
+	}
+	return selected
+}
+
+func (name nameSelector) String() string {
+	return quote(string(name))
+}
+
+// quote returns str as a single quoted string
+func quote(str string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(str) + "'"
+}
+
+func (index indexSelector) selectFrom(selected []interface{}, value interface{}, _ interface{}) []interface{} {
+	array, ok := asArray(value)
+	if !ok {
+		return selected
+	}
+
+	if i, ok := index.normalize(len(array)); ok {
+		return append(selected, array[i])
+	}
+	return selected
+}
+
+// normalize returns the index in an array of length, counting back from the end if negative
+func (index indexSelector) normalize(length int) (int, bool) {
+	i := int(index)
+	if i < 0 {
+		i += length
+	}
+	return i, i >= 0 && i < length
+}
+
+func (index indexSelector) String() string {
+	return fmt.Sprintf("%v", int(index))
+}
+
+func (slice sliceSelector) selectFrom(selected []interface{}, value interface{}, _ interface{}) []interface{} {
+	array, ok := asArray(value)
+	if !ok {
+		return selected
+	}
+
+	length := len(array)
+	step := 1
+	if slice.step != nil {
+		step = *slice.step
+	}
+
+	normalize := func(i *int, otherwise int, min int, max int) int {
+		if i == nil {
+			return otherwise
+		}
+
+		n := *i
+		if n < 0 {
+			n += length
+		}
+		if n < min {
+			return min
+		}
+		if n > max {
+			return max
+		}
+		return n
+	}
+
+	if step > 0 {
+		start := normalize(slice.start, 0, 0, length)
+		end := normalize(slice.end, length, 0, length)
+		for i := start; i < end; i += step {
+			selected = append(selected, array[i])
+		}
+	} else {
+		start := normalize(slice.start, length-1, -1, length-1)
+		end := normalize(slice.end, -1, -1, length-1)
+		for i := start; i > end; i += step {
+			selected = append(selected, array[i])
+		}
+	}
+
+	return selected
+}
+
+func (slice sliceSelector) String() string {
+	str := func(i *int) string {
+		if i == nil {
+			return ""
+		}
+		return fmt.Sprintf("%v", *i)
+	}
+
+	s := str(slice.start) + ":" + str(slice.end)
+	if slice.step != nil {
+		s += ":" + str(slice.step)
+	}
+	return s
+}
+
+func (wildcardSelector) selectFrom(selected []interface{}, value interface{}, _ interface{}) []interface{} {
+	return append(selected, children(value)...)
+}
+
+func (wildcardSelector) String() string {
+	return "*"
+}
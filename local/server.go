@@ -0,0 +1,738 @@
+// Local AWS Step Functions API server executing State Machines with the machine package
+package local
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/coinbase/step/handler"
+	"github.com/coinbase/step/machine"
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// Server serves the AWS Step Functions JSON API, e.g. for an AWS SDK client with its Endpoint set to the server.
+// Task states whose Resource is a registered Lambda call the TaskHandler with their name,
+// other Resources use the Integrations.
+type Server struct {
+	Region    string
+	AccountID string
+
+	Integrations *state.Integrations
+
+	lock       sync.Mutex
+	lambdas    map[string]*handler.TaskHandlers // by function name
+	machines   map[string]*stateMachine         // by ARN
+	executions map[string]*startedExecution     // by ARN
+	started    []*startedExecution              // oldest first
+}
+
+type stateMachine struct {
+	arn        string
+	name       string
+	definition string
+	roleArn    string
+	created    time.Time
+}
+
+type startedExecution struct {
+	arn             string
+	name            string
+	stateMachineArn string
+	input           string
+	started         time.Time
+	running         *machine.RunningExecution
+}
+
+// apiError is returned as the "__type" and "message" of an error response
+type apiError struct {
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%v: %v", e.code, e.message)
+}
+
+// status is the HTTP status of the error response, only a failure of the server itself is not the request's fault
+func (e *apiError) status() int {
+	if e.code == "InternalFailure" {
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+// request is an SDK input shape, which validates its required members
+type request interface {
+	Validate() error
+}
+
+func NewServer() *Server {
+	return &Server{
+		Region:     "us-east-1",
+		AccountID:  "000000000000",
+		lambdas:    map[string]*handler.TaskHandlers{},
+		machines:   map[string]*stateMachine{},
+		executions: map[string]*startedExecution{},
+	}
+}
+
+// RegisterLambda sets the TaskHandlers of the Lambda function name, e.g. the handlers run.LambdaTasks would serve
+func (s *Server) RegisterLambda(name string, handlers *handler.TaskHandlers) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lambdas[name] = handlers
+}
+
+// ListenAndServe serves the API on the port until it fails
+func (s *Server) ListenAndServe(port int) error {
+	return http.ListenAndServe(fmt.Sprintf(":%v", port), s)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, &apiError{"SerializationException", err.Error()})
+		return
+	}
+
+	operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "AWSStepFunctions.")
+	output, err := s.call(operation, body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	raw, err := json.Marshal(shapeJSON(reflect.ValueOf(output)))
+	if err != nil {
+		writeError(w, &apiError{"InternalFailure", err.Error()})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.Write(raw)
+}
+
+// writeError writes the error response, an error without a code is a ValidationException of the request
+func writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		apiErr = &apiError{"ValidationException", err.Error()}
+	}
+
+	raw, _ := json.Marshal(map[string]string{"__type": apiErr.code, "message": apiErr.message})
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+	w.WriteHeader(apiErr.status())
+	w.Write(raw)
+}
+
+// shapeJSON returns the JSON value of an SDK shape as the AWS JSON protocol encodes it,
+// i.e. members named by their locationName, nil members left out and timestamps in epoch seconds.
+// Inputs need no conversion, json.Unmarshal matches the lowerCamel names to the fields
+func shapeJSON(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return shapeJSON(v.Elem())
+	case reflect.Slice:
+		list := []interface{}{}
+		for i := 0; i < v.Len(); i++ {
+			list = append(list, shapeJSON(v.Index(i)))
+		}
+		return list
+	case reflect.Map:
+		members := map[string]interface{}{}
+		for _, key := range v.MapKeys() {
+			members[fmt.Sprint(key.Interface())] = shapeJSON(v.MapIndex(key))
+		}
+		return members
+	case reflect.Struct:
+		if t, ok := v.Interface().(time.Time); ok {
+			return float64(t.UnixNano()) / float64(time.Second)
+		}
+
+		members := map[string]interface{}{}
+		for i := 0; i < v.NumField(); i++ {
+			field, value := v.Type().Field(i), v.Field(i)
+			if field.PkgPath != "" || field.Name == "_" {
+				continue
+			}
+
+			switch value.Kind() {
+			case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+				if value.IsNil() {
+					continue
+				}
+			}
+
+			name := field.Name
+			if locationName := field.Tag.Get("locationName"); locationName != "" {
+				name = locationName
+			}
+			members[name] = shapeJSON(value)
+		}
+		return members
+	}
+
+	return v.Interface()
+}
+
+// call decodes the input of the operation and returns its output
+func (s *Server) call(operation string, body []byte) (interface{}, error) {
+	decode := func(input request) error {
+		if len(bytes.TrimSpace(body)) > 0 {
+			if err := json.Unmarshal(body, input); err != nil {
+				return &apiError{"SerializationException", err.Error()}
+			}
+		}
+
+		if err := input.Validate(); err != nil {
+			return &apiError{"ValidationException", err.Error()}
+		}
+		return nil
+	}
+
+	switch operation {
+	case "CreateStateMachine":
+		var input sfn.CreateStateMachineInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.createStateMachine(&input)
+	case "UpdateStateMachine":
+		var input sfn.UpdateStateMachineInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.updateStateMachine(&input)
+	case "DescribeStateMachine":
+		var input sfn.DescribeStateMachineInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.describeStateMachine(&input)
+	case "StartExecution":
+		var input sfn.StartExecutionInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.startExecution(&input)
+	case "DescribeExecution":
+		var input sfn.DescribeExecutionInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.describeExecution(&input)
+	case "GetExecutionHistory":
+		var input sfn.GetExecutionHistoryInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.getExecutionHistory(&input)
+	case "ListExecutions":
+		var input sfn.ListExecutionsInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.listExecutions(&input)
+	case "StopExecution":
+		var input sfn.StopExecutionInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.stopExecution(&input)
+	case "SendTaskSuccess":
+		var input sfn.SendTaskSuccessInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.sendTaskSuccess(&input)
+	case "SendTaskFailure":
+		var input sfn.SendTaskFailureInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.sendTaskFailure(&input)
+	case "SendTaskHeartbeat":
+		var input sfn.SendTaskHeartbeatInput
+		if err := decode(&input); err != nil {
+			return nil, err
+		}
+		return s.sendTaskHeartbeat(&input)
+	}
+
+	return nil, &apiError{"UnknownOperationException", fmt.Sprintf("Unknown Operation %q", operation)}
+}
+
+//////
+// State Machines
+//////
+
+func (s *Server) createStateMachine(input *sfn.CreateStateMachineInput) (*sfn.CreateStateMachineOutput, error) {
+	name := to.Strs(input.Name)
+	if name == "" {
+		return nil, &apiError{"InvalidName", "Name is required"}
+	}
+
+	definition := to.Strs(input.Definition)
+	if err := validDefinition(definition); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	arn := fmt.Sprintf("arn:aws:states:%v:%v:stateMachine:%v", s.Region, s.AccountID, name)
+	if existing, ok := s.machines[arn]; ok {
+		// Creating the same State Machine again is idempotent
+		if existing.definition != definition || existing.roleArn != to.Strs(input.RoleArn) {
+			return nil, &apiError{"StateMachineAlreadyExists", fmt.Sprintf("State Machine Already Exists: %q", arn)}
+		}
+		return &sfn.CreateStateMachineOutput{StateMachineArn: &existing.arn, CreationDate: to.Timep(existing.created)}, nil
+	}
+
+	sm := &stateMachine{
+		arn:        arn,
+		name:       name,
+		definition: definition,
+		roleArn:    to.Strs(input.RoleArn),
+		created:    time.Now(),
+	}
+	s.machines[arn] = sm
+
+	return &sfn.CreateStateMachineOutput{StateMachineArn: &sm.arn, CreationDate: to.Timep(sm.created)}, nil
+}
+
+func (s *Server) updateStateMachine(input *sfn.UpdateStateMachineInput) (*sfn.UpdateStateMachineOutput, error) {
+	if input.Definition != nil {
+		if err := validDefinition(*input.Definition); err != nil {
+			return nil, err
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sm, err := s.findStateMachine(input.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Definition != nil {
+		sm.definition = *input.Definition
+	}
+
+	if input.RoleArn != nil {
+		sm.roleArn = *input.RoleArn
+	}
+
+	return &sfn.UpdateStateMachineOutput{UpdateDate: to.Timep(time.Now())}, nil
+}
+
+func (s *Server) describeStateMachine(input *sfn.DescribeStateMachineInput) (*sfn.DescribeStateMachineOutput, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sm, err := s.findStateMachine(input.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.DescribeStateMachineOutput{
+		StateMachineArn: to.Strp(sm.arn),
+		Name:            to.Strp(sm.name),
+		Definition:      to.Strp(sm.definition),
+		RoleArn:         to.Strp(sm.roleArn),
+		Status:          to.Strp("ACTIVE"),
+		CreationDate:    to.Timep(sm.created),
+	}, nil
+}
+
+func (s *Server) findStateMachine(arn *string) (*stateMachine, error) {
+	sm, ok := s.machines[to.Strs(arn)]
+	if !ok {
+		return nil, &apiError{"StateMachineDoesNotExist", fmt.Sprintf("State Machine Does Not Exist: %q", to.Strs(arn))}
+	}
+	return sm, nil
+}
+
+func validDefinition(definition string) error {
+	sm, err := machine.FromJSON([]byte(definition))
+	if err == nil {
+		err = sm.Validate()
+	}
+
+	if err != nil {
+		return &apiError{"InvalidDefinition", err.Error()}
+	}
+	return nil
+}
+
+//////
+// Executions
+//////
+
+func (s *Server) startExecution(input *sfn.StartExecutionInput) (*sfn.StartExecutionOutput, error) {
+	execInput := to.Strs(input.Input)
+	if execInput == "" {
+		execInput = "{}"
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(execInput), &parsed); err != nil {
+		return nil, &apiError{"InvalidExecutionInput", fmt.Sprintf("Input must be a JSON object: %v", err)}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	smRecord, err := s.findStateMachine(input.StateMachineArn)
+	if err != nil {
+		return nil, err
+	}
+
+	name := to.Strs(input.Name)
+	if name == "" {
+		name = *to.TimeUUID("execution-")
+	}
+
+	arn := fmt.Sprintf("arn:aws:states:%v:%v:execution:%v:%v", s.Region, s.AccountID, smRecord.name, name)
+	if _, ok := s.executions[arn]; ok {
+		return nil, &apiError{"ExecutionAlreadyExists", fmt.Sprintf("Execution Already Exists: %q", arn)}
+	}
+
+	sm, err := s.stateMachine(smRecord.definition)
+	if err != nil {
+		return nil, err
+	}
+
+	exec := &startedExecution{
+		arn:             arn,
+		name:            name,
+		stateMachineArn: smRecord.arn,
+		input:           execInput,
+		started:         time.Now(),
+		running:         sm.Start(machine.WithExecutionArn(context.Background(), arn), execInput),
+	}
+
+	s.executions[arn] = exec
+	s.started = append(s.started, exec)
+
+	return &sfn.StartExecutionOutput{ExecutionArn: to.Strp(arn), StartDate: to.Timep(exec.started)}, nil
+}
+
+// stateMachine parses the definition with the handlers of the registered Lambdas and the Integrations
+func (s *Server) stateMachine(definition string) (*machine.StateMachine, error) {
+	sm, err := machine.FromJSON([]byte(definition))
+	if err != nil {
+		return nil, &apiError{"InvalidDefinition", err.Error()}
+	}
+
+	sm.Integrations = s.Integrations
+
+	for name, task := range sm.Tasks() {
+		handlers, ok := s.lambdas[functionName(to.Strs(task.Resource))]
+		if !ok {
+			continue
+		}
+
+		if _, ok := (*handlers)[name]; !ok {
+			continue
+		}
+
+		taskHandler, err := handler.CreateHandler(handlers)
+		if err != nil {
+			return nil, &apiError{"InvalidDefinition", err.Error()}
+		}
+
+		if err := sm.SetTaskHandler(name, taskHandler); err != nil {
+			return nil, &apiError{"InvalidDefinition", err.Error()}
+		}
+	}
+
+	return sm, nil
+}
+
+// functionName returns the function name of a Lambda ARN, or the Resource
+func functionName(resource string) string {
+	parts := strings.Split(resource, ":")
+	if len(parts) >= 7 && parts[5] == "function" {
+		return parts[6]
+	}
+	return resource
+}
+
+// describeExecutionOutput is the DescribeExecution response with the Error and Cause of an Execution that did not succeed,
+// which sfn.DescribeExecutionOutput of this SDK version does not have
+type describeExecutionOutput struct {
+	_ struct{} `type:"structure"`
+
+	ExecutionArn    *string    `locationName:"executionArn" type:"string"`
+	StateMachineArn *string    `locationName:"stateMachineArn" type:"string"`
+	Name            *string    `locationName:"name" type:"string"`
+	Input           *string    `locationName:"input" type:"string"`
+	Output          *string    `locationName:"output" type:"string"`
+	Error           *string    `locationName:"error" type:"string"`
+	Cause           *string    `locationName:"cause" type:"string"`
+	StartDate       *time.Time `locationName:"startDate" type:"timestamp"`
+	StopDate        *time.Time `locationName:"stopDate" type:"timestamp"`
+	Status          *string    `locationName:"status" type:"string"`
+}
+
+func (s *Server) describeExecution(input *sfn.DescribeExecutionInput) (*describeExecutionOutput, error) {
+	s.lock.Lock()
+	exec, err := s.findExecution(input.ExecutionArn)
+	s.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	output := &describeExecutionOutput{
+		ExecutionArn:    to.Strp(exec.arn),
+		StateMachineArn: to.Strp(exec.stateMachineArn),
+		Name:            to.Strp(exec.name),
+		Input:           to.Strp(exec.input),
+		StartDate:       to.Timep(exec.started),
+		Status:          to.Strp("RUNNING"),
+	}
+
+	history := exec.history()
+	if len(history) == 0 {
+		return output, nil
+	}
+
+	last := history[len(history)-1]
+	output.Status = to.Strp(exec.status())
+	if *output.Status != "RUNNING" {
+		output.StopDate = last.Timestamp
+	}
+
+	switch {
+	case last.ExecutionSucceededEventDetails != nil:
+		output.Output = last.ExecutionSucceededEventDetails.Output
+	case last.ExecutionFailedEventDetails != nil:
+		output.Error, output.Cause = last.ExecutionFailedEventDetails.Error, last.ExecutionFailedEventDetails.Cause
+	case last.ExecutionTimedOutEventDetails != nil:
+		output.Error, output.Cause = last.ExecutionTimedOutEventDetails.Error, last.ExecutionTimedOutEventDetails.Cause
+	case last.ExecutionAbortedEventDetails != nil:
+		output.Error, output.Cause = last.ExecutionAbortedEventDetails.Error, last.ExecutionAbortedEventDetails.Cause
+	}
+
+	return output, nil
+}
+
+func (s *Server) getExecutionHistory(input *sfn.GetExecutionHistoryInput) (*sfn.GetExecutionHistoryOutput, error) {
+	s.lock.Lock()
+	exec, err := s.findExecution(input.ExecutionArn)
+	s.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*sfn.HistoryEvent{}
+	for _, event := range exec.history() {
+		event := event.HistoryEvent
+		events = append(events, &event)
+	}
+
+	if input.ReverseOrder != nil && *input.ReverseOrder {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	from, end, nextToken, err := page(len(events), input.MaxResults, input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.GetExecutionHistoryOutput{Events: events[from:end], NextToken: nextToken}, nil
+}
+
+func (s *Server) listExecutions(input *sfn.ListExecutionsInput) (*sfn.ListExecutionsOutput, error) {
+	s.lock.Lock()
+	_, err := s.findStateMachine(input.StateMachineArn)
+	started := append([]*startedExecution{}, s.started...)
+	s.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	items := []*sfn.ExecutionListItem{}
+	for _, exec := range started {
+		if exec.stateMachineArn != *input.StateMachineArn {
+			continue
+		}
+
+		item := exec.listItem()
+		if input.StatusFilter != nil && *input.StatusFilter != *item.Status {
+			continue
+		}
+		items = append(items, item)
+	}
+
+	// Newest first like AWS
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+
+	from, end, nextToken, err := page(len(items), input.MaxResults, input.NextToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sfn.ListExecutionsOutput{Executions: items[from:end], NextToken: nextToken}, nil
+}
+
+func (s *Server) stopExecution(input *sfn.StopExecutionInput) (*sfn.StopExecutionOutput, error) {
+	s.lock.Lock()
+	exec, err := s.findExecution(input.ExecutionArn)
+	s.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	exec.running.Stop()
+	return &sfn.StopExecutionOutput{StopDate: to.Timep(time.Now())}, nil
+}
+
+func (s *Server) findExecution(arn *string) (*startedExecution, error) {
+	exec, ok := s.executions[to.Strs(arn)]
+	if !ok {
+		return nil, &apiError{"ExecutionDoesNotExist", fmt.Sprintf("Execution Does Not Exist: %q", to.Strs(arn))}
+	}
+	return exec, nil
+}
+
+func (e *startedExecution) history() []machine.HistoryEvent {
+	if exec := e.running.Execution(); exec != nil {
+		return exec.History()
+	}
+	return []machine.HistoryEvent{}
+}
+
+func (e *startedExecution) status() string {
+	if exec := e.running.Execution(); exec != nil {
+		return exec.Status()
+	}
+	return "RUNNING"
+}
+
+func (e *startedExecution) listItem() *sfn.ExecutionListItem {
+	item := &sfn.ExecutionListItem{
+		ExecutionArn:    to.Strp(e.arn),
+		StateMachineArn: to.Strp(e.stateMachineArn),
+		Name:            to.Strp(e.name),
+		StartDate:       to.Timep(e.started),
+		Status:          to.Strp(e.status()),
+	}
+
+	if history := e.history(); *item.Status != "RUNNING" && len(history) > 0 {
+		item.StopDate = history[len(history)-1].Timestamp
+	}
+
+	return item
+}
+
+// page returns the range of a page of total results, with the token of the next page
+func page(total int, maxResults *int64, token *string) (int, int, *string, error) {
+	from := 0
+	if token != nil && *token != "" {
+		offset, err := strconv.Atoi(*token)
+		if err != nil || offset < 0 || offset > total {
+			return 0, 0, nil, &apiError{"InvalidToken", fmt.Sprintf("Invalid Token %q", *token)}
+		}
+		from = offset
+	}
+
+	size := 100
+	if maxResults != nil && *maxResults > 0 {
+		size = int(*maxResults)
+	}
+
+	end := from + size
+	if end >= total {
+		return from, total, nil, nil
+	}
+
+	nextToken := strconv.Itoa(end)
+	return from, end, &nextToken, nil
+}
+
+//////
+// Task Tokens
+//////
+
+func (s *Server) sendTaskSuccess(input *sfn.SendTaskSuccessInput) (*sfn.SendTaskSuccessOutput, error) {
+	var output interface{}
+	if err := json.Unmarshal([]byte(to.Strs(input.Output)), &output); err != nil {
+		return nil, &apiError{"InvalidOutput", fmt.Sprintf("Output must be JSON: %v", err)}
+	}
+
+	running, err := s.waitingFor(to.Strs(input.TaskToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := running.SendTaskSuccess(*input.TaskToken, output); err != nil {
+		return nil, &apiError{"TaskDoesNotExist", err.Error()}
+	}
+	return &sfn.SendTaskSuccessOutput{}, nil
+}
+
+func (s *Server) sendTaskFailure(input *sfn.SendTaskFailureInput) (*sfn.SendTaskFailureOutput, error) {
+	running, err := s.waitingFor(to.Strs(input.TaskToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := running.SendTaskFailure(*input.TaskToken, to.Strs(input.Error), to.Strs(input.Cause)); err != nil {
+		return nil, &apiError{"TaskDoesNotExist", err.Error()}
+	}
+	return &sfn.SendTaskFailureOutput{}, nil
+}
+
+func (s *Server) sendTaskHeartbeat(input *sfn.SendTaskHeartbeatInput) (*sfn.SendTaskHeartbeatOutput, error) {
+	running, err := s.waitingFor(to.Strs(input.TaskToken))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := running.SendTaskHeartbeat(*input.TaskToken); err != nil {
+		return nil, &apiError{"TaskTimedOut", err.Error()}
+	}
+	return &sfn.SendTaskHeartbeatOutput{}, nil
+}
+
+// waitingFor returns the running execution with a Task waiting for token
+func (s *Server) waitingFor(token string) (*machine.RunningExecution, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, exec := range s.started {
+		for _, waiting := range exec.running.Tokens() {
+			if waiting == token {
+				return exec.running, nil
+			}
+		}
+	}
+
+	return nil, &apiError{"TaskDoesNotExist", fmt.Sprintf("Task Token %q is not waiting", token)}
+}
@@ -0,0 +1,267 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/coinbase/step/execution"
+	"github.com/coinbase/step/handler"
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+var helloMachine = `{
+  "StartAt": "Hello",
+  "States": {
+    "Hello": {
+      "Type": "TaskFn",
+      "Resource": "arn:aws:lambda:us-east-1:000000000000:function:hello",
+      "Next": "Approval"
+    },
+    "Approval": {
+      "Type": "Task",
+      "Resource": "arn:aws:states:::sqs:sendMessage.waitForTaskToken",
+      "Parameters": { "QueueUrl": "approvals", "MessageBody.$": "$$.Task.Token" },
+      "ResultPath": "$.approval",
+      "End": true
+    }
+  }
+}`
+
+type hello struct {
+	Name     string      `json:"name"`
+	Greeting string      `json:"greeting,omitempty"`
+	Approval interface{} `json:"approval,omitempty"`
+}
+
+// testServer returns an SFN client of a local Server with the hello Lambda and fake services
+func testServer(t *testing.T) (*sfn.SFN, *state.FakeServices, func()) {
+	server := NewServer()
+	server.RegisterLambda("hello", &handler.TaskHandlers{
+		"Hello": func(_ context.Context, input *hello) (*hello, error) {
+			input.Greeting = "Hello " + input.Name
+			return input, nil
+		},
+	})
+
+	services := state.NewFakeServices()
+	server.Integrations = services.Integrations()
+
+	httpServer := httptest.NewServer(server)
+
+	sess, err := session.NewSession(aws.NewConfig().
+		WithEndpoint(httpServer.URL).
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("id", "secret", "")))
+	assert.NoError(t, err)
+
+	return sfn.New(sess), services, httpServer.Close
+}
+
+func createHello(t *testing.T, sfnc *sfn.SFN) *string {
+	out, err := sfnc.CreateStateMachine(&sfn.CreateStateMachineInput{
+		Name:       to.Strp("Hello"),
+		Definition: to.Strp(helloMachine),
+		RoleArn:    to.Strp("arn:aws:iam::000000000000:role/step"),
+	})
+	assert.NoError(t, err)
+	return out.StateMachineArn
+}
+
+// waitForToken waits for the Approval Task to send its task token
+func waitForToken(t *testing.T, services *state.FakeServices) string {
+	for i := 0; i < 500; i++ {
+		if messages := services.Messages("approvals"); len(messages) > 0 {
+			return messages[len(messages)-1]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no task token sent")
+	return ""
+}
+
+func errorCode(err error) string {
+	if awsErr, ok := err.(awserr.Error); ok {
+		return awsErr.Code()
+	}
+	return ""
+}
+
+func Test_Server_StateMachines(t *testing.T) {
+	sfnc, _, closeServer := testServer(t)
+	defer closeServer()
+
+	arn := createHello(t, sfnc)
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:stateMachine:Hello", *arn)
+
+	// Creating the same State Machine again is idempotent
+	createHello(t, sfnc)
+
+	_, err := sfnc.CreateStateMachine(&sfn.CreateStateMachineInput{Name: to.Strp("Hello"), Definition: to.Strp(`{"StartAt": "A", "States": {"A": {"Type": "Succeed"}}}`), RoleArn: to.Strp("arn:aws:iam::000000000000:role/step")})
+	assert.Equal(t, "StateMachineAlreadyExists", errorCode(err))
+
+	_, err = sfnc.CreateStateMachine(&sfn.CreateStateMachineInput{Name: to.Strp("Bad"), Definition: to.Strp(`{"StartAt": "Missing"}`), RoleArn: to.Strp("arn:aws:iam::000000000000:role/step")})
+	assert.Equal(t, "InvalidDefinition", errorCode(err))
+
+	_, err = sfnc.UpdateStateMachine(&sfn.UpdateStateMachineInput{StateMachineArn: arn, RoleArn: to.Strp("arn:aws:iam::000000000000:role/other")})
+	assert.NoError(t, err)
+
+	described, err := sfnc.DescribeStateMachine(&sfn.DescribeStateMachineInput{StateMachineArn: arn})
+	assert.NoError(t, err)
+	assert.Equal(t, "Hello", *described.Name)
+	assert.Equal(t, "ACTIVE", *described.Status)
+	assert.Equal(t, helloMachine, *described.Definition)
+	assert.Equal(t, "arn:aws:iam::000000000000:role/other", *described.RoleArn)
+
+	_, err = sfnc.DescribeStateMachine(&sfn.DescribeStateMachineInput{StateMachineArn: to.Strp("arn:aws:states:us-east-1:000000000000:stateMachine:Missing")})
+	assert.Equal(t, "StateMachineDoesNotExist", errorCode(err))
+}
+
+func Test_Server_Execution_SendTaskSuccess(t *testing.T) {
+	sfnc, services, closeServer := testServer(t)
+	defer closeServer()
+
+	arn := createHello(t, sfnc)
+
+	exec, err := execution.StartExecution(sfnc, arn, to.Strp("first"), map[string]interface{}{"name": "Ada"})
+	assert.NoError(t, err)
+	assert.Equal(t, "arn:aws:states:us-east-1:000000000000:execution:Hello:first", *exec.ExecutionArn)
+
+	_, err = execution.StartExecution(sfnc, arn, to.Strp("first"), map[string]interface{}{})
+	assert.Equal(t, "ExecutionAlreadyExists", errorCode(err))
+
+	token := waitForToken(t, services)
+
+	running, err := sfnc.ListExecutions(&sfn.ListExecutionsInput{StateMachineArn: arn, StatusFilter: to.Strp("RUNNING")})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(running.Executions))
+
+	_, err = sfnc.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: to.Strp(token), Output: to.Strp(`{"approved": true}`)})
+	assert.NoError(t, err)
+
+	_, err = sfnc.SendTaskSuccess(&sfn.SendTaskSuccessInput{TaskToken: to.Strp(token), Output: to.Strp(`{}`)})
+	assert.Equal(t, "TaskDoesNotExist", errorCode(err))
+
+	exec.WaitForExecution(sfnc, 0, func(*execution.Execution, *execution.StateDetails, error) error { return nil })
+	assert.Equal(t, "SUCCEEDED", *exec.Status)
+
+	var output hello
+	assert.NoError(t, json.Unmarshal([]byte(*exec.Output), &output))
+	assert.Equal(t, hello{Name: "Ada", Greeting: "Hello Ada", Approval: map[string]interface{}{"approved": true}}, output)
+
+	// The full history in pages
+	history, err := execution.GetHistory(sfnc, exec.ExecutionArn)
+	assert.NoError(t, err)
+	assert.Equal(t, "ExecutionStarted", *history[0].Type)
+	assert.Equal(t, "ExecutionSucceeded", *history[len(history)-1].Type)
+
+	page, err := sfnc.GetExecutionHistory(&sfn.GetExecutionHistoryInput{ExecutionArn: exec.ExecutionArn, MaxResults: to.Int64p(2), ReverseOrder: to.Boolp(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(page.Events))
+	assert.Equal(t, "ExecutionSucceeded", *page.Events[0].Type)
+	assert.Equal(t, "2", *page.NextToken)
+
+	executions, err := execution.ExecutionsAfter(sfnc, arn, to.Strp("SUCCEEDED"), time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(executions))
+	assert.Equal(t, "first", *executions[0].Name)
+}
+
+func Test_Server_StopExecution(t *testing.T) {
+	sfnc, services, closeServer := testServer(t)
+	defer closeServer()
+
+	arn := createHello(t, sfnc)
+
+	exec, err := execution.StartExecution(sfnc, arn, nil, map[string]interface{}{"name": "Ada"})
+	assert.NoError(t, err)
+
+	waitForToken(t, services)
+
+	_, err = sfnc.StopExecution(&sfn.StopExecutionInput{ExecutionArn: exec.ExecutionArn})
+	assert.NoError(t, err)
+
+	described, err := sfnc.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: exec.ExecutionArn})
+	assert.NoError(t, err)
+	assert.Equal(t, "ABORTED", *described.Status)
+	assert.NotNil(t, described.StopDate)
+
+	_, err = sfnc.DescribeExecution(&sfn.DescribeExecutionInput{ExecutionArn: to.Strp("missing")})
+	assert.Equal(t, "ExecutionDoesNotExist", errorCode(err))
+}
+
+func Test_Server_DescribeExecution_Failed(t *testing.T) {
+	server := NewServer()
+
+	sm, err := server.createStateMachine(&sfn.CreateStateMachineInput{
+		Name:       to.Strp("Failing"),
+		Definition: to.Strp(`{"StartAt": "Fail", "States": {"Fail": {"Type": "Fail", "Error": "Rejected", "Cause": "Not approved"}}}`),
+		RoleArn:    to.Strp("arn:aws:iam::000000000000:role/step"),
+	})
+	assert.NoError(t, err)
+
+	started, err := server.startExecution(&sfn.StartExecutionInput{StateMachineArn: sm.StateMachineArn, Input: to.Strp(`{}`)})
+	assert.NoError(t, err)
+
+	body := `{"executionArn": "` + *started.ExecutionArn + `"}`
+	var described map[string]interface{}
+	for i := 0; i < 500; i++ {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		request.Header.Set("X-Amz-Target", "AWSStepFunctions.DescribeExecution")
+		server.ServeHTTP(recorder, request)
+
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &described))
+		if described["status"] != "RUNNING" {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, "FAILED", described["status"])
+	assert.Equal(t, "Rejected", described["error"])
+	assert.Equal(t, "Not approved", described["cause"])
+	assert.Nil(t, described["output"])
+	assert.IsType(t, float64(0), described["startDate"])
+}
+
+func Test_Server_Request_Errors(t *testing.T) {
+	server := NewServer()
+
+	call := func(operation string, body string) (int, map[string]interface{}) {
+		recorder := httptest.NewRecorder()
+		request := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		request.Header.Set("X-Amz-Target", "AWSStepFunctions."+operation)
+		server.ServeHTTP(recorder, request)
+
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+		return recorder.Code, response
+	}
+
+	status, response := call("CreateStateMachine", `{"name": "Bad", "definition": "{\"StartAt\": \"Missing\"}", "roleArn": "arn:aws:iam::000000000000:role/step"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "InvalidDefinition", response["__type"])
+
+	status, response = call("CreateStateMachine", `{"name": "NoDefinition"}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "ValidationException", response["__type"])
+
+	status, response = call("StartExecution", `{"stateMachineArn": 1}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "SerializationException", response["__type"])
+
+	status, response = call("Missing", `{}`)
+	assert.Equal(t, 400, status)
+	assert.Equal(t, "UnknownOperationException", response["__type"])
+}
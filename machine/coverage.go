@@ -0,0 +1,288 @@
+package machine
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// Coverage aggregates the States and Edges exercised by many Executions of a StateMachine,
+// including the States inside Parallel Branches and Map iterations.
+type Coverage struct {
+	sm *StateMachine
+
+	States map[string]int // times each State was entered
+	Edges  map[Edge]int   // times each Edge was taken
+}
+
+// CoverageReport lists every State and Edge of the StateMachine with the times it was exercised
+type CoverageReport struct {
+	StatesCovered int
+	StatesTotal   int
+	EdgesCovered  int
+	EdgesTotal    int
+
+	States []StateCoverage
+	Edges  []EdgeCoverage
+}
+
+// StateCoverage is a State with its Branch, e.g. "Parallel[0]" or "Map" for the ItemProcessor, empty at the top level
+type StateCoverage struct {
+	Name   string
+	Branch string `json:",omitempty"`
+	Count  int
+}
+
+type EdgeCoverage struct {
+	Edge
+	Branch string `json:",omitempty"`
+	Count  int
+}
+
+// NewCoverage returns an empty Coverage of sm
+func NewCoverage(sm *StateMachine) *Coverage {
+	return &Coverage{
+		sm:     sm,
+		States: map[string]int{},
+		Edges:  map[Edge]int{},
+	}
+}
+
+// Add counts the States entered and Edges taken by the executions
+func (c *Coverage) Add(executions ...*Execution) {
+	for _, exec := range executions {
+		if exec == nil {
+			continue
+		}
+
+		// State names are unique across the whole StateMachine, including its Branches
+		for _, event := range exec.History() {
+			if event.StateEnteredEventDetails != nil {
+				c.States[to.Strs(event.StateEnteredEventDetails.Name)]++
+			}
+		}
+
+		for _, edge := range exec.Edges {
+			c.Edges[edge]++
+		}
+
+		for _, edges := range exec.branchEdges() {
+			for _, edge := range edges {
+				c.Edges[edge]++
+			}
+		}
+	}
+}
+
+// Report returns the coverage of every State and Edge in the StateMachine, sorted by State name,
+// followed by the States of the Branches of each Parallel and Map State
+func (c *Coverage) Report() *CoverageReport {
+	report := &CoverageReport{States: []StateCoverage{}, Edges: []EdgeCoverage{}}
+	c.report(report, c.sm, "")
+	return report
+}
+
+func (c *Coverage) report(report *CoverageReport, sm *StateMachine, branch string) {
+	names := []string{}
+	for name := range sm.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		sc := StateCoverage{Name: name, Branch: branch, Count: c.States[name]}
+		report.States = append(report.States, sc)
+		report.StatesTotal++
+		if sc.Count > 0 {
+			report.StatesCovered++
+		}
+
+		for _, edge := range Edges(sm.States[name]) {
+			ec := EdgeCoverage{Edge: edge, Branch: branch, Count: c.Edges[edge]}
+			report.Edges = append(report.Edges, ec)
+			report.EdgesTotal++
+			if ec.Count > 0 {
+				report.EdgesCovered++
+			}
+		}
+	}
+
+	for _, name := range names {
+		for _, nested := range branchesOf(sm.States[name]) {
+			label := nested.label
+			if branch != "" {
+				label = branch + "/" + label
+			}
+			c.report(report, nested.sm, label)
+		}
+	}
+}
+
+type labelledBranch struct {
+	label string
+	sm    *StateMachine
+}
+
+// branchesOf returns the nested State Machines of a Parallel State labelled "Parallel[i]",
+// or of a Map State labelled with its name
+func branchesOf(s state.State) []labelledBranch {
+	branches := []labelledBranch{}
+
+	switch s := s.(type) {
+	case *state.ParallelState:
+		for i, b := range s.Branches {
+			if branch, ok := b.(*StateMachine); ok && branch != nil {
+				branches = append(branches, labelledBranch{fmt.Sprintf("%v[%v]", *s.Name(), i), branch})
+			}
+		}
+	case *state.MapState:
+		processor := s.ItemProcessor
+		if processor == nil {
+			processor = s.Iterator
+		}
+		if branch, ok := processor.(*StateMachine); ok && branch != nil {
+			branches = append(branches, labelledBranch{*s.Name(), branch})
+		}
+	}
+
+	return branches
+}
+
+// path is the name of the State after its Branch, e.g. "Parallel[0]/A"
+func (s StateCoverage) path() string {
+	if s.Branch == "" {
+		return s.Name
+	}
+	return s.Branch + "/" + s.Name
+}
+
+// Uncovered returns the States and Edges never exercised, e.g. to fail a test
+func (r *CoverageReport) Uncovered() []string {
+	uncovered := []string{}
+	for _, s := range r.States {
+		if s.Count == 0 {
+			uncovered = append(uncovered, fmt.Sprintf("State %v", s.path()))
+		}
+	}
+
+	for _, e := range r.Edges {
+		if e.Count == 0 {
+			uncovered = append(uncovered, fmt.Sprintf("Edge %v -> %v %v", e.From, e.To, e.Label))
+		}
+	}
+	return uncovered
+}
+
+// Text returns the report as a checklist
+func (r *CoverageReport) Text() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "States %v/%v (%v)\n", r.StatesCovered, r.StatesTotal, percent(r.StatesCovered, r.StatesTotal))
+	for _, s := range r.States {
+		fmt.Fprintf(&b, "  %v %v%v\n", checkbox(s.Count), s.path(), count(s.Count))
+	}
+
+	fmt.Fprintf(&b, "Edges %v/%v (%v)\n", r.EdgesCovered, r.EdgesTotal, percent(r.EdgesCovered, r.EdgesTotal))
+	for _, e := range r.Edges {
+		fmt.Fprintf(&b, "  %v %v -> %v %v%v\n", checkbox(e.Count), e.From, e.To, e.Label, count(e.Count))
+	}
+
+	return b.String()
+}
+
+// JSON returns the report as JSON
+func (r *CoverageReport) JSON() (string, error) {
+	return to.PrettyJSON(r)
+}
+
+// Dot returns the StateMachine as a GraphViz graph with the untested States and Edges in red
+func (r *CoverageReport) Dot() string {
+	var b strings.Builder
+
+	b.WriteString("digraph Coverage {\n")
+	b.WriteString("  node [ style=\"rounded,filled\", shape=box, fontname=\"Arial\", fillcolor=\"#ffffff\" ];\n")
+	b.WriteString("  edge [ fontname=\"Arial\" ];\n")
+
+	for _, s := range r.States {
+		if s.Count == 0 {
+			fmt.Fprintf(&b, "  %q [ color=\"#d0021b\", fillcolor=\"#f9d6d5\" ];\n", s.Name)
+		} else {
+			fmt.Fprintf(&b, "  %q;\n", s.Name)
+		}
+	}
+
+	for _, e := range r.Edges {
+		if e.Count == 0 {
+			fmt.Fprintf(&b, "  %q -> %q [ label=%q, color=\"#d0021b\", fontcolor=\"#d0021b\", style=dashed ];\n", e.From, e.To, e.Label)
+		} else {
+			fmt.Fprintf(&b, "  %q -> %q [ label=%q ];\n", e.From, e.To, fmt.Sprintf("%v (%v)", e.Label, e.Count))
+		}
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid returns the StateMachine as a Mermaid flowchart with the untested States and Edges in red
+func (r *CoverageReport) Mermaid() string {
+	var b strings.Builder
+
+	b.WriteString("flowchart TD\n")
+	b.WriteString("  classDef untested fill:#f9d6d5,stroke:#d0021b\n")
+
+	// Mermaid ids cannot contain every character of a State name
+	ids := map[string]string{}
+	for i, s := range r.States {
+		ids[s.Name] = fmt.Sprintf("s%v", i)
+		fmt.Fprintf(&b, "  %v[%q]\n", ids[s.Name], s.Name)
+		if s.Count == 0 {
+			fmt.Fprintf(&b, "  class %v untested\n", ids[s.Name])
+		}
+	}
+
+	// linkStyle refers to links by the order they are defined
+	link := 0
+	for _, e := range r.Edges {
+		toID, ok := ids[e.To]
+		if !ok {
+			continue
+		}
+
+		label := e.Label
+		if e.Count > 0 {
+			label = fmt.Sprintf("%v (%v)", e.Label, e.Count)
+		}
+
+		fmt.Fprintf(&b, "  %v -->|%q| %v\n", ids[e.From], label, toID)
+		if e.Count == 0 {
+			fmt.Fprintf(&b, "  linkStyle %v stroke:#d0021b,stroke-dasharray:5\n", link)
+		}
+		link++
+	}
+
+	return b.String()
+}
+
+func percent(covered int, total int) string {
+	if total == 0 {
+		return "100.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(covered)/float64(total))
+}
+
+func checkbox(count int) string {
+	if count == 0 {
+		return "[ ]"
+	}
+	return "[x]"
+}
+
+func count(count int) string {
+	if count == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%v)", count)
+}
@@ -0,0 +1,161 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var coverageMachine = `{
+  "StartAt": "Check",
+  "States": {
+    "Check": {
+      "Type": "Choice",
+      "Choices": [{ "Variable": "$.skip", "BooleanEquals": true, "Next": "Done" }],
+      "Default": "Task"
+    },
+    "Task": {
+      "Type": "Task",
+      "Resource": "r",
+      "Retry": [{ "ErrorEquals": ["RetryableError"], "MaxAttempts": 1 }],
+      "Catch": [{ "ErrorEquals": ["States.ALL"], "Next": "Failed" }],
+      "Next": "Done"
+    },
+    "Failed": { "Type": "Fail", "Error": "Failed" },
+    "Done": { "Type": "Succeed" }
+  }
+}`
+
+func Test_Coverage_Report(t *testing.T) {
+	sm, err := FromJSON([]byte(coverageMachine))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{}, nil
+	})
+
+	coverage := NewCoverage(sm)
+
+	exec, err := sm.Execute(map[string]interface{}{"skip": false})
+	assert.NoError(t, err)
+	coverage.Add(exec)
+
+	assert.Equal(t, []Edge{
+		{From: "Check", To: "Task", Label: "Default"},
+		{From: "Task", To: "Done", Label: "Next"},
+	}, exec.Edges)
+
+	report := coverage.Report()
+	assert.Equal(t, 3, report.StatesCovered)
+	assert.Equal(t, 4, report.StatesTotal)
+	assert.Equal(t, 2, report.EdgesCovered)
+	assert.Equal(t, 5, report.EdgesTotal)
+
+	assert.Equal(t, []string{
+		"State Failed",
+		"Edge Check -> Done Choices[0]",
+		"Edge Task -> Task Retry[0]",
+		"Edge Task -> Failed Catch[0]",
+	}, report.Uncovered())
+
+	assert.Contains(t, report.Text(), "States 3/4 (75.0%)")
+	assert.Contains(t, report.Text(), "[ ] Task -> Failed Catch[0]")
+	assert.Contains(t, report.Text(), "[x] Check -> Task Default (1)")
+
+	assert.Contains(t, report.Dot(), `"Task" -> "Failed" [ label="Catch[0]", color="#d0021b"`)
+	assert.Contains(t, report.Mermaid(), "class s2 untested")
+	assert.Contains(t, report.Mermaid(), `s3 -->|"Retry[0]"| s3`)
+
+	raw, err := report.JSON()
+	assert.NoError(t, err)
+
+	var parsed CoverageReport
+	assert.NoError(t, json.Unmarshal([]byte(raw), &parsed))
+	assert.Equal(t, *report, parsed)
+}
+
+func Test_Coverage_Retry_Catch_And_Choice(t *testing.T) {
+	sm, err := FromJSON([]byte(coverageMachine))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		return nil, RetryableError{}
+	})
+
+	coverage := NewCoverage(sm)
+
+	exec, err := sm.Execute(map[string]interface{}{"skip": false})
+	assert.Error(t, err)
+	coverage.Add(exec)
+
+	exec, err = sm.Execute(map[string]interface{}{"skip": true})
+	assert.NoError(t, err)
+	coverage.Add(exec)
+
+	report := coverage.Report()
+	assert.Equal(t, []string{"Edge Task -> Done Next"}, report.Uncovered())
+	assert.Equal(t, 2, coverage.States["Check"])
+	assert.Equal(t, 2, coverage.States["Task"])
+}
+
+type RetryableError struct{}
+
+func (RetryableError) Error() string { return "retry me" }
+
+func Test_Coverage_Branches(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          { "StartAt": "A", "States": { "A": { "Type": "Pass", "Next": "B" }, "B": { "Type": "Succeed" }}},
+          { "StartAt": "C", "States": { "C": { "Type": "Pass", "End": true }}}
+        ],
+        "ResultPath": "$.parallel",
+        "Next": "Map"
+      },
+      "Map": {
+        "Type": "Map",
+        "ItemsPath": "$.items",
+        "ItemProcessor": {
+          "StartAt": "Item",
+          "States": {
+            "Item": {
+              "Type": "Choice",
+              "Choices": [{ "Variable": "$", "NumericEquals": 0, "Next": "Zero" }],
+              "Default": "Other"
+            },
+            "Zero": { "Type": "Succeed" },
+            "Other": { "Type": "Succeed" }
+          }
+        },
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	coverage := NewCoverage(sm)
+	exec, err := sm.Execute(map[string]interface{}{"items": []interface{}{1, 2}})
+	assert.NoError(t, err)
+	coverage.Add(exec)
+
+	report := coverage.Report()
+	assert.Equal(t, []StateCoverage{
+		{Name: "Map", Count: 1},
+		{Name: "Parallel", Count: 1},
+		{Name: "Item", Branch: "Map", Count: 2},
+		{Name: "Other", Branch: "Map", Count: 2},
+		{Name: "Zero", Branch: "Map"},
+		{Name: "A", Branch: "Parallel[0]", Count: 1},
+		{Name: "B", Branch: "Parallel[0]", Count: 1},
+		{Name: "C", Branch: "Parallel[1]", Count: 1},
+	}, report.States)
+
+	assert.Equal(t, []string{"State Map/Zero", "Edge Item -> Zero Choices[0]"}, report.Uncovered())
+	assert.Contains(t, report.Text(), "[x] Parallel[0]/A (1)")
+	assert.Contains(t, report.Text(), "[x] Item -> Other Default (2)")
+}
@@ -1,17 +1,24 @@
 package machine
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/service/sfn"
+	steperrors "github.com/coinbase/step/errors"
 	"github.com/coinbase/step/machine/state"
 	"github.com/coinbase/step/utils/to"
 )
 
 type HistoryEvent struct {
 	sfn.HistoryEvent
+
+	// Branch of a Parallel or Map State the event happened in, e.g. "Parallel[0]", empty for the top level States
+	Branch string `json:",omitempty"`
 }
 
 type Execution struct {
@@ -24,6 +31,77 @@ type Execution struct {
 	LastError      error // interim error
 
 	ExecutionHistory []HistoryEvent
+
+	// Edges taken between States, in order
+	Edges []Edge
+
+	// BranchEdges are the Edges taken in Parallel Branches and Map iterations, by Branch
+	BranchEdges map[string][]Edge `json:",omitempty"`
+
+	// ExecutionArn is the $$.Execution.Id, ParentExecutionArn the Execution whose Task started this one
+	ExecutionArn       string
+	ParentExecutionArn string
+
+	lock     sync.RWMutex // guards the ExecutionHistory, BranchEdges, children, checkpoint and invocations while running
+	children []*Execution
+
+	invocations map[*mockKey]int // of each mocked Task
+
+	// A Branch records its events and Edges in the parent Execution
+	parent *Execution
+	branch string
+
+	// The State to enter next with its input, and the Retrier attempts, for a Snapshot.
+	// Branch retries are by "<branch>/<State>", e.g. "Parallel[0]/Task"
+	next          *string
+	data          interface{}
+	retries       map[string][]int
+	branchRetries map[string][]int
+	resumeRetries map[string][]int // of the Branches of the resumed State, each taken once
+
+	clock    state.Clock // timestamps the history, nil is the real time
+	deadline time.Time   // from TimeoutSeconds, zero is no deadline
+
+	breakpoint Breakpoint // of the StateMachine, or from the Context of the Execution
+}
+
+// Children returns the child Executions started by states:startExecution Tasks,
+// a child started without .sync may still be running, only its Status is safe to read until it is not RUNNING
+func (sm *Execution) Children() []*Execution {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return append([]*Execution{}, sm.children...)
+}
+
+func (sm *Execution) addChild(child *Execution) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+	sm.children = append(sm.children, child)
+}
+
+// invoked counts an invocation of the mocked Task key and returns its number, from 0
+func (sm *Execution) invoked(key *mockKey) int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.invocations == nil {
+		sm.invocations = map[*mockKey]int{}
+	}
+
+	i := sm.invocations[key]
+	sm.invocations[key]++
+	return i
+}
+
+func (sm *Execution) pastDeadline() bool {
+	return !sm.deadline.IsZero() && sm.now().After(sm.deadline)
+}
+
+func (sm *Execution) now() time.Time {
+	if sm.clock == nil {
+		return time.Now()
+	}
+	return sm.clock.Now()
 }
 
 func (sm *Execution) SetOutput(output interface{}, err error) {
@@ -31,6 +109,9 @@ func (sm *Execution) SetOutput(output interface{}, err error) {
 	case map[string]interface{}:
 		sm.Output = output.(map[string]interface{})
 		sm.OutputJSON, _ = to.PrettyJSON(output)
+	case []interface{}:
+		// e.g. the output of a Parallel state
+		sm.OutputJSON, _ = to.PrettyJSON(output)
 	}
 
 	if err != nil {
@@ -50,31 +131,225 @@ func (sm *Execution) SetLastOutput(output interface{}, err error) {
 	}
 }
 
+// addEvent appends event to the history with sequential Id and PreviousEventId like AWS
+func (sm *Execution) addEvent(event HistoryEvent) {
+	if sm.parent != nil {
+		event.Branch = sm.branch
+		sm.parent.addEvent(event)
+		return
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	id := int64(len(sm.ExecutionHistory) + 1)
+	event.Id = &id
+	event.PreviousEventId = to.Int64p(id - 1)
+	sm.ExecutionHistory = append(sm.ExecutionHistory, event)
+}
+
+// addEdge records the Edge taken, in BranchEdges of the parent for a Branch
+func (sm *Execution) addEdge(edge Edge) {
+	if sm.parent != nil {
+		sm.parent.addBranchEdge(sm.branch, edge)
+		return
+	}
+	sm.Edges = append(sm.Edges, edge)
+}
+
+func (sm *Execution) addBranchEdge(branch string, edge Edge) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.BranchEdges == nil {
+		sm.BranchEdges = map[string][]Edge{}
+	}
+	sm.BranchEdges[branch] = append(sm.BranchEdges[branch], edge)
+}
+
 func (sm *Execution) EnteredEvent(s state.State, input interface{}) {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEnteredEvent(s, input))
+	sm.addEvent(createEnteredEvent(sm.now(), s, input))
 }
 
 func (sm *Execution) ExitedEvent(s state.State, output interface{}) {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createExitedEvent(s, output))
+	sm.addEvent(createExitedEvent(sm.now(), s, output))
 }
 
 func (sm *Execution) Start() {
-	sm.ExecutionHistory = []HistoryEvent{createEvent("ExecutionStarted")}
+	sm.lock.Lock()
+	sm.ExecutionHistory = []HistoryEvent{}
+	sm.lock.Unlock()
+
+	sm.addEvent(createEvent(sm.now(), "ExecutionStarted"))
+}
+
+// setInput records the input on the ExecutionStarted event
+func (sm *Execution) setInput(input interface{}) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if len(sm.ExecutionHistory) > 0 {
+		sm.ExecutionHistory[0].ExecutionStartedEventDetails = &sfn.ExecutionStartedEventDetails{
+			Input: jsonStr(input),
+		}
+	}
 }
 
+// Failed records the ExecutionFailed event with the Error and Cause of sm.Error
 func (sm *Execution) Failed() {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEvent("ExecutionFailed"))
+	event := createEvent(sm.now(), "ExecutionFailed")
+
+	if sm.Error != nil {
+		errorName, cause := errorAndCause(sm.Error)
+		event.ExecutionFailedEventDetails = &sfn.ExecutionFailedEventDetails{
+			Error: to.Strp(errorName),
+			Cause: to.Strp(cause),
+		}
+	}
+
+	sm.addEvent(event)
 }
 
+func (sm *Execution) TimedOut() {
+	event := createEvent(sm.now(), "ExecutionTimedOut")
+
+	if sm.Error != nil {
+		errorName, cause := errorAndCause(sm.Error)
+		event.ExecutionTimedOutEventDetails = &sfn.ExecutionTimedOutEventDetails{
+			Error: to.Strp(errorName),
+			Cause: to.Strp(cause),
+		}
+	}
+
+	sm.addEvent(event)
+}
+
+func (sm *Execution) Aborted() {
+	event := createEvent(sm.now(), "ExecutionAborted")
+
+	if sm.Error != nil {
+		errorName, cause := errorAndCause(sm.Error)
+		event.ExecutionAbortedEventDetails = &sfn.ExecutionAbortedEventDetails{
+			Error: to.Strp(errorName),
+			Cause: to.Strp(cause),
+		}
+	}
+
+	sm.addEvent(event)
+}
+
+// Succeeded records the ExecutionSucceeded event with the Output
 func (sm *Execution) Succeeded() {
-	sm.ExecutionHistory = append(sm.ExecutionHistory, createEvent("ExecutionSucceeded"))
+	event := createEvent(sm.now(), "ExecutionSucceeded")
+
+	output := sm.OutputJSON
+	var compact bytes.Buffer
+	if json.Compact(&compact, []byte(output)) == nil {
+		output = compact.String()
+	}
+
+	event.ExecutionSucceededEventDetails = &sfn.ExecutionSucceededEventDetails{
+		Output: to.Strp(output),
+	}
+
+	sm.addEvent(event)
+}
+
+// Task events, Execution is a state.TaskRecorder
+
+func (sm *Execution) TaskScheduled(resource string, parameters interface{}, timeoutSeconds int) {
+	event := createEvent(sm.now(), "TaskScheduled")
+
+	resourceType, resourceName, region := resourceDetails(resource)
+	event.TaskScheduledEventDetails = &sfn.TaskScheduledEventDetails{
+		Resource:     to.Strp(resourceName),
+		ResourceType: to.Strp(resourceType),
+		Region:       to.Strp(region),
+		Parameters:   jsonStr(parameters),
+	}
+
+	if timeoutSeconds > 0 {
+		event.TaskScheduledEventDetails.TimeoutInSeconds = to.Int64p(int64(timeoutSeconds))
+	}
+
+	sm.addEvent(event)
+}
+
+func (sm *Execution) TaskStarted(resource string) {
+	event := createEvent(sm.now(), "TaskStarted")
+
+	resourceType, resourceName, _ := resourceDetails(resource)
+	event.TaskStartedEventDetails = &sfn.TaskStartedEventDetails{
+		Resource:     to.Strp(resourceName),
+		ResourceType: to.Strp(resourceType),
+	}
+
+	sm.addEvent(event)
 }
 
-// Path returns the Path of States, ignoreing TaskFn states
+func (sm *Execution) TaskSucceeded(resource string, output interface{}) {
+	event := createEvent(sm.now(), "TaskSucceeded")
+
+	resourceType, resourceName, _ := resourceDetails(resource)
+	event.TaskSucceededEventDetails = &sfn.TaskSucceededEventDetails{
+		Resource:     to.Strp(resourceName),
+		ResourceType: to.Strp(resourceType),
+		Output:       jsonStr(output),
+	}
+
+	sm.addEvent(event)
+}
+
+// TaskFailed records TaskTimedOut for States.Timeout errors and TaskFailed otherwise
+func (sm *Execution) TaskFailed(resource string, err error) {
+	resourceType, resourceName, _ := resourceDetails(resource)
+	errorName, cause := errorAndCause(err)
+
+	if errorName == "States.Timeout" {
+		event := createEvent(sm.now(), "TaskTimedOut")
+		event.TaskTimedOutEventDetails = &sfn.TaskTimedOutEventDetails{
+			Resource:     to.Strp(resourceName),
+			ResourceType: to.Strp(resourceType),
+			Error:        to.Strp(errorName),
+			Cause:        to.Strp(cause),
+		}
+		sm.addEvent(event)
+		return
+	}
+
+	event := createEvent(sm.now(), "TaskFailed")
+	event.TaskFailedEventDetails = &sfn.TaskFailedEventDetails{
+		Resource:     to.Strp(resourceName),
+		ResourceType: to.Strp(resourceType),
+		Error:        to.Strp(errorName),
+		Cause:        to.Strp(cause),
+	}
+	sm.addEvent(event)
+}
+
+// resourceDetails splits a Task Resource into the ResourceType, Resource and Region AWS reports,
+// e.g. "arn:aws:states:::lambda:invoke" is type "lambda" resource "invoke",
+// any other resource is treated as a Lambda function ARN
+func resourceDetails(resource string) (string, string, string) {
+	region := "us-east-1"
+	parts := strings.Split(resource, ":")
+
+	if len(parts) > 3 && parts[3] != "" {
+		region = parts[3]
+	}
+
+	if len(parts) >= 7 && parts[2] == "states" && parts[5] != "" {
+		return parts[5], strings.Join(parts[6:], ":"), region
+	}
+
+	return "lambda", resource, region
+}
+
+// Path returns the Path of States, ignoreing TaskFn states and States in Branches
 func (sm *Execution) Path() []string {
 	path := []string{}
-	for _, er := range sm.ExecutionHistory {
-		if er.StateEnteredEventDetails != nil {
+	for _, er := range sm.History() {
+		if er.StateEnteredEventDetails != nil && er.Branch == "" {
 			name := *er.StateEnteredEventDetails.Name
 			path = append(path, name)
 		}
@@ -82,44 +357,79 @@ func (sm *Execution) Path() []string {
 	return path
 }
 
-func createEvent(name string) HistoryEvent {
-	t := time.Now()
+// History returns a copy of the ExecutionHistory, safe to call while the Execution runs
+func (sm *Execution) History() []HistoryEvent {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return append([]HistoryEvent{}, sm.ExecutionHistory...)
+}
+
+// HistoryOutput returns the ExecutionHistory as the AWS GetExecutionHistory API would
+func (sm *Execution) HistoryOutput(reverseOrder bool) *sfn.GetExecutionHistoryOutput {
+	events := []*sfn.HistoryEvent{}
+	for _, event := range sm.History() {
+		event := event.HistoryEvent
+		events = append(events, &event)
+	}
+
+	if reverseOrder {
+		for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+			events[i], events[j] = events[j], events[i]
+		}
+	}
+
+	return &sfn.GetExecutionHistoryOutput{Events: events}
+}
+
+// errorAndCause returns the Error name and Cause of err as AWS reports them
+func errorAndCause(err error) (string, string) {
+	switch e := err.(type) {
+	case steperrors.FailError:
+		return e.Name, e.Cause
+	case steperrors.StatesError:
+		return e.Name, e.Cause
+	}
+	return to.ErrorType(err), err.Error()
+}
+
+func createEvent(t time.Time, name string) HistoryEvent {
 	return HistoryEvent{
-		sfn.HistoryEvent{
+		HistoryEvent: sfn.HistoryEvent{
 			Type:      to.Strp(name),
 			Timestamp: &t,
 		},
 	}
 }
 
-func createEnteredEvent(state state.State, input interface{}) HistoryEvent {
-	event := createEvent(fmt.Sprintf("%vStateEntered", *state.GetType()))
-	json_raw, err := json.Marshal(input)
-
-	if err != nil {
-		json_raw = []byte{}
-	}
+func createEnteredEvent(t time.Time, state state.State, input interface{}) HistoryEvent {
+	event := createEvent(t, fmt.Sprintf("%vStateEntered", *state.GetType()))
 
 	event.StateEnteredEventDetails = &sfn.StateEnteredEventDetails{
 		Name:  state.Name(),
-		Input: to.Strp(string(json_raw)),
+		Input: jsonStr(input),
 	}
 
 	return event
 }
 
-func createExitedEvent(state state.State, output interface{}) HistoryEvent {
-	event := createEvent(fmt.Sprintf("%vStateExited", *state.GetType()))
-	json_raw, err := json.Marshal(output)
-
-	if err != nil {
-		json_raw = []byte{}
-	}
+func createExitedEvent(t time.Time, state state.State, output interface{}) HistoryEvent {
+	event := createEvent(t, fmt.Sprintf("%vStateExited", *state.GetType()))
 
 	event.StateExitedEventDetails = &sfn.StateExitedEventDetails{
 		Name:   state.Name(),
-		Output: to.Strp(string(json_raw)),
+		Output: jsonStr(output),
 	}
 
 	return event
 }
+
+// jsonStr returns the compact JSON of value, or an empty string
+func jsonStr(value interface{}) *string {
+	json_raw, err := json.Marshal(value)
+
+	if err != nil {
+		json_raw = []byte{}
+	}
+
+	return to.Strp(string(json_raw))
+}
@@ -0,0 +1,340 @@
+// State Machine graph analysis
+package machine
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// Transitions returns the names of all states s can transition to, including Catchers and Choices
+func Transitions(s state.State) []string {
+	nexts := []*string{}
+	catchers := []*state.Catcher{}
+
+	switch s.(type) {
+	case *state.PassState:
+		nexts = append(nexts, s.(*state.PassState).Next)
+	case *state.WaitState:
+		nexts = append(nexts, s.(*state.WaitState).Next)
+	case *state.TaskState:
+		nexts = append(nexts, s.(*state.TaskState).Next)
+		catchers = s.(*state.TaskState).Catch
+	case *state.ActionState:
+		nexts = append(nexts, s.(*state.ActionState).Next)
+		catchers = s.(*state.ActionState).Catch
+	case *state.ParallelState:
+		nexts = append(nexts, s.(*state.ParallelState).Next)
+		catchers = s.(*state.ParallelState).Catch
+	case *state.MapState:
+		nexts = append(nexts, s.(*state.MapState).Next)
+		catchers = s.(*state.MapState).Catch
+	case *state.ChoiceState:
+		cs := s.(*state.ChoiceState)
+		for _, c := range cs.Choices {
+			if c != nil {
+				nexts = append(nexts, c.Next)
+			}
+		}
+		nexts = append(nexts, cs.Default)
+	}
+
+	for _, c := range catchers {
+		if c != nil {
+			nexts = append(nexts, c.Next)
+		}
+	}
+
+	names := []string{}
+	for _, n := range nexts {
+		if n != nil {
+			names = append(names, *n)
+		}
+	}
+	return names
+}
+
+// Edge is a labelled transition from a State, the Label is "Next", "Choices[i]", "Default",
+// "Catch[i]" or "Retry[i]", Retry edges transition back to the same State
+type Edge struct {
+	From  string
+	To    string
+	Label string
+}
+
+// Edges returns every labelled transition s can take
+func Edges(s state.State) []Edge {
+	name := *s.Name()
+	edges := []Edge{}
+
+	add := func(to *string, label string) {
+		if to != nil {
+			edges = append(edges, Edge{From: name, To: *to, Label: label})
+		}
+	}
+
+	var next *string
+	var retriers []*state.Retrier
+	var catchers []*state.Catcher
+
+	switch s := s.(type) {
+	case *state.PassState:
+		next = s.Next
+	case *state.WaitState:
+		next = s.Next
+	case *state.TaskState:
+		next, retriers, catchers = s.Next, s.Retry, s.Catch
+	case *state.ActionState:
+		next, retriers, catchers = s.Next, s.Retry, s.Catch
+	case *state.ParallelState:
+		next, retriers, catchers = s.Next, s.Retry, s.Catch
+	case *state.MapState:
+		next, retriers, catchers = s.Next, s.Retry, s.Catch
+	case *state.ChoiceState:
+		for i, c := range s.Choices {
+			if c != nil {
+				add(c.Next, fmt.Sprintf("Choices[%v]", i))
+			}
+		}
+		add(s.Default, "Default")
+	}
+
+	add(next, "Next")
+
+	for i, r := range retriers {
+		if r != nil {
+			add(&name, fmt.Sprintf("Retry[%v]", i))
+		}
+	}
+
+	for i, c := range catchers {
+		if c != nil {
+			add(c.Next, fmt.Sprintf("Catch[%v]", i))
+		}
+	}
+
+	return edges
+}
+
+// terminal is true if the execution can end in s
+func terminal(s state.State) bool {
+	switch s.(type) {
+	case *state.SucceedState, *state.FailState:
+		return true
+	case *state.PassState:
+		return s.(*state.PassState).End != nil
+	case *state.WaitState:
+		return s.(*state.WaitState).End != nil
+	case *state.TaskState:
+		return s.(*state.TaskState).End != nil
+	case *state.ActionState:
+		return s.(*state.ActionState).End != nil
+	case *state.ParallelState:
+		return s.(*state.ParallelState).End != nil
+	case *state.MapState:
+		return s.(*state.MapState).End != nil
+	}
+	return false
+}
+
+// graphErrors returns every problem with the transitions between states:
+// unknown states, unreachable states, loops with no exit and non exhaustive Choices
+func (sm *StateMachine) graphErrors() []string {
+	graph_errors := []string{}
+
+	names := []string{}
+	for name := range sm.States {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Unknown States
+	if _, ok := sm.States[*sm.StartAt]; !ok {
+		graph_errors = append(graph_errors, fmt.Sprintf("StartAt Unknown State %q", *sm.StartAt))
+	}
+
+	for _, name := range names {
+		for _, next := range Transitions(sm.States[name]) {
+			if _, ok := sm.States[next]; !ok {
+				graph_errors = append(graph_errors, fmt.Sprintf("State %q transitions to Unknown State %q", name, next))
+			}
+		}
+	}
+
+	// Unreachable States
+	reachable := sm.reachableFrom(*sm.StartAt)
+	for _, name := range names {
+		if !reachable[name] {
+			graph_errors = append(graph_errors, fmt.Sprintf("State %q is unreachable from StartAt", name))
+		}
+	}
+
+	// Loops with no exit, reachable states that cannot reach a terminal state
+	exits := sm.canExit()
+	trapped := []string{}
+	for _, name := range names {
+		if reachable[name] && !exits[name] {
+			trapped = append(trapped, name)
+		}
+	}
+
+	if len(trapped) != 0 {
+		graph_errors = append(graph_errors, fmt.Sprintf("States %q loop with no exit", trapped))
+	}
+
+	// Choices that can match nothing
+	for _, name := range names {
+		if cs, ok := sm.States[name].(*state.ChoiceState); ok && cs.Default == nil && !exhaustive(cs.Choices) {
+			graph_errors = append(graph_errors, fmt.Sprintf("ChoiceState %q has no Default and its Choices are not exhaustive", name))
+		}
+	}
+
+	return graph_errors
+}
+
+func (sm *StateMachine) reachableFrom(start string) map[string]bool {
+	reachable := map[string]bool{}
+	queue := []string{start}
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		s, ok := sm.States[name]
+		if !ok || reachable[name] {
+			continue
+		}
+
+		reachable[name] = true
+		queue = append(queue, Transitions(s)...)
+	}
+
+	return reachable
+}
+
+// canExit returns the states that have a path to a terminal state
+func (sm *StateMachine) canExit() map[string]bool {
+	exits := map[string]bool{}
+	for name, s := range sm.States {
+		if terminal(s) {
+			exits[name] = true
+		}
+	}
+
+	// Walk back from the terminal states until nothing changes
+	for changed := true; changed; {
+		changed = false
+		for name, s := range sm.States {
+			if exits[name] {
+				continue
+			}
+
+			for _, next := range Transitions(s) {
+				if exits[next] {
+					exits[name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	return exits
+}
+
+// maxChoiceAtoms bounds the comparisons exhaustive checks, Choices with more are assumed exhaustive
+const maxChoiceAtoms = 16
+
+// exhaustive is true if one of the choices matches any input, i.e. their Or is true
+// for every combination of its comparisons being true or false, e.g. a rule and its Not
+func exhaustive(choices []*state.Choice) bool {
+	rules := []*state.ChoiceRule{}
+	atoms := map[string]uint{}
+	for _, c := range choices {
+		if c != nil {
+			rules = append(rules, &c.ChoiceRule)
+			choiceAtoms(&c.ChoiceRule, atoms)
+		}
+	}
+
+	if len(atoms) > maxChoiceAtoms {
+		return true
+	}
+
+	for values := 0; values < 1<<uint(len(atoms)); values++ {
+		matched := false
+		for _, rule := range rules {
+			if ruleValue(rule, atoms, values) {
+				matched = true
+				break
+			}
+		}
+
+		if !matched {
+			return false
+		}
+	}
+
+	return true
+}
+
+// choiceAtoms numbers every distinct comparison in rule
+func choiceAtoms(rule *state.ChoiceRule, atoms map[string]uint) {
+	switch {
+	case rule == nil:
+	case rule.Not != nil:
+		choiceAtoms(rule.Not, atoms)
+	case len(rule.And) > 0 || len(rule.Or) > 0:
+		for _, r := range append(append([]*state.ChoiceRule{}, rule.And...), rule.Or...) {
+			choiceAtoms(r, atoms)
+		}
+	default:
+		key, _ := atom(rule)
+		if _, ok := atoms[key]; !ok {
+			atoms[key] = uint(len(atoms))
+		}
+	}
+}
+
+// atom returns the key of a comparison, IsPresent false is the negation of IsPresent true
+func atom(rule *state.ChoiceRule) (string, bool) {
+	negated := false
+	if rule.IsPresent != nil && !*rule.IsPresent {
+		present := *rule
+		present.IsPresent = to.Boolp(true)
+		rule, negated = &present, true
+	}
+
+	raw, _ := json.Marshal(rule)
+	return string(raw), negated
+}
+
+// ruleValue evaluates rule with the comparison numbered i true if bit i of values is set
+func ruleValue(rule *state.ChoiceRule, atoms map[string]uint, values int) bool {
+	switch {
+	case rule == nil:
+		return false
+	case rule.Not != nil:
+		return !ruleValue(rule.Not, atoms, values)
+	case len(rule.And) > 0:
+		for _, r := range rule.And {
+			if !ruleValue(r, atoms, values) {
+				return false
+			}
+		}
+		return true
+	case len(rule.Or) > 0:
+		for _, r := range rule.Or {
+			if ruleValue(r, atoms, values) {
+				return true
+			}
+		}
+		return false
+	}
+
+	key, negated := atom(rule)
+	return (values&(1<<atoms[key]) != 0) != negated
+}
@@ -0,0 +1,167 @@
+package machine
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+func validateJSON(json string, t *testing.T) error {
+	sm, err := FromJSON([]byte(json))
+	assert.NoError(t, err)
+	return sm.Validate()
+}
+
+func Test_Graph_Valid(t *testing.T) {
+	err := validateJSON(`{
+    "StartAt": "A",
+    "States": {
+      "A": { "Type": "Pass", "Next": "B" },
+      "B": {
+        "Type": "Task",
+        "Resource": "r",
+        "Catch": [{ "ErrorEquals": ["States.ALL"], "Next": "Failed" }],
+        "Next": "Loop"
+      },
+      "Loop": {
+        "Type": "Choice",
+        "Choices": [{ "Variable": "$.done", "BooleanEquals": false, "Next": "B" }],
+        "Default": "Done"
+      },
+      "Failed": { "Type": "Fail", "Error": "Failed" },
+      "Done": { "Type": "Succeed" }
+    }
+  }`, t)
+
+	assert.NoError(t, err)
+}
+
+func Test_Graph_Unknown_States(t *testing.T) {
+	err := validateJSON(`{
+    "StartAt": "Missing",
+    "States": {
+      "A": { "Type": "Pass", "Next": "B" },
+      "C": {
+        "Type": "Task",
+        "Resource": "r",
+        "Catch": [{ "ErrorEquals": ["States.ALL"], "Next": "D" }],
+        "End": true
+      },
+      "E": {
+        "Type": "Choice",
+        "Choices": [{ "Variable": "$.x", "BooleanEquals": true, "Next": "F" }],
+        "Default": "G"
+      }
+    }
+  }`, t)
+
+	assert.Error(t, err)
+	assert.Regexp(t, `StartAt Unknown State \\"Missing\\"`, err.Error())
+	assert.Regexp(t, `State \\"A\\" transitions to Unknown State \\"B\\"`, err.Error())
+	assert.Regexp(t, `State \\"C\\" transitions to Unknown State \\"D\\"`, err.Error())
+	assert.Regexp(t, `State \\"E\\" transitions to Unknown State \\"F\\"`, err.Error())
+	assert.Regexp(t, `State \\"E\\" transitions to Unknown State \\"G\\"`, err.Error())
+}
+
+func Test_Graph_Unreachable_States(t *testing.T) {
+	err := validateJSON(`{
+    "StartAt": "A",
+    "States": {
+      "A": { "Type": "Pass", "End": true },
+      "B": { "Type": "Pass", "Next": "C" },
+      "C": { "Type": "Succeed" }
+    }
+  }`, t)
+
+	assert.Error(t, err)
+	assert.Regexp(t, `State \\"B\\" is unreachable from StartAt`, err.Error())
+	assert.Regexp(t, `State \\"C\\" is unreachable from StartAt`, err.Error())
+}
+
+func Test_Graph_Loop_With_No_Exit(t *testing.T) {
+	err := validateJSON(`{
+    "StartAt": "A",
+    "States": {
+      "A": { "Type": "Pass", "Next": "B" },
+      "B": { "Type": "Wait", "Seconds": 1, "Next": "A" }
+    }
+  }`, t)
+
+	assert.Error(t, err)
+	assert.Regexp(t, `States \[\\"A\\" \\"B\\"\] loop with no exit`, err.Error())
+}
+
+// choiceMachine returns a machine whose Choice state has the choices and no Default
+func choiceMachine(choices string) string {
+	return `{
+    "StartAt": "Choice",
+    "States": {
+      "Choice": { "Type": "Choice", "Choices": [` + choices + `] },
+      "Done": { "Type": "Succeed" }
+    }
+  }`
+}
+
+func Test_Graph_Choice_Not_Exhaustive(t *testing.T) {
+	for _, choices := range []string{
+		`{ "Variable": "$.x", "StringEquals": "a", "Next": "Done" }`,
+		// Numbers compare false against strings, so these do not cover every value
+		`{ "Variable": "$.x", "NumericLessThan": 1, "Next": "Done" },
+		 { "Variable": "$.x", "NumericGreaterThanEquals": 1, "Next": "Done" }`,
+		`{ "And": [{ "Variable": "$.x", "IsPresent": true }, { "Variable": "$.y", "IsPresent": true }], "Next": "Done" },
+		 { "Variable": "$.x", "IsPresent": false, "Next": "Done" }`,
+	} {
+		err := validateJSON(choiceMachine(choices), t)
+		assert.Error(t, err, choices)
+		assert.Regexp(t, `ChoiceState \\"Choice\\" has no Default and its Choices are not exhaustive`, err.Error())
+	}
+}
+
+func Test_Graph_Choice_Exhaustive(t *testing.T) {
+	for _, choices := range []string{
+		`{ "Variable": "$.x", "StringEquals": "a", "Next": "Done" },
+		 { "Not": { "Variable": "$.x", "StringEquals": "a" }, "Next": "Done" }`,
+		`{ "Variable": "$.x", "IsPresent": true, "Next": "Done" },
+		 { "Variable": "$.x", "IsPresent": false, "Next": "Done" }`,
+		`{ "And": [{ "Variable": "$.x", "IsString": true }, { "Variable": "$.y", "IsString": true }], "Next": "Done" },
+		 { "Not": { "Variable": "$.x", "IsString": true }, "Next": "Done" },
+		 { "Not": { "Variable": "$.y", "IsString": true }, "Next": "Done" }`,
+		`{ "Or": [{ "Variable": "$.x", "BooleanEquals": true }, { "Not": { "Variable": "$.x", "BooleanEquals": true } }], "Next": "Done" }`,
+	} {
+		assert.NoError(t, validateJSON(choiceMachine(choices), t), choices)
+	}
+}
+
+func Test_Graph_Choice_No_Match_Fails(t *testing.T) {
+	sm, err := FromJSON([]byte(choiceMachine(`{ "Variable": "$.x", "StringEquals": "a", "Next": "Done" }`)))
+	assert.NoError(t, err)
+
+	// Without Validate the Execution fails like AWS
+	choice := sm.States["Choice"].(*state.ChoiceState)
+	_, _, err = choice.Execute(context.Background(), map[string]interface{}{"x": "b"})
+	assert.Error(t, err)
+	assert.Regexp(t, "States.NoChoiceMatched", err.Error())
+}
+
+func Test_Graph_Branches_Are_Validated(t *testing.T) {
+	err := validateJSON(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [{
+          "StartAt": "A",
+          "States": { "A": { "Type": "Pass", "Next": "Done" } }
+        }],
+        "Next": "Done"
+      },
+      "Done": { "Type": "Succeed" }
+    }
+  }`, t)
+
+	assert.Error(t, err)
+	assert.Regexp(t, `Branch 0`, err.Error())
+	assert.Regexp(t, `Unknown State`, err.Error())
+}
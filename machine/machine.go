@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambdacontext"
+	steperrors "github.com/coinbase/step/errors"
 	"github.com/coinbase/step/handler"
 	"github.com/coinbase/step/machine/state"
 	"github.com/coinbase/step/utils/is"
@@ -36,6 +38,49 @@ type StateMachine struct {
 	StartAt *string
 
 	States States
+
+	TimeoutSeconds int `json:",omitempty"`
+
+	// Clock used for Wait states and Retry intervals, defaults to a FakeClock
+	Clock state.Clock `json:"-"`
+
+	// StrictDataFlow processes input and output exactly like AWS,
+	// without merging task output into the input
+	StrictDataFlow bool `json:"-"`
+
+	// Integrations handle the Resource of Task states without a TaskHandler,
+	// e.g. state.NewFakeServices().Integrations()
+	Integrations *state.Integrations `json:"-"`
+
+	// Observers are notified through the lifecycle of every Execution
+	Observers []Observer `json:"-"`
+
+	// Breakpoint is called before each State is entered, e.g. by a debugger,
+	// it returns the input to use and an error stops the Execution. See WithBreakpoint for one Execution.
+	Breakpoint Breakpoint `json:"-"`
+
+	// Checkpoint is called with a Snapshot of the Execution after every State transition,
+	// e.g. to save it and Resume after a crash, an error stops the Execution
+	Checkpoint func(snapshot *Snapshot) error `json:"-"`
+}
+
+// Breakpoint is called with each State and its input before it is entered
+type Breakpoint func(s state.State, input interface{}) (interface{}, error)
+
+type breakpointKey struct{}
+
+// WithBreakpoint returns a Context with a Breakpoint for the Executions run with it, instead of the
+// Breakpoint of the StateMachine. It is not called in Parallel Branches or Map iterations.
+func WithBreakpoint(ctx context.Context, breakpoint Breakpoint) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, breakpointKey{}, breakpoint)
+}
+
+func contextBreakpoint(ctx context.Context) Breakpoint {
+	breakpoint, _ := ctx.Value(breakpointKey{}).(Breakpoint)
+	return breakpoint
 }
 
 // Global Methods
@@ -72,6 +117,8 @@ func (sm *StateMachine) FindAction(name string) (*state.ActionState, error) {
 	return action, nil
 }
 
+// Tasks returns all Task states, including those nested in Branches
+// State names are unique across the whole State Machine
 func (sm *StateMachine) Tasks() map[string]*state.TaskState {
 	tasks := map[string]*state.TaskState{}
 	for name, s := range sm.States {
@@ -80,9 +127,16 @@ func (sm *StateMachine) Tasks() map[string]*state.TaskState {
 			tasks[name] = s.(*state.TaskState)
 		}
 	}
+
+	for _, branch := range sm.branches() {
+		for name, task := range branch.Tasks() {
+			tasks[name] = task
+		}
+	}
 	return tasks
 }
 
+// Actions returns all Action states, including those nested in Branches
 func (sm *StateMachine) Actions() map[string]*state.ActionState {
 	tasks := map[string]*state.ActionState{}
 	for name, s := range sm.States {
@@ -91,9 +145,36 @@ func (sm *StateMachine) Actions() map[string]*state.ActionState {
 			tasks[name] = s.(*state.ActionState)
 		}
 	}
+
+	for _, branch := range sm.branches() {
+		for name, action := range branch.Actions() {
+			tasks[name] = action
+		}
+	}
 	return tasks
 }
 
+// branches returns the nested State Machines of this machines states
+func (sm *StateMachine) branches() []*StateMachine {
+	nested := []state.Machine{}
+	for _, s := range sm.States {
+		switch s.(type) {
+		case *state.ParallelState:
+			nested = append(nested, s.(*state.ParallelState).Branches...)
+		case *state.MapState:
+			nested = append(nested, s.(*state.MapState).Iterator, s.(*state.MapState).ItemProcessor)
+		}
+	}
+
+	branches := []*StateMachine{}
+	for _, n := range nested {
+		if branch, ok := n.(*StateMachine); ok && branch != nil {
+			branches = append(branches, branch)
+		}
+	}
+	return branches
+}
+
 func (sm *StateMachine) SetResource(lambda_arn *string) {
 	for _, task := range sm.Tasks() {
 		if task.Resource == nil {
@@ -174,6 +255,10 @@ func (sm *StateMachine) Validate() error {
 		return errors.New("State Machine must have States")
 	}
 
+	if sm.TimeoutSeconds < 0 {
+		return errors.New("State Machine TimeoutSeconds must be positive")
+	}
+
 	state_errors := []string{}
 
 	for _, state := range sm.States {
@@ -183,16 +268,22 @@ func (sm *StateMachine) Validate() error {
 		}
 	}
 
+	// Report every problem together
+	state_errors = append(state_errors, sm.graphErrors()...)
+
 	if len(state_errors) != 0 {
 		return fmt.Errorf("State Errors %q", state_errors)
 	}
 
-	// TODO: validate all states are reachable
 	return nil
 }
 
 func (sm *StateMachine) DefaultLambdaContext(lambda_name string) context.Context {
-	return lambdacontext.NewContext(context.Background(), &lambdacontext.LambdaContext{
+	return lambdaContext(context.Background(), lambda_name)
+}
+
+func lambdaContext(ctx context.Context, lambda_name string) context.Context {
+	return lambdacontext.NewContext(ctx, &lambdacontext.LambdaContext{
 		InvokedFunctionArn: fmt.Sprintf("arn:aws:lambda:us-east-1:000000000000:function:%v", lambda_name),
 	})
 }
@@ -219,6 +310,12 @@ func processInput(input interface{}) (interface{}, error) {
 }
 
 func (sm *StateMachine) Execute(input interface{}) (*Execution, error) {
+	return sm.ExecuteContext(context.Background(), input)
+}
+
+// ExecuteContext executes the machine passing ctx to every state handler
+// Cancelling ctx aborts the execution, exceeding TimeoutSeconds times it out
+func (sm *StateMachine) ExecuteContext(ctx context.Context, input interface{}) (*Execution, error) {
 	if err := sm.Validate(); err != nil {
 		return nil, err
 	}
@@ -229,57 +326,187 @@ func (sm *StateMachine) Execute(input interface{}) (*Execution, error) {
 	}
 
 	// Start Execution (records the history, inputs, outputs...)
-	exec := &Execution{}
+	exec := &Execution{clock: sm.clock()}
 	exec.Start()
+	exec.setInput(input)
 
-	// Execute Start State
-	output, err := sm.stateLoop(exec, sm.StartAt, input)
+	start, _ := ctx.Value(executionStartKey{}).(executionStart)
 
-	// Set Final Output
-	exec.SetOutput(output, err)
+	exec.ExecutionArn = start.executionArn
+	if exec.ExecutionArn == "" {
+		exec.ExecutionArn = localExecutionArn("local", *to.TimeUUID("execution-"))
+	}
 
-	if err != nil {
-		exec.Failed()
+	if start.parent != nil {
+		exec.ParentExecutionArn = start.parent.ExecutionArn
+		start.parent.addChild(exec)
+	}
+
+	if start.started != nil {
+		start.started(exec)
+	}
+
+	return sm.run(ctx, exec, input, sm.StartAt, input)
+}
+
+func (sm *StateMachine) clock() state.Clock {
+	if sm.Clock == nil {
+		// Simulate time so Wait and Retry intervals do not slow tests
+		return state.NewFakeClock(time.Now())
+	}
+	return sm.Clock
+}
+
+// run executes exec from the State next with data, execInput is the input of the whole Execution
+func (sm *StateMachine) run(ctx context.Context, exec *Execution, execInput interface{}, next *string, data interface{}) (*Execution, error) {
+	// Observers and the Breakpoint from ctx are for this Execution only
+	observers := append(append([]Observer{}, sm.Observers...), contextObservers(ctx)...)
+	exec.breakpoint = sm.Breakpoint
+	if breakpoint := contextBreakpoint(ctx); breakpoint != nil {
+		exec.breakpoint = breakpoint
+	}
+
+	started := exec.now()
+	for _, observer := range observers {
+		observer.OnExecutionStart(data, started)
+	}
+
+	// TimeoutSeconds bounds both the real time and the simulated time of the Clock
+	var loopCtx context.Context
+	var cancel context.CancelFunc
+	if sm.TimeoutSeconds > 0 {
+		timeout := time.Duration(sm.TimeoutSeconds) * time.Second
+		exec.deadline = exec.now().Add(timeout)
+		loopCtx, cancel = context.WithTimeout(ctx, timeout)
 	} else {
+		loopCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	stateCtx := state.WithTaskRecorder(state.WithClock(loopCtx, exec.clock), exec)
+	stateCtx = context.WithValue(stateCtx, executionKey{}, exec)
+	stateCtx = context.WithValue(stateCtx, executionStartKey{}, nil) // not for Executions started by handlers
+	stateCtx = state.WithContextObject(stateCtx, executionContextObject(ctx, exec, execInput, *exec.ExecutionHistory[0].Timestamp))
+	if sm.StrictDataFlow {
+		stateCtx = state.WithStrictDataFlow(stateCtx)
+	}
+	stateCtx = withObservers(stateCtx, observers)
+	if sm.Integrations != nil {
+		stateCtx = state.WithIntegrations(stateCtx, sm.Integrations)
+	}
+	if state.ContextCallbacks(stateCtx) == nil {
+		stateCtx = state.WithCallbacks(stateCtx, state.NewCallbacks())
+	}
+
+	output, err := sm.stateLoop(stateCtx, exec, next, data)
+
+	switch {
+	case err != nil && ctx.Err() != nil:
+		err = fmt.Errorf("Execution Aborted: %v", ctx.Err())
+		exec.SetOutput(nil, err)
+		exec.Aborted()
+	case (err != nil && loopCtx.Err() != nil) || exec.pastDeadline():
+		err = steperrors.StatesError{Name: "States.Timeout", Cause: fmt.Sprintf("Execution exceeded TimeoutSeconds(%v)", sm.TimeoutSeconds)}
+		exec.SetOutput(nil, err)
+		exec.TimedOut()
+	case err == nil:
+		exec.SetOutput(output, err)
 		exec.Succeeded()
+	default:
+		exec.SetOutput(output, err)
+		exec.Failed()
+	}
+
+	for _, observer := range observers {
+		observer.OnExecutionEnd(exec, exec.now().Sub(started))
 	}
 
 	return exec, err
 }
 
-func (sm *StateMachine) stateLoop(exec *Execution, next *string, input interface{}) (output interface{}, err error) {
+// ExecuteBranch executes the machine as a nested Branch of a Parallel state, or Map item
+// The branch stops when ctx is cancelled, e.g. when a sibling branch fails
+func (sm *StateMachine) ExecuteBranch(ctx context.Context, input interface{}) (interface{}, error) {
+	exec := &Execution{clock: state.ContextClock(ctx), breakpoint: sm.Breakpoint}
+
+	// Branches record their events in the history of the Execution they run in, as they happen
+	if parent, ok := ctx.Value(executionKey{}).(*Execution); ok && parent != nil {
+		exec.parent = parent
+		exec.branch = state.ContextBranch(ctx)
+		exec.retries = parent.takeResumeRetries(exec.branch, sm.States)
+	} else {
+		exec.Start()
+	}
+
+	return sm.stateLoop(state.WithTaskRecorder(ctx, exec), exec, sm.StartAt, input)
+}
+
+func (sm *StateMachine) stateLoop(ctx context.Context, exec *Execution, next *string, input interface{}) (output interface{}, err error) {
+	recorder := &stateRecorder{observers: contextObservers(ctx), states: sm.States, exec: exec}
+	ctx = state.WithTransitionRecorder(ctx, recorder)
+
+	// Retry attempts belong to this loop, not the shared States
+	attempts := state.NewRetryAttempts(exec.retries)
+	ctx = state.WithRetryAttempts(ctx, attempts)
+
 	// Flat loop instead of recursion to better implement timeouts
-	for {
+	for entered := 0; ; entered++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if exec.pastDeadline() {
+			return nil, fmt.Errorf("Execution exceeded TimeoutSeconds")
+		}
+
 		s, ok := sm.States[*next]
 
 		if !ok {
 			return nil, fmt.Errorf("Unknown State: %v", *next)
 		}
 
-		if len(exec.ExecutionHistory) > 250 {
+		// Count States not events, as Tasks add their own events
+		if entered >= 125 {
 			return nil, fmt.Errorf("State Overflow")
 		}
 
+		if exec.breakpoint != nil {
+			if input, err = exec.breakpoint(s, input); err != nil {
+				return nil, err
+			}
+		}
+
 		exec.EnteredEvent(s, input)
+		exec.checkpoint(next, input, attempts.Attempts())
+		recorder.entered(s, input)
 
-		output, next, err = s.Execute(sm.DefaultLambdaContext(*s.Name()), input)
+		output, next, err = s.Execute(lambdaContext(ctx, *s.Name()), input)
 
 		if *s.GetType() != "Fail" {
 			// Failure States Dont exit.
 			exec.SetLastOutput(output, err)
 			exec.ExitedEvent(s, output)
 		}
+		recorder.exited(output, next, err)
 
 		// If Error return error
 		if err != nil {
 			return output, err
 		}
 
+		exec.checkpoint(next, output, attempts.Attempts())
+
 		// If next is nil then END
 		if next == nil {
 			return output, nil
 		}
 
+		if sm.Checkpoint != nil {
+			if err := sm.Checkpoint(exec.Snapshot()); err != nil {
+				return nil, err
+			}
+		}
+
 		input = output
 	}
 }
@@ -1,10 +1,18 @@
 package machine
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/coinbase/step/aws/mocks"
+	"github.com/coinbase/step/execution"
+	"github.com/coinbase/step/handler"
+	"github.com/coinbase/step/machine/state"
 	"github.com/coinbase/step/utils/to"
 	"github.com/stretchr/testify/assert"
 )
@@ -95,3 +103,864 @@ func Test_Machine_MarshallAllTypes(t *testing.T) {
 
 	assert.JSONEq(t, string(raw_json), string(marshalled_json))
 }
+
+func Test_Machine_Parallel_Branches_Ordered_Output(t *testing.T) {
+	json := []byte(`
+  {
+      "StartAt": "Parallel",
+      "States": {
+        "Parallel": {
+          "Type": "Parallel",
+          "ResultPath": "$.results",
+          "Branches": [
+            {
+              "StartAt": "Slow",
+              "States": {
+                "Slow": { "Type": "Wait", "Seconds": 1, "Next": "A" },
+                "A": { "Type": "Pass", "Result": "a", "ResultPath": "$.branch", "End": true }
+              }
+            },
+            {
+              "StartAt": "B",
+              "States": {
+                "B": { "Type": "Pass", "Result": "b", "ResultPath": "$.branch", "End": true }
+              }
+            }
+          ],
+          "End": true
+        }
+    }
+  }`)
+
+	output, err := execute(json, map[string]interface{}{"x": "y"}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", output["x"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"x": "y", "branch": "a"},
+		map[string]interface{}{"x": "y", "branch": "b"},
+	}, output["results"])
+}
+
+func Test_Machine_Parallel_Branch_Tasks_Have_Handlers(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Parallel",
+      "States": {
+        "Parallel": {
+          "Type": "Parallel",
+          "Branches": [
+            { "StartAt": "A", "States": { "A": { "Type": "TaskFn", "Resource": "r", "End": true }}},
+            { "StartAt": "B", "States": { "B": { "Type": "TaskFn", "Resource": "r", "End": true }}}
+          ],
+          "End": true
+        }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, len(sm.Tasks()))
+
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		"A": func(_ context.Context, input interface{}) (interface{}, error) {
+			return map[string]string{"a": "A"}, nil
+		},
+		"B": func(_ context.Context, input interface{}) (interface{}, error) {
+			return map[string]string{"b": "B"}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Regexp(t, `"a": "A"`, exec.OutputJSON)
+	assert.Regexp(t, `"b": "B"`, exec.OutputJSON)
+}
+
+func Test_Machine_Parallel_Branch_Events_In_History(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Parallel",
+      "States": {
+        "Parallel": {
+          "Type": "Parallel",
+          "Branches": [
+            {
+              "StartAt": "A",
+              "States": {
+                "A": { "Type": "TaskFn", "Resource": "r", "Next": "ADone" },
+                "ADone": { "Type": "Succeed" }
+              }
+            },
+            { "StartAt": "B", "States": { "B": { "Type": "Pass", "End": true }}}
+          ],
+          "End": true
+        }
+    }
+  }`))
+	assert.NoError(t, err)
+	sm.SetDefaultHandler()
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// Path and Edges are the top level States, Branch events are labelled with their Branch
+	assert.Equal(t, []string{"Parallel"}, exec.Path())
+	assert.Equal(t, map[string][]Edge{"Parallel[0]": {{From: "A", To: "ADone", Label: "Next"}}}, exec.BranchEdges)
+
+	branches := map[string][]string{}
+	for i, event := range exec.ExecutionHistory {
+		assert.Equal(t, int64(i+1), *event.Id)
+		if event.Branch != "" {
+			branches[event.Branch] = append(branches[event.Branch], *event.Type)
+		}
+	}
+
+	assert.Equal(t, map[string][]string{
+		"Parallel[0]": {"TaskStateEntered", "TaskScheduled", "TaskStarted", "TaskSucceeded", "TaskStateExited", "SucceedStateEntered", "SucceedStateExited"},
+		"Parallel[1]": {"PassStateEntered", "PassStateExited"},
+	}, branches)
+
+	types := []string{}
+	for _, event := range exec.ExecutionHistory {
+		if event.Branch == "" {
+			types = append(types, *event.Type)
+		}
+	}
+	assert.Equal(t, []string{"ExecutionStarted", "ParallelStateEntered", "ParallelStateExited", "ExecutionSucceeded"}, types)
+}
+
+func Test_Machine_Parallel_Failing_Branch_Cancels_Siblings(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Parallel",
+      "States": {
+        "Parallel": {
+          "Type": "Parallel",
+          "Branches": [
+            { "StartAt": "Fails", "States": { "Fails": { "Type": "Fail", "Error": "Broken" }}},
+            {
+              "StartAt": "Blocks",
+              "States": {
+                "Blocks": { "Type": "TaskFn", "Resource": "r", "Next": "NeverReached" },
+                "NeverReached": { "Type": "TaskFn", "Resource": "r", "End": true }
+              }
+            }
+          ],
+          "Catch": [{ "ErrorEquals": ["States.BranchFailed"], "ResultPath": "$.error", "Next": "Caught" }],
+          "End": true
+        },
+        "Caught": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	reached := false
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		// Blocks until the failing branch cancels it
+		"Blocks": func(ctx context.Context, input interface{}) (interface{}, error) {
+			<-ctx.Done()
+			return map[string]string{}, nil
+		},
+		"NeverReached": func(_ context.Context, input interface{}) (interface{}, error) {
+			reached = true
+			return map[string]string{}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.False(t, reached)
+	assert.Equal(t, []string{"Parallel", "Caught"}, exec.Path())
+	assert.Regexp(t, "States.BranchFailed", exec.OutputJSON)
+	assert.Regexp(t, "Fail State", exec.OutputJSON)
+}
+
+func Test_Machine_Parallel_Requires_Branches(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Parallel",
+      "States": {
+        "Parallel": { "Type": "Parallel", "End": true }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	err = sm.Validate()
+	assert.Error(t, err)
+	assert.Regexp(t, "Must have Branches", err.Error())
+}
+
+func Test_Machine_Map_Items_Ordered_Output(t *testing.T) {
+	json := []byte(`
+  {
+      "StartAt": "Map",
+      "States": {
+        "Map": {
+          "Type": "Map",
+          "ItemsPath": "$.items",
+          "ItemSelector": {
+            "index.$": "$$.Map.Item.Index",
+            "value.$": "$$.Map.Item.Value",
+            "prefix.$": "$.prefix"
+          },
+          "Iterator": {
+            "StartAt": "Item",
+            "States": {
+              "Item": { "Type": "Pass", "Result": "done", "ResultPath": "$.status", "End": true }
+            }
+          },
+          "ResultPath": "$.results",
+          "End": true
+        }
+    }
+  }`)
+
+	output, err := execute(json, map[string]interface{}{"prefix": "p", "items": []interface{}{"a", "b"}}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"a", "b"}, output["items"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"index": 0.0, "value": "a", "prefix": "p", "status": "done"},
+		map[string]interface{}{"index": 1.0, "value": "b", "prefix": "p", "status": "done"},
+	}, output["results"])
+}
+
+func Test_Machine_Map_ResultSelector(t *testing.T) {
+	json := []byte(`
+  {
+      "StartAt": "Map",
+      "States": {
+        "Map": {
+          "Type": "Map",
+          "ItemsPath": "$.items",
+          "ItemProcessor": {
+            "StartAt": "Item",
+            "States": {
+              "Item": { "Type": "Pass", "End": true }
+            }
+          },
+          "ResultSelector": {
+            "count.$": "States.ArrayLength($)",
+            "items.$": "$"
+          },
+          "ResultPath": "$.results",
+          "End": true
+        }
+    }
+  }`)
+
+	output, err := execute(json, map[string]interface{}{"items": []interface{}{"a", "b"}}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"count": 2.0,
+		"items": []interface{}{"a", "b"},
+	}, output["results"])
+}
+
+func Test_Machine_Map_MaxConcurrency(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Map",
+      "States": {
+        "Map": {
+          "Type": "Map",
+          "MaxConcurrency": 2,
+          "ItemProcessor": {
+            "StartAt": "Item",
+            "States": { "Item": { "Type": "TaskFn", "Resource": "r", "End": true }}
+          },
+          "End": true
+        }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	var lock sync.Mutex
+	running, maxRunning := 0, 0
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		"Item": func(_ context.Context, input interface{}) (interface{}, error) {
+			lock.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			lock.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			lock.Lock()
+			running--
+			lock.Unlock()
+			return map[string]interface{}{}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.ExecuteBranch(context.Background(), []interface{}{1, 2, 3, 4, 5})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(exec.([]interface{})))
+	assert.Equal(t, 2, maxRunning)
+}
+
+func Test_Machine_Map_ToleratedFailurePercentage(t *testing.T) {
+	definition := `
+  {
+      "StartAt": "Map",
+      "States": {
+        "Map": {
+          "Type": "Map",
+          "ItemsPath": "$.items",
+          "ToleratedFailurePercentage": %v,
+          "Iterator": {
+            "StartAt": "Item",
+            "States": { "Item": { "Type": "TaskFn", "Resource": "r", "End": true }}
+          },
+          "Catch": [{ "ErrorEquals": ["States.ExceedToleratedFailureThreshold"], "Next": "Exceeded" }],
+          "End": true
+        },
+        "Exceeded": { "Type": "Succeed" }
+    }
+  }`
+
+	run := func(percentage int) *Execution {
+		sm, err := FromJSON([]byte(fmt.Sprintf(definition, percentage)))
+		assert.NoError(t, err)
+
+		err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+			"Item": func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+				if input["fail"] == true {
+					return nil, fmt.Errorf("item failed")
+				}
+				return input, nil
+			},
+		})
+		assert.NoError(t, err)
+
+		exec, err := sm.Execute(`{"items": [{"fail": true}, {"fail": false}, {"fail": false}, {"fail": false}]}`)
+		assert.NoError(t, err)
+		return exec
+	}
+
+	// 25% of items fail
+	assert.Equal(t, []string{"Map"}, run(50).Path())
+	assert.Equal(t, []string{"Map", "Exceeded"}, run(10).Path())
+}
+
+func Test_Machine_Map_Reports_Failed_Item_Not_Cancelled_Item(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Map",
+      "States": {
+        "Map": {
+          "Type": "Map",
+          "ItemsPath": "$.items",
+          "ResultPath": "$.error",
+          "Iterator": {
+            "StartAt": "Nested",
+            "States": {
+              "Nested": {
+                "Type": "Parallel",
+                "Branches": [{ "StartAt": "Item", "States": { "Item": { "Type": "TaskFn", "Resource": "r", "End": true }}}],
+                "End": true
+              }
+            }
+          },
+          "Catch": [{ "ErrorEquals": ["States.BranchFailed"], "ResultPath": "$.error", "Next": "Caught" }],
+          "End": true
+        },
+        "Caught": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	blocked := make(chan struct{})
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		// The first item blocks until the second item fails and cancels it
+		"Item": func(ctx context.Context, input map[string]interface{}) (interface{}, error) {
+			if input["fail"] == true {
+				<-blocked
+				return nil, fmt.Errorf("item failed")
+			}
+			close(blocked)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(`{"items": [{"fail": false}, {"fail": true}]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Map", "Caught"}, exec.Path())
+	assert.Regexp(t, "Item 1: .*item failed", exec.OutputJSON)
+	assert.NotRegexp(t, "canceled", exec.OutputJSON)
+}
+
+func Test_Machine_Clock_Simulates_Wait_Timing(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Wait",
+      "States": {
+        "Wait": { "Type": "Wait", "Seconds": 3600, "Next": "Done" },
+        "Done": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	sm.Clock = state.NewFakeClock(start)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	history := exec.ExecutionHistory
+	assert.Equal(t, "ExecutionStarted", *history[0].Type)
+	assert.Equal(t, start, *history[0].Timestamp)
+	assert.Equal(t, "ExecutionSucceeded", *history[len(history)-1].Type)
+	assert.Equal(t, start.Add(time.Hour), *history[len(history)-1].Timestamp)
+}
+
+func Test_Machine_Clock_Branches_Wait_Concurrently(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	ended := func(machine string) time.Time {
+		sm, err := FromJSON([]byte(machine))
+		assert.NoError(t, err)
+		sm.Clock = state.NewFakeClock(start)
+
+		exec, err := sm.Execute(map[string]interface{}{"items": []interface{}{1, 2, 3}})
+		assert.NoError(t, err)
+
+		history := exec.ExecutionHistory
+		return *history[len(history)-1].Timestamp
+	}
+
+	// The Parallel State ends with its longest Branch
+	assert.Equal(t, start.Add(20*time.Second), ended(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          { "StartAt": "A", "States": { "A": { "Type": "Wait", "Seconds": 10, "End": true }}},
+          { "StartAt": "B", "States": { "B": { "Type": "Wait", "Seconds": 20, "End": true }}}
+        ],
+        "End": true
+      }
+    }
+  }`))
+
+	mapMachine := `{
+    "StartAt": "Map",
+    "States": {
+      "Map": {
+        "Type": "Map",
+        "ItemsPath": "$.items",
+        "MaxConcurrency": %v,
+        "Iterator": { "StartAt": "W", "States": { "W": { "Type": "Wait", "Seconds": 5, "End": true }}},
+        "End": true
+      }
+    }
+  }`
+
+	// Items wait for a free slot
+	assert.Equal(t, start.Add(5*time.Second), ended(fmt.Sprintf(mapMachine, 0)))
+	assert.Equal(t, start.Add(10*time.Second), ended(fmt.Sprintf(mapMachine, 2)))
+	assert.Equal(t, start.Add(15*time.Second), ended(fmt.Sprintf(mapMachine, 1)))
+}
+
+func Test_Machine_ExecuteContext_Cancel_Aborts(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Blocks",
+      "States": {
+        "Blocks": { "Type": "TaskFn", "Resource": "r", "Next": "Done" },
+        "Done": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		"Blocks": func(ctx context.Context, input interface{}) (interface{}, error) {
+			cancel()
+			<-ctx.Done()
+			return map[string]interface{}{}, nil
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.ExecuteContext(ctx, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "Execution Aborted", err.Error())
+	assert.Equal(t, []string{"Blocks"}, exec.Path())
+	assert.Equal(t, "ExecutionAborted", *exec.ExecutionHistory[len(exec.ExecutionHistory)-1].Type)
+}
+
+func Test_Machine_TimeoutSeconds_Simulated_Time(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Wait",
+      "TimeoutSeconds": 60,
+      "States": {
+        "Wait": { "Type": "Wait", "Seconds": 3600, "Next": "Done" },
+        "Done": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "States.Timeout", err.Error())
+	assert.Equal(t, []string{"Wait"}, exec.Path())
+	assert.Equal(t, "ExecutionTimedOut", *exec.ExecutionHistory[len(exec.ExecutionHistory)-1].Type)
+}
+
+func Test_Machine_TimeoutSeconds_Runaway_Handler(t *testing.T) {
+	sm, err := FromJSON([]byte(`
+  {
+      "StartAt": "Runaway",
+      "TimeoutSeconds": 1,
+      "States": {
+        "Runaway": {
+          "Type": "TaskFn",
+          "Resource": "r",
+          "Catch": [{ "ErrorEquals": ["States.ALL"], "Next": "Runaway" }],
+          "End": true
+        }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	err = sm.SetTaskFnHandlers(&handler.TaskHandlers{
+		"Runaway": func(ctx context.Context, input interface{}) (interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		},
+	})
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "States.Timeout", err.Error())
+	assert.Equal(t, "ExecutionTimedOut", *exec.ExecutionHistory[len(exec.ExecutionHistory)-1].Type)
+}
+
+func executeStrict(json string, task interface{}, input interface{}, t *testing.T) (*Execution, error) {
+	sm, err := FromJSON([]byte(json))
+	assert.NoError(t, err)
+
+	sm.StrictDataFlow = true
+	if task != nil {
+		assert.NoError(t, sm.SetTaskHandler("Task", task))
+	}
+
+	return sm.Execute(input)
+}
+
+func Test_Machine_StrictDataFlow_No_Merge(t *testing.T) {
+	json := `{
+    "StartAt": "Task",
+    "States": { "Task": { "Type": "Task", "Resource": "r", "End": true } }
+  }`
+
+	task := func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{"z": "y"}, nil
+	}
+
+	exec, err := executeStrict(json, task, map[string]interface{}{"a": "b"}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"z": "y"}, exec.Output)
+
+	// The default mode merges the output into the input
+	sm, err := FromJSON([]byte(json))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetTaskHandler("Task", task))
+
+	exec, err = sm.Execute(map[string]interface{}{"a": "b"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "b", "z": "y"}, exec.Output)
+}
+
+func Test_Machine_StrictDataFlow_Pipeline(t *testing.T) {
+	var taskInput interface{}
+	task := func(_ context.Context, input interface{}) (interface{}, error) {
+		taskInput = input
+		return map[string]interface{}{"id": 1, "extra": "dropped"}, nil
+	}
+
+	exec, err := executeStrict(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "r",
+        "InputPath": "$.request",
+        "Parameters": { "name.$": "$.name" },
+        "ResultSelector": { "id.$": "$.id" },
+        "ResultPath": "$.response",
+        "OutputPath": "$.response",
+        "Next": "Pass"
+      },
+      "Pass": { "Type": "Pass", "Result": "done", "ResultPath": "$.status", "End": true }
+    }
+  }`, task, map[string]interface{}{"request": map[string]interface{}{"name": "n", "other": "o"}}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"name": "n"}, taskInput)
+	assert.Equal(t, map[string]interface{}{"id": 1.0, "status": "done"}, exec.Output)
+}
+
+func Test_Machine_StrictDataFlow_Null_Paths(t *testing.T) {
+	var taskInput interface{}
+	task := func(_ context.Context, input interface{}) (interface{}, error) {
+		taskInput = input
+		return map[string]interface{}{"z": "y"}, nil
+	}
+
+	input := map[string]interface{}{"a": "b"}
+
+	// null InputPath passes {} and null ResultPath discards the result
+	exec, err := executeStrict(`{
+    "StartAt": "Task",
+    "States": { "Task": { "Type": "Task", "Resource": "r", "InputPath": null, "ResultPath": null, "End": true } }
+  }`, task, input, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, taskInput)
+	assert.Equal(t, input, exec.Output)
+
+	// null OutputPath outputs {}
+	exec, err = executeStrict(`{
+    "StartAt": "Task",
+    "States": { "Task": { "Type": "Task", "Resource": "r", "OutputPath": null, "End": true } }
+  }`, task, input, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{}, exec.Output)
+}
+
+func Test_Machine_StrictDataFlow_ResultPathMatchFailure(t *testing.T) {
+	task := func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{"z": "y"}, nil
+	}
+
+	json := `{
+    "StartAt": "Task",
+    "States": {
+      "Task": { "Type": "Task", "Resource": "r", "ResultPath": "$.a.b", "End": true }
+    }
+  }`
+
+	_, err := executeStrict(json, task, map[string]interface{}{"a": "not an object"}, t)
+	assert.Error(t, err)
+	assert.Regexp(t, "Unable to apply ResultPath", err.Error())
+
+	// The error can be caught, a null Catcher ResultPath keeps the input
+	exec, err := executeStrict(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "r",
+        "ResultPath": "$.a.b",
+        "Catch": [{ "ErrorEquals": ["States.ResultPathMatchFailure"], "ResultPath": null, "Next": "Caught" }],
+        "End": true
+      },
+      "Caught": { "Type": "Succeed" }
+    }
+  }`, task, map[string]interface{}{"a": "not an object"}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": "not an object"}, exec.Output)
+}
+
+func Test_Machine_Fail_State_Error_And_Cause(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Fail",
+    "States": {
+      "Fail": { "Type": "Fail", "ErrorPath": "$.error", "CausePath": "$.cause" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	exec, err := sm.Execute(map[string]interface{}{"error": "DeployError", "cause": "the real cause"})
+	assert.Error(t, err)
+	assert.Equal(t, err, exec.Error)
+	assert.Equal(t, "DeployError", to.ErrorType(exec.Error))
+	assert.Regexp(t, "the real cause", exec.Error.Error())
+
+	failed := exec.ExecutionHistory[len(exec.ExecutionHistory)-1]
+	assert.Equal(t, "ExecutionFailed", *failed.Type)
+	assert.Equal(t, "DeployError", *failed.ExecutionFailedEventDetails.Error)
+	assert.Equal(t, "the real cause", *failed.ExecutionFailedEventDetails.Cause)
+}
+
+func Test_Machine_Execution_History_Task_Events(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Choice",
+    "States": {
+      "Choice": {
+        "Type": "Choice",
+        "Choices": [{ "Variable": "$.go", "BooleanEquals": true, "Next": "Task" }],
+        "Default": "Task"
+      },
+      "Task": { "Type": "Task", "Resource": "arn:aws:lambda:eu-west-1:000000000000:function:fn", "TimeoutSeconds": 10, "End": true }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{"done": true}, nil
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{"go": true})
+	assert.NoError(t, err)
+
+	types := []string{}
+	for i, event := range exec.ExecutionHistory {
+		types = append(types, *event.Type)
+		assert.Equal(t, int64(i+1), *event.Id)
+		assert.Equal(t, int64(i), *event.PreviousEventId)
+	}
+
+	assert.Equal(t, []string{
+		"ExecutionStarted",
+		"ChoiceStateEntered",
+		"ChoiceStateExited",
+		"TaskStateEntered",
+		"TaskScheduled",
+		"TaskStarted",
+		"TaskSucceeded",
+		"TaskStateExited",
+		"ExecutionSucceeded",
+	}, types)
+
+	started := exec.ExecutionHistory[0].ExecutionStartedEventDetails
+	assert.Equal(t, `{"go":true}`, *started.Input)
+
+	scheduled := exec.ExecutionHistory[4].TaskScheduledEventDetails
+	assert.Equal(t, "lambda", *scheduled.ResourceType)
+	assert.Equal(t, "eu-west-1", *scheduled.Region)
+	assert.Equal(t, int64(10), *scheduled.TimeoutInSeconds)
+	assert.Equal(t, `{"go":true}`, *scheduled.Parameters)
+
+	succeeded := exec.ExecutionHistory[6].TaskSucceededEventDetails
+	assert.Equal(t, `{"done":true}`, *succeeded.Output)
+
+	assert.Equal(t, `{"done":true,"go":true}`, *exec.ExecutionHistory[8].ExecutionSucceededEventDetails.Output)
+
+	// Tools reading AWS histories work on local executions
+	sd, err := (&execution.Execution{}).GetStateDetails(&mocks.MockSFNClient{GetExecutionHistoryResp: exec.HistoryOutput(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, "Task", *sd.LastStateName)
+	assert.Equal(t, "Task", *sd.LastTaskName)
+}
+
+func Test_Machine_Execution_History_Task_Failed(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": { "Type": "Task", "Resource": "arn:aws:states:::lambda:invoke", "End": true }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("task broke")
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+
+	failed := exec.ExecutionHistory[4]
+	assert.Equal(t, "TaskFailed", *failed.Type)
+	assert.Equal(t, "lambda", *failed.TaskFailedEventDetails.ResourceType)
+	assert.Equal(t, "invoke", *failed.TaskFailedEventDetails.Resource)
+	assert.Equal(t, "task broke", *failed.TaskFailedEventDetails.Cause)
+}
+
+func Test_Machine_Integrations_Mixed_With_Handlers(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Work",
+    "States": {
+      "Work": { "Type": "Task", "Resource": "arn:aws:lambda:us-east-1:000000000000:function:work", "Next": "Notify" },
+      "Notify": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::sqs:sendMessage",
+        "Parameters": { "QueueUrl": "queue", "MessageBody.$": "$.done" },
+        "ResultPath": null,
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	services := state.NewFakeServices()
+	sm.Integrations = services.Integrations()
+
+	sm.SetTaskHandler("Work", func(_ context.Context, input interface{}) (interface{}, error) {
+		return map[string]interface{}{"done": "yes"}, nil
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "yes", exec.Output["done"])
+	assert.Equal(t, []string{"yes"}, services.Messages("queue"))
+}
+
+func Test_Machine_Concurrent_Executions_Are_Isolated(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "ResultPath": "$.branches",
+        "Branches": [
+          { "StartAt": "A", "States": { "A": { "Type": "Pass", "Result": "a", "ResultPath": "$.branch", "End": true }}},
+          { "StartAt": "B", "States": { "B": { "Type": "Pass", "Result": "b", "ResultPath": "$.branch", "End": true }}}
+        ],
+        "Next": "Flaky"
+      },
+      "Flaky": {
+        "Type": "Task",
+        "Resource": "arn:aws:lambda:us-east-1:000000000000:function:flaky",
+        "Retry": [{ "ErrorEquals": ["RetryableError"], "MaxAttempts": 2 }],
+        "ResultPath": "$.flaky",
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	// Every Execution fails twice before succeeding, so needs both its own attempts
+	var lock sync.Mutex
+	failures := map[interface{}]int{}
+	sm.SetTaskHandler("Flaky", func(_ context.Context, input map[string]interface{}) (interface{}, error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if failures[input["id"]] < 2 {
+			failures[input["id"]]++
+			return nil, RetryableError{}
+		}
+		return input["id"], nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(id float64) {
+			defer wg.Done()
+
+			exec, err := sm.Execute(map[string]interface{}{"id": id})
+			assert.NoError(t, err)
+			assert.Equal(t, id, exec.Output["flaky"])
+			assert.Nil(t, exec.Output["branch"])
+			assert.Equal(t, []interface{}{
+				map[string]interface{}{"id": id, "branch": "a"},
+				map[string]interface{}{"id": id, "branch": "b"},
+			}, exec.Output["branches"])
+		}(float64(i))
+	}
+	wg.Wait()
+}
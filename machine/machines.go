@@ -0,0 +1,276 @@
+// Local child executions of states:startExecution Tasks
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	steperrors "github.com/coinbase/step/errors"
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// Machines is a registry of local StateMachines by ARN, once added to Integrations
+// Task states with an "arn:aws:states:::states:startExecution" Resource run them as child executions
+type Machines struct {
+	lock     sync.RWMutex
+	machines map[string]*StateMachine
+}
+
+func NewMachines() *Machines {
+	return &Machines{machines: map[string]*StateMachine{}}
+}
+
+// Register adds sm as the State Machine with arn, e.g. "arn:aws:states:us-east-1:000000000000:stateMachine:Child"
+func (m *Machines) Register(arn string, sm *StateMachine) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.machines[arn] = sm
+}
+
+// Integrate registers the states:startExecution, .sync and .sync:2 Integrations
+func (m *Machines) Integrate(integrations *state.Integrations) {
+	integrations.Register("arn:aws:states:::states:startExecution*", m.startExecution)
+}
+
+// Find returns the State Machine with arn, or with the same name
+func (m *Machines) Find(arn string) (*StateMachine, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if sm, ok := m.machines[arn]; ok {
+		return sm, true
+	}
+
+	for registered, sm := range m.machines {
+		if stateMachineName(registered) == stateMachineName(arn) {
+			return sm, true
+		}
+	}
+
+	return nil, false
+}
+
+func (m *Machines) startExecution(ctx context.Context, resource string, parameters interface{}) (interface{}, error) {
+	params, ok := parameters.(map[string]interface{})
+	if !ok {
+		return nil, steperrors.StatesError{Name: "States.Runtime", Cause: "Parameters must be an object"}
+	}
+
+	smArn, _ := params["StateMachineArn"].(string)
+	child, ok := m.Find(smArn)
+	if !ok {
+		return nil, steperrors.StatesError{
+			Name:  "StepFunctions.StateMachineDoesNotExistException",
+			Cause: fmt.Sprintf("State Machine Does Not Exist: %q", smArn),
+		}
+	}
+
+	input, err := childInput(params["Input"])
+	if err != nil {
+		return nil, err
+	}
+
+	name, _ := params["Name"].(string)
+	if name == "" {
+		name = *to.TimeUUID("execution-")
+	}
+
+	parent, _ := ctx.Value(executionKey{}).(*Execution)
+	execArn := localExecutionArn(stateMachineName(smArn), name)
+
+	// A .sync child is cancelled with the parent, one started in the background outlives it,
+	// neither inherits the parents settings
+	async := !strings.HasSuffix(resource, ".sync") && !strings.HasSuffix(resource, ".sync:2")
+	childCtx := context.Context(childContext{ctx})
+	if async {
+		childCtx = childContext{detachedContext{ctx}}
+	}
+	started := make(chan struct{})
+	childCtx = context.WithValue(childCtx, executionStartKey{}, executionStart{
+		executionArn: execArn,
+		parent:       parent,
+		started:      func(*Execution) { close(started) },
+	})
+	if integrations := state.ContextIntegrations(ctx); integrations != nil {
+		childCtx = state.WithIntegrations(childCtx, integrations)
+	}
+	if callbacks := state.ContextCallbacks(ctx); callbacks != nil {
+		childCtx = state.WithCallbacks(childCtx, callbacks)
+	}
+
+	if !async {
+		exec, err := child.ExecuteContext(childCtx, input)
+		if exec == nil {
+			return nil, steperrors.StatesError{Name: "States.TaskFailed", Cause: fmt.Sprintf("%v", err)}
+		}
+
+		output := describeExecution(exec, smArn, name, strings.HasSuffix(resource, ":2"))
+		if err != nil {
+			cause, _ := to.CompactJSON(output)
+			return nil, steperrors.StatesError{Name: "States.TaskFailed", Cause: cause}
+		}
+		return output, nil
+	}
+
+	// Wait until the child is in the parents Children, or failed to start
+	failed := make(chan error, 1)
+	go func() {
+		if exec, err := child.ExecuteContext(childCtx, input); exec == nil {
+			failed <- err
+		}
+	}()
+
+	select {
+	case <-started:
+	case err := <-failed:
+		return nil, steperrors.StatesError{Name: "States.TaskFailed", Cause: fmt.Sprintf("%v", err)}
+	}
+
+	return map[string]interface{}{
+		"ExecutionArn": execArn,
+		"StartDate":    epochMillis(time.Now()),
+	}, nil
+}
+
+// childInput returns the Input parameter, a JSON string or any JSON value
+func childInput(input interface{}) (interface{}, error) {
+	switch value := input.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case string:
+		var parsed interface{}
+		if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+			return nil, steperrors.StatesError{Name: "States.Runtime", Cause: fmt.Sprintf("Input is not JSON: %v", err)}
+		}
+		return parsed, nil
+	default:
+		return value, nil
+	}
+}
+
+// describeExecution returns the DescribeExecution response of exec,
+// with the Input and Output as JSON strings or, for .sync:2, as JSON values
+func describeExecution(exec *Execution, smArn string, name string, jsonValues bool) map[string]interface{} {
+	history := exec.ExecutionHistory
+	last := history[len(history)-1]
+
+	output := map[string]interface{}{
+		"ExecutionArn":    exec.ExecutionArn,
+		"StateMachineArn": smArn,
+		"Name":            name,
+		"StartDate":       epochMillis(*history[0].Timestamp),
+		"StopDate":        epochMillis(*last.Timestamp),
+		"InputDetails":    map[string]interface{}{"Included": true},
+	}
+
+	input := ""
+	if history[0].ExecutionStartedEventDetails != nil {
+		input = to.Strs(history[0].ExecutionStartedEventDetails.Input)
+	}
+
+	output["Status"] = exec.Status()
+	if *last.Type == "ExecutionSucceeded" {
+		output["OutputDetails"] = map[string]interface{}{"Included": true}
+		output["Output"] = jsonValue(to.Strs(last.ExecutionSucceededEventDetails.Output), jsonValues)
+	}
+
+	if exec.Error != nil {
+		output["Error"], output["Cause"] = errorAndCause(exec.Error)
+	}
+
+	output["Input"] = jsonValue(input, jsonValues)
+
+	return output
+}
+
+func jsonValue(raw string, parse bool) interface{} {
+	if !parse {
+		return raw
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		return raw
+	}
+	return value
+}
+
+func epochMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// stateMachineName returns the name of a State Machine ARN, or the name itself
+func stateMachineName(arn string) string {
+	parts := strings.Split(arn, ":")
+	return parts[len(parts)-1]
+}
+
+func localExecutionArn(smName string, name string) string {
+	return fmt.Sprintf("arn:aws:states:us-east-1:000000000000:execution:%v:%v", smName, name)
+}
+
+// executionContextObject adds $$.Execution to the Context Object of ctx
+func executionContextObject(ctx context.Context, exec *Execution, input interface{}, started time.Time) map[string]interface{} {
+	contextObject := map[string]interface{}{}
+	for k, v := range state.ContextObject(ctx) {
+		contextObject[k] = v
+	}
+
+	contextObject["Execution"] = map[string]interface{}{
+		"Id":        exec.ExecutionArn,
+		"Name":      stateMachineName(exec.ExecutionArn),
+		"Input":     input,
+		"StartTime": started.UTC().Format(time.RFC3339),
+	}
+
+	return contextObject
+}
+
+type executionKey struct{}
+
+type executionStartKey struct{}
+
+// executionStart sets up the Execution started with a Context
+type executionStart struct {
+	executionArn string
+	parent       *Execution
+	started      func(*Execution) // called once the Execution has its ARN
+}
+
+// WithExecutionArn returns a Context whose Execution has arn as its ExecutionArn and $$.Execution.Id
+func WithExecutionArn(ctx context.Context, arn string) context.Context {
+	start, _ := ctx.Value(executionStartKey{}).(executionStart)
+	start.executionArn = arn
+	return context.WithValue(ctx, executionStartKey{}, start)
+}
+
+// childContext is cancelled with its parent but holds none of its values
+type childContext struct {
+	context.Context
+}
+
+func (childContext) Value(key interface{}) interface{} {
+	return nil
+}
+
+// detachedContext holds the values of its parent but is never cancelled
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
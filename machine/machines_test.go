@@ -0,0 +1,162 @@
+package machine
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+var childMachine = `{
+  "StartAt": "Double",
+  "States": {
+    "Double": { "Type": "Task", "Resource": "arn:aws:lambda:us-east-1:000000000000:function:double", "End": true }
+  }
+}`
+
+var failingChildMachine = `{
+  "StartAt": "Fail",
+  "States": {
+    "Fail": { "Type": "Fail", "Error": "ChildError", "Cause": "child failed" }
+  }
+}`
+
+func parentMachine(resource string, child string) string {
+	return `{
+  "StartAt": "StartChild",
+  "States": {
+    "StartChild": {
+      "Type": "Task",
+      "Resource": "` + resource + `",
+      "Parameters": {
+        "StateMachineArn": "arn:aws:states:us-east-1:000000000000:stateMachine:` + child + `",
+        "Input": { "n.$": "$.n", "parent.$": "$$.Execution.Id" }
+      },
+      "ResultPath": "$.child",
+      "Catch": [{ "ErrorEquals": ["States.TaskFailed"], "ResultPath": "$.error", "Next": "Failed" }],
+      "End": true
+    },
+    "Failed": { "Type": "Pass", "End": true }
+  }
+}`
+}
+
+func registeredMachines(t *testing.T, resource string, child string) *StateMachine {
+	services := state.NewFakeServices()
+	services.SetFunction("double", func(_ context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"n": input["n"].(float64) * 2, "parent": input["parent"]}, nil
+	})
+
+	childSM, err := FromJSON([]byte(childMachine))
+	assert.NoError(t, err)
+
+	failingSM, err := FromJSON([]byte(failingChildMachine))
+	assert.NoError(t, err)
+
+	machines := NewMachines()
+	machines.Register("arn:aws:states:us-east-1:000000000000:stateMachine:Child", childSM)
+	machines.Register("arn:aws:states:us-east-1:000000000000:stateMachine:FailingChild", failingSM)
+
+	integrations := services.Integrations()
+	machines.Integrate(integrations)
+
+	parent, err := FromJSON([]byte(parentMachine(resource, child)))
+	assert.NoError(t, err)
+	parent.Integrations = integrations
+
+	return parent
+}
+
+func Test_Machines_StartExecutionSync2(t *testing.T) {
+	parent := registeredMachines(t, "arn:aws:states:::states:startExecution.sync:2", "Child")
+
+	exec, err := parent.Execute(map[string]interface{}{"n": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"StartChild"}, exec.Path())
+
+	child := exec.Output["child"].(map[string]interface{})
+	assert.Equal(t, "SUCCEEDED", child["Status"])
+	assert.Equal(t, map[string]interface{}{"n": 4.0, "parent": exec.ExecutionArn}, child["Output"])
+
+	// The child execution links back to the parent
+	children := exec.Children()
+	assert.Equal(t, 1, len(children))
+	assert.Equal(t, exec.ExecutionArn, children[0].ParentExecutionArn)
+	assert.Equal(t, child["ExecutionArn"], children[0].ExecutionArn)
+	assert.Equal(t, []string{"Double"}, children[0].Path())
+}
+
+func Test_Machines_StartExecutionSync(t *testing.T) {
+	parent := registeredMachines(t, "arn:aws:states:::states:startExecution.sync", "Child")
+
+	exec, err := parent.Execute(map[string]interface{}{"n": 3})
+	assert.NoError(t, err)
+
+	child := exec.Output["child"].(map[string]interface{})
+	assert.Equal(t, "SUCCEEDED", child["Status"])
+	assert.JSONEq(t, `{"n": 6, "parent": "`+exec.ExecutionArn+`"}`, child["Output"].(string))
+}
+
+func Test_Machines_StartExecutionSync_ChildFailure(t *testing.T) {
+	parent := registeredMachines(t, "arn:aws:states:::states:startExecution.sync:2", "FailingChild")
+
+	exec, err := parent.Execute(map[string]interface{}{"n": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"StartChild", "Failed"}, exec.Path())
+
+	caught := exec.Output["error"].(map[string]interface{})
+	assert.Equal(t, "States.TaskFailed", caught["Error"])
+	assert.Contains(t, caught["Cause"], `"Status":"FAILED"`)
+	assert.Contains(t, caught["Cause"], `"Error":"ChildError"`)
+}
+
+func Test_Machines_StartExecution_UnknownMachine(t *testing.T) {
+	parent := registeredMachines(t, "arn:aws:states:::states:startExecution.sync", "Missing")
+
+	_, err := parent.Execute(map[string]interface{}{"n": 1})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "StepFunctions.StateMachineDoesNotExistException")
+}
+
+func Test_Machines_StartExecution_ChildOutlivesParent(t *testing.T) {
+	release := make(chan struct{})
+	services := state.NewFakeServices()
+	services.SetFunction("double", func(_ context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+		<-release
+		return map[string]interface{}{"n": input["n"].(float64) * 2}, nil
+	})
+
+	childSM, err := FromJSON([]byte(childMachine))
+	assert.NoError(t, err)
+
+	machines := NewMachines()
+	machines.Register("arn:aws:states:us-east-1:000000000000:stateMachine:Child", childSM)
+
+	integrations := services.Integrations()
+	machines.Integrate(integrations)
+
+	parent, err := FromJSON([]byte(parentMachine("arn:aws:states:::states:startExecution", "Child")))
+	assert.NoError(t, err)
+	parent.Integrations = integrations
+
+	ctx, cancel := context.WithCancel(context.Background())
+	exec, err := parent.ExecuteContext(ctx, map[string]interface{}{"n": 2})
+	assert.NoError(t, err)
+	assert.Equal(t, "SUCCEEDED", exec.Status())
+
+	// The parent is done and its Context cancelled while the child still runs
+	cancel()
+	close(release)
+
+	children := exec.Children()
+	assert.Equal(t, 1, len(children))
+
+	for i := 0; i < 1000 && children[0].Status() == "RUNNING"; i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	assert.Equal(t, "SUCCEEDED", children[0].Status())
+	assert.Equal(t, 4.0, children[0].Output["n"])
+}
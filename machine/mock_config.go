@@ -0,0 +1,260 @@
+// Mocked Task responses in the format of the AWS Step Functions Local MockConfig file
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	steperrors "github.com/coinbase/step/errors"
+	"github.com/coinbase/step/utils/to"
+)
+
+// MockConfig holds named test cases per State Machine, each mapping Task names to a MockedResponse:
+//
+//	{
+//	  "StateMachines": {
+//	    "Deployer": { "TestCases": { "RetryPath": { "Lock": "FailTwice" } } }
+//	  },
+//	  "MockedResponses": {
+//	    "FailTwice": {
+//	      "0-1": { "Throw": { "Error": "LockError", "Cause": "locked" } },
+//	      "2": { "Return": { "locked": true } }
+//	    }
+//	  }
+//	}
+type MockConfig struct {
+	StateMachines   map[string]*MockStateMachine
+	MockedResponses map[string]MockedResponse
+}
+
+// MockStateMachine maps each test case name to the MockedResponse name of each Task
+type MockStateMachine struct {
+	TestCases map[string]map[string]string
+}
+
+// MockedResponse maps invocation numbers, e.g. "0" or the range "1-3", to the response of those invocations
+type MockedResponse map[string]*MockResponse
+
+// MockResponse either Returns a result, which can be null, or Throws an error
+type MockResponse struct {
+	Return interface{} `json:",omitempty"`
+	Throw  *MockThrow  `json:",omitempty"`
+
+	returnsNull bool // "Return": null was set
+}
+
+// UnmarshalJSON records a "Return": null, which is otherwise the same as no Return
+func (mr *MockResponse) UnmarshalJSON(raw []byte) error {
+	type plainResponse MockResponse
+	if err := json.Unmarshal(raw, (*plainResponse)(mr)); err != nil {
+		return err
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return err
+	}
+
+	_, hasReturn := fields["Return"]
+	mr.returnsNull = hasReturn && mr.Return == nil
+	return nil
+}
+
+func (mr *MockResponse) returns() bool {
+	return mr.Return != nil || mr.returnsNull
+}
+
+type MockThrow struct {
+	Error string
+	Cause string `json:",omitempty"`
+}
+
+// ParseMockConfigFile reads and validates a MockConfig file
+func ParseMockConfigFile(file string) (*MockConfig, error) {
+	raw, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseMockConfig(raw)
+}
+
+// ParseMockConfig parses and validates a MockConfig
+func ParseMockConfig(raw []byte) (*MockConfig, error) {
+	var config MockConfig
+	if err := json.Unmarshal(raw, &config); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// Validate checks every test case references a MockedResponse and every response is well formed
+func (mc *MockConfig) Validate() error {
+	for smName, sm := range mc.StateMachines {
+		if sm == nil {
+			return fmt.Errorf("MockConfig Error: StateMachine %q is null", smName)
+		}
+
+		for caseName, tasks := range sm.TestCases {
+			for taskName, responseName := range tasks {
+				if _, ok := mc.MockedResponses[responseName]; !ok {
+					return fmt.Errorf("MockConfig Error: %v TestCase %v Task %v Unknown MockedResponse %q", smName, caseName, taskName, responseName)
+				}
+			}
+		}
+	}
+
+	for name, response := range mc.MockedResponses {
+		if _, err := response.sequence(); err != nil {
+			return fmt.Errorf("MockConfig Error: MockedResponse %v %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// SetMockTestCase sets the handler of each Task in the test case to return its MockedResponse in sequence
+func (sm *StateMachine) SetMockTestCase(config *MockConfig, smName string, caseName string) error {
+	mockSM, ok := config.StateMachines[smName]
+	if !ok {
+		return fmt.Errorf("MockConfig Error: Unknown StateMachine %q", smName)
+	}
+
+	tasks, ok := mockSM.TestCases[caseName]
+	if !ok {
+		return fmt.Errorf("MockConfig Error: %v Unknown TestCase %q", smName, caseName)
+	}
+
+	for taskName, responseName := range tasks {
+		sequence, err := config.MockedResponses[responseName].sequence()
+		if err != nil {
+			return fmt.Errorf("MockConfig Error: MockedResponse %v %v", responseName, err)
+		}
+
+		if err := sm.SetTaskHandler(taskName, mockHandler(taskName, sequence)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sequence returns the responses ordered by invocation, checking every invocation from 0 is defined once
+func (mr MockedResponse) sequence() ([]*MockResponse, error) {
+	byInvocation := map[int]*MockResponse{}
+
+	keys := []string{}
+	for key := range mr {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		response := mr[key]
+		if response == nil || response.returns() == (response.Throw != nil) {
+			return nil, fmt.Errorf("%q must have exactly one of Return or Throw", key)
+		}
+
+		if response.Throw != nil && response.Throw.Error == "" {
+			return nil, fmt.Errorf("%q Throw must have Error", key)
+		}
+
+		first, last, err := invocationRange(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := first; i <= last; i++ {
+			if _, ok := byInvocation[i]; ok {
+				return nil, fmt.Errorf("invocation %v defined twice", i)
+			}
+			byInvocation[i] = response
+		}
+	}
+
+	sequence := make([]*MockResponse, len(byInvocation))
+	for i := range sequence {
+		response, ok := byInvocation[i]
+		if !ok {
+			return nil, fmt.Errorf("invocation %v is not defined", i)
+		}
+		sequence[i] = response
+	}
+
+	return sequence, nil
+}
+
+// invocationRange parses "2" or "1-3"
+func invocationRange(key string) (int, int, error) {
+	parts := strings.SplitN(key, "-", 2)
+
+	first, err := strconv.Atoi(parts[0])
+	if err != nil || first < 0 {
+		return 0, 0, fmt.Errorf("invalid invocation %q", key)
+	}
+
+	if len(parts) == 1 {
+		return first, first, nil
+	}
+
+	last, err := strconv.Atoi(parts[1])
+	if err != nil || last < first {
+		return 0, 0, fmt.Errorf("invalid invocation range %q", key)
+	}
+
+	return first, last, nil
+}
+
+// mockKey identifies a mockHandler in the invocations counted by each Execution
+type mockKey struct {
+	taskName string
+}
+
+// mockHandler returns the responses in sequence, counting the invocations of each Execution on the Execution,
+// safe for concurrent Map iterations and Executions
+func mockHandler(taskName string, sequence []*MockResponse) func(context.Context, interface{}) (interface{}, error) {
+	key := &mockKey{taskName: taskName}
+
+	// Outside an Execution, e.g. a State executed on its own, the handler counts its invocations
+	var lock sync.Mutex
+	invocations := 0
+
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		var i int
+		if exec, ok := ctx.Value(executionKey{}).(*Execution); ok && exec != nil {
+			i = exec.invoked(key)
+		} else {
+			lock.Lock()
+			i = invocations
+			invocations++
+			lock.Unlock()
+		}
+
+		if i >= len(sequence) {
+			return nil, fmt.Errorf("MockConfig Error: Task %v has no MockedResponse for invocation %v", taskName, i)
+		}
+
+		response := sequence[i]
+		if response.Throw != nil {
+			return nil, steperrors.StatesError{Name: response.Throw.Error, Cause: response.Throw.Cause}
+		}
+
+		if response.Return == nil {
+			return nil, nil
+		}
+
+		// A copy, so a State changing its output does not change the next response
+		return to.FromJSON(response.Return)
+	}
+}
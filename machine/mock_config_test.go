@@ -0,0 +1,156 @@
+package machine
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var mockMachine = `{
+  "StartAt": "Lock",
+  "States": {
+    "Lock": {
+      "Type": "Task",
+      "Resource": "r",
+      "Retry": [{ "ErrorEquals": ["LockError"], "MaxAttempts": 2 }],
+      "Catch": [{ "ErrorEquals": ["States.ALL"], "ResultPath": "$.error", "Next": "Failed" }],
+      "Next": "Done"
+    },
+    "Failed": { "Type": "Fail", "Error": "Failed" },
+    "Done": { "Type": "Succeed" }
+  }
+}`
+
+var mockConfig = `{
+  "StateMachines": {
+    "Locker": {
+      "TestCases": {
+        "HappyPath": { "Lock": "Locked" },
+        "RetryPath": { "Lock": "FailTwiceThenLock" },
+        "CatchPath": { "Lock": "Broken" }
+      }
+    }
+  },
+  "MockedResponses": {
+    "Locked": { "0": { "Return": { "locked": true } } },
+    "FailTwiceThenLock": {
+      "0-1": { "Throw": { "Error": "LockError", "Cause": "already locked" } },
+      "2": { "Return": { "locked": true } }
+    },
+    "Broken": { "0": { "Throw": { "Error": "BadError", "Cause": "broken" } } }
+  }
+}`
+
+func executeMockTestCase(testCase string, t *testing.T) (*Execution, error) {
+	sm, err := FromJSON([]byte(mockMachine))
+	assert.NoError(t, err)
+
+	config, err := ParseMockConfig([]byte(mockConfig))
+	assert.NoError(t, err)
+
+	assert.NoError(t, sm.SetMockTestCase(config, "Locker", testCase))
+	return sm.Execute(map[string]interface{}{})
+}
+
+func Test_MockConfig_TestCases(t *testing.T) {
+	exec, err := executeMockTestCase("HappyPath", t)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Lock", "Done"}, exec.Path())
+	assert.Equal(t, true, exec.Output["locked"])
+
+	exec, err = executeMockTestCase("RetryPath", t)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Lock", "Lock", "Lock", "Done"}, exec.Path())
+
+	exec, err = executeMockTestCase("CatchPath", t)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Lock", "Failed"}, exec.Path())
+	assert.Equal(t, map[string]interface{}{"Error": "BadError", "Cause": "broken"}, exec.LastOutput["error"])
+}
+
+func Test_MockConfig_Errors(t *testing.T) {
+	_, err := ParseMockConfig([]byte(`{
+    "StateMachines": { "A": { "TestCases": { "T": { "Task": "Unknown" } } } },
+    "MockedResponses": {}
+  }`))
+	assert.Error(t, err)
+	assert.Regexp(t, "Unknown MockedResponse", err.Error())
+
+	_, err = ParseMockConfig([]byte(`{
+    "MockedResponses": { "Gap": { "0": { "Return": {} }, "2": { "Return": {} } } }
+  }`))
+	assert.Error(t, err)
+	assert.Regexp(t, "invocation 1 is not defined", err.Error())
+
+	_, err = ParseMockConfig([]byte(`{
+    "MockedResponses": { "Both": { "0": { "Return": {}, "Throw": { "Error": "E" } } } }
+  }`))
+	assert.Error(t, err)
+	assert.Regexp(t, "exactly one of Return or Throw", err.Error())
+
+	_, err = ParseMockConfig([]byte(`{
+    "MockedResponses": { "Overlap": { "0-2": { "Return": {} }, "1": { "Return": {} } } }
+  }`))
+	assert.Error(t, err)
+	assert.Regexp(t, "defined twice", err.Error())
+
+	// Running out of responses fails the Task
+	sm, err := FromJSON([]byte(mockMachine))
+	assert.NoError(t, err)
+
+	config, err := ParseMockConfig([]byte(`{
+    "StateMachines": { "Locker": { "TestCases": { "Short": { "Lock": "Once" } } } },
+    "MockedResponses": { "Once": { "0": { "Throw": { "Error": "LockError" } } } }
+  }`))
+	assert.NoError(t, err)
+	assert.Error(t, sm.SetMockTestCase(config, "Locker", "Unknown"))
+	assert.NoError(t, sm.SetMockTestCase(config, "Locker", "Short"))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "no MockedResponse for invocation 1", exec.LastOutput["error"].(map[string]interface{})["Cause"])
+}
+
+func Test_MockConfig_Per_Execution(t *testing.T) {
+	sm, err := FromJSON([]byte(mockMachine))
+	assert.NoError(t, err)
+
+	config, err := ParseMockConfig([]byte(mockConfig))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetMockTestCase(config, "Locker", "RetryPath"))
+
+	// Every Execution starts from the first response
+	for i := 0; i < 2; i++ {
+		exec, err := sm.Execute(map[string]interface{}{})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"Lock", "Lock", "Lock", "Done"}, exec.Path())
+
+		// The response is copied, changing the output does not change the config
+		exec.Output["locked"] = false
+	}
+
+	assert.Equal(t, map[string]interface{}{"locked": true}, config.MockedResponses["FailTwiceThenLock"]["2"].Return)
+}
+
+func Test_MockConfig_Return_Null(t *testing.T) {
+	sm, err := FromJSON([]byte(mockMachine))
+	assert.NoError(t, err)
+
+	config, err := ParseMockConfig([]byte(`{
+    "StateMachines": { "Locker": { "TestCases": { "Null": { "Lock": "Null" } } } },
+    "MockedResponses": { "Null": { "0": { "Return": null } } }
+  }`))
+	assert.NoError(t, err)
+	assert.NoError(t, sm.SetMockTestCase(config, "Locker", "Null"))
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Lock", "Done"}, exec.Path())
+
+	_, err = ParseMockConfig([]byte(`{ "MockedResponses": { "Neither": { "0": {} } } }`))
+	assert.Error(t, err)
+	assert.Regexp(t, "exactly one of Return or Throw", err.Error())
+
+	_, err = ParseMockConfig([]byte(`{ "MockedResponses": { "Both": { "0": { "Return": null, "Throw": { "Error": "E" } } } } }`))
+	assert.Error(t, err)
+}
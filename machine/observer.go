@@ -0,0 +1,148 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/coinbase/step/machine/state"
+)
+
+// StateEvent describes a State of an Execution to an Observer
+type StateEvent struct {
+	State state.State
+
+	Input  interface{}
+	Output interface{} // set once the State exits
+	Error  error       // the error Exited with, Retried or Caught
+
+	Entered  time.Time
+	Duration time.Duration // time since Entered
+}
+
+// Observer is notified through the lifecycle of an Execution, e.g. to log, collect metrics, trace or assert.
+// States in Parallel Branches and Map iterations are observed too, possibly concurrently.
+type Observer interface {
+	OnExecutionStart(input interface{}, started time.Time)
+	OnStateEntered(event StateEvent)
+	OnStateExited(event StateEvent)
+	OnRetry(event StateEvent, attempt int, interval time.Duration)
+	OnCatch(event StateEvent, next string)
+	OnExecutionEnd(exec *Execution, duration time.Duration)
+}
+
+// NoopObserver implements every Observer callback doing nothing,
+// embed it to implement only some of them
+type NoopObserver struct{}
+
+func (NoopObserver) OnExecutionStart(input interface{}, started time.Time)         {}
+func (NoopObserver) OnStateEntered(event StateEvent)                               {}
+func (NoopObserver) OnStateExited(event StateEvent)                                {}
+func (NoopObserver) OnRetry(event StateEvent, attempt int, interval time.Duration) {}
+func (NoopObserver) OnCatch(event StateEvent, next string)                         {}
+func (NoopObserver) OnExecutionEnd(exec *Execution, duration time.Duration)        {}
+
+// AddObserver registers an Observer for every Execution of the machine
+func (sm *StateMachine) AddObserver(observer Observer) {
+	sm.Observers = append(sm.Observers, observer)
+}
+
+// WithObserver returns a Context that adds observer to the Observers of the Executions run with it,
+// without changing the StateMachine so other Executions are not observed
+func WithObserver(ctx context.Context, observer Observer) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	observers := append([]Observer{}, contextObservers(ctx)...)
+	return withObservers(ctx, append(observers, observer))
+}
+
+type observersKey struct{}
+
+// withObservers passes the observers of the Execution down to nested Branches
+func withObservers(ctx context.Context, observers []Observer) context.Context {
+	return context.WithValue(ctx, observersKey{}, observers)
+}
+
+func contextObservers(ctx context.Context) []Observer {
+	observers, _ := ctx.Value(observersKey{}).([]Observer)
+	return observers
+}
+
+// stateRecorder records the Edges taken by the States of one state loop and notifies the observers,
+// it is the state.TransitionRecorder for the current State
+type stateRecorder struct {
+	observers []Observer
+	states    States
+	exec      *Execution
+
+	current StateEvent
+	label   string // of the Edge taken by the current State, default "Next"
+}
+
+func (o *stateRecorder) entered(s state.State, input interface{}) {
+	o.current = StateEvent{State: s, Input: input, Entered: o.exec.now()}
+	o.label = "Next"
+
+	for _, observer := range o.observers {
+		observer.OnStateEntered(o.current)
+	}
+}
+
+func (o *stateRecorder) exited(output interface{}, next *string, err error) {
+	if err == nil && next != nil {
+		o.exec.addEdge(Edge{From: *o.current.State.Name(), To: *next, Label: o.label})
+	}
+
+	if len(o.observers) == 0 {
+		return
+	}
+
+	event := o.event(err)
+	event.Output = output
+	for _, observer := range o.observers {
+		observer.OnStateExited(event)
+	}
+}
+
+func (o *stateRecorder) Retried(stateName string, retrier int, err error, attempt int, interval time.Duration) {
+	o.label = fmt.Sprintf("Retry[%v]", retrier)
+
+	event := o.eventFor(stateName, err)
+	for _, observer := range o.observers {
+		observer.OnRetry(event, attempt, interval)
+	}
+}
+
+func (o *stateRecorder) Caught(stateName string, catcher int, err error, next string) {
+	o.label = fmt.Sprintf("Catch[%v]", catcher)
+
+	event := o.eventFor(stateName, err)
+	for _, observer := range o.observers {
+		observer.OnCatch(event, next)
+	}
+}
+
+func (o *stateRecorder) Chose(stateName string, choice int, next string) {
+	if choice < 0 {
+		o.label = "Default"
+	} else {
+		o.label = fmt.Sprintf("Choices[%v]", choice)
+	}
+}
+
+func (o *stateRecorder) event(err error) StateEvent {
+	event := o.current
+	event.Error = err
+	event.Duration = o.exec.now().Sub(event.Entered)
+	return event
+}
+
+// eventFor returns the event of the current State, or a new one if stateName is another State
+func (o *stateRecorder) eventFor(stateName string, err error) StateEvent {
+	if o.current.State == nil || *o.current.State.Name() != stateName {
+		o.current = StateEvent{State: o.states[stateName], Entered: o.exec.now()}
+	}
+	return o.event(err)
+}
@@ -0,0 +1,188 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingObserver struct {
+	NoopObserver
+	sync.Mutex
+
+	calls []string
+	end   *Execution
+}
+
+func (o *recordingObserver) record(format string, args ...interface{}) {
+	o.Lock()
+	defer o.Unlock()
+	o.calls = append(o.calls, fmt.Sprintf(format, args...))
+}
+
+func (o *recordingObserver) OnExecutionStart(input interface{}, started time.Time) {
+	o.record("start %v", input)
+}
+
+func (o *recordingObserver) OnStateEntered(event StateEvent) {
+	o.record("entered %v", *event.State.Name())
+}
+
+func (o *recordingObserver) OnStateExited(event StateEvent) {
+	o.record("exited %v %v", *event.State.Name(), event.Error != nil)
+}
+
+func (o *recordingObserver) OnRetry(event StateEvent, attempt int, interval time.Duration) {
+	o.record("retry %v %v %v", *event.State.Name(), attempt, interval)
+}
+
+func (o *recordingObserver) OnCatch(event StateEvent, next string) {
+	o.record("catch %v %v", *event.State.Name(), next)
+}
+
+func (o *recordingObserver) OnExecutionEnd(exec *Execution, duration time.Duration) {
+	o.record("end %v", exec.Error == nil)
+	o.end = exec
+}
+
+func Test_Observer_Retry_And_Catch(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "r",
+        "Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 1, "IntervalSeconds": 2 }],
+        "Catch": [{ "ErrorEquals": ["States.ALL"], "Next": "Caught" }],
+        "End": true
+      },
+      "Caught": { "Type": "Pass", "End": true }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Task", func(_ context.Context, input interface{}) (interface{}, error) {
+		return nil, fmt.Errorf("broken")
+	})
+
+	observer := &recordingObserver{}
+	sm.AddObserver(observer)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, exec, observer.end)
+
+	assert.Equal(t, []string{
+		"start map[]",
+		"entered Task",
+		"retry Task 1 2s",
+		"exited Task false",
+		"entered Task",
+		"catch Task Caught",
+		"exited Task false",
+		"entered Caught",
+		"exited Caught false",
+		"end true",
+	}, observer.calls)
+}
+
+func Test_Observer_Timing_And_Failure(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Wait",
+    "States": {
+      "Wait": { "Type": "Wait", "Seconds": 10, "Next": "Fail" },
+      "Fail": { "Type": "Fail", "Error": "Bad" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	var waited time.Duration
+	var failed error
+
+	observer := &timingObserver{exited: func(event StateEvent) {
+		switch *event.State.Name() {
+		case "Wait":
+			waited = event.Duration
+		case "Fail":
+			failed = event.Error
+		}
+	}}
+	sm.AddObserver(observer)
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+
+	assert.Equal(t, 10*time.Second, waited)
+	assert.Error(t, failed)
+}
+
+type timingObserver struct {
+	NoopObserver
+	exited func(StateEvent)
+}
+
+func (o *timingObserver) OnStateExited(event StateEvent) {
+	o.exited(event)
+}
+
+func Test_Observer_Parallel_Branches(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Parallel",
+    "States": {
+      "Parallel": {
+        "Type": "Parallel",
+        "Branches": [
+          { "StartAt": "A", "States": { "A": { "Type": "Pass", "End": true } } },
+          { "StartAt": "B", "States": { "B": { "Type": "Pass", "End": true } } }
+        ],
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	observer := &recordingObserver{}
+	sm.AddObserver(observer)
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	assert.Contains(t, observer.calls, "entered A")
+	assert.Contains(t, observer.calls, "exited B false")
+	assert.Equal(t, "entered Parallel", observer.calls[1])
+	assert.Equal(t, "exited Parallel false", observer.calls[len(observer.calls)-2])
+}
+
+func Test_Observer_And_Breakpoint_From_Context(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "A",
+    "States": {
+      "A": { "Type": "Pass", "Next": "B" },
+      "B": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	observer := &recordingObserver{}
+	paused := []string{}
+	breakpoint := func(s state.State, input interface{}) (interface{}, error) {
+		paused = append(paused, *s.Name())
+		return input, nil
+	}
+
+	ctx := WithBreakpoint(WithObserver(context.Background(), observer), breakpoint)
+	_, err = sm.ExecuteContext(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+
+	// Other Executions are not observed
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, []string{"A", "B"}, paused)
+	assert.Equal(t, []string{"start map[]", "entered A", "exited A false", "entered B", "exited B false", "end true"}, observer.calls)
+}
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"reflect"
 
+	"github.com/coinbase/step/jsonpath"
 	"github.com/coinbase/step/machine/state"
 	"github.com/coinbase/step/utils/to"
 )
@@ -56,6 +58,19 @@ type stateType struct {
 	Type string
 }
 
+// parallelState shadows the Branches to parse them as nested State Machines
+type parallelState struct {
+	state.ParallelState
+	Branches []*StateMachine
+}
+
+// mapState shadows the Iterator and ItemProcessor to parse them as nested State Machines
+type mapState struct {
+	state.MapState
+	Iterator      *StateMachine
+	ItemProcessor *StateMachine
+}
+
 func unmarshallState(name string, raw_json *json.RawMessage) ([]state.State, error) {
 	var err error
 
@@ -93,9 +108,27 @@ func unmarshallState(name string, raw_json *json.RawMessage) ([]state.State, err
 		err = json.Unmarshal(*raw_json, &s)
 		newState = &s
 	case "Parallel":
-		var s state.ParallelState
-		err = json.Unmarshal(*raw_json, &s)
-		newState = &s
+		var ps parallelState
+		err = json.Unmarshal(*raw_json, &ps)
+		for _, branch := range ps.Branches {
+			if branch == nil {
+				err = fmt.Errorf("Parallel State %q has null Branch", name)
+				break
+			}
+			ps.ParallelState.Branches = append(ps.ParallelState.Branches, branch)
+		}
+		newState = &ps.ParallelState
+	case "Map":
+		var ms mapState
+		err = json.Unmarshal(*raw_json, &ms)
+		// Only assign defined processors, a nil *StateMachine is not a nil Machine
+		if ms.Iterator != nil {
+			ms.MapState.Iterator = ms.Iterator
+		}
+		if ms.ItemProcessor != nil {
+			ms.MapState.ItemProcessor = ms.ItemProcessor
+		}
+		newState = &ms.MapState
 	case "TaskFn":
 		// This is a custom state that adds values to Task to be handled
 		var s state.TaskState
@@ -114,7 +147,13 @@ func unmarshallState(name string, raw_json *json.RawMessage) ([]state.State, err
 	}
 
 	// End of loop return error
-	if err != nil {
+	if err != nil && newState != nil {
+		return nil, fmt.Errorf("%vState(%v) Error: %v", state_type.Type, name, err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := setNullPaths(newState, raw_json); err != nil {
 		return nil, err
 	}
 
@@ -124,3 +163,40 @@ func unmarshallState(name string, raw_json *json.RawMessage) ([]state.State, err
 
 	return []state.State{newState}, nil
 }
+
+// setNullPaths marks the paths defined as null, e.g. "ResultPath": null,
+// which json otherwise leaves as nil, the same as an undefined path
+func setNullPaths(s state.State, raw_json *json.RawMessage) error {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(*raw_json, &fields); err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(s).Elem()
+	for _, name := range []string{"InputPath", "OutputPath", "ResultPath"} {
+		field := value.FieldByName(name)
+		if raw, ok := fields[name]; ok && string(raw) == "null" && field.IsValid() {
+			field.Set(reflect.ValueOf(jsonpath.NullPath()))
+		}
+	}
+
+	// Catchers can also discard the error with a null ResultPath
+	catchField := value.FieldByName("Catch")
+	if !catchField.IsValid() || fields["Catch"] == nil {
+		return nil
+	}
+	catchers, _ := catchField.Interface().([]*state.Catcher)
+
+	var rawCatchers []map[string]json.RawMessage
+	if err := json.Unmarshal(fields["Catch"], &rawCatchers); err != nil {
+		return err
+	}
+
+	for i, raw := range rawCatchers {
+		if i < len(catchers) && catchers[i] != nil && string(raw["ResultPath"]) == "null" {
+			catchers[i].ResultPath = jsonpath.NullPath()
+		}
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package machine
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/coinbase/step/machine/state"
@@ -81,6 +82,50 @@ func Test_Machine_Parser_OfBadPath(t *testing.T) {
 	assert.Regexp(t, "Bad JSON path", err.Error())
 }
 
+func Test_Machine_Parser_Names_State_With_Bad_Choice(t *testing.T) {
+	_, err := FromJSON([]byte(`{
+    "StartAt": "Choice",
+    "States": {
+      "Choice": {
+        "Type": "Choice",
+        "Choices": [{ "Variable": "$.t", "TimestampEquals": "yesterday", "Next": "Done" }]
+      },
+      "Done": { "Type": "Succeed" }
+    }
+  }`))
+
+	assert.Error(t, err)
+	assert.Regexp(t, `ChoiceState\(Choice\) Error: Choices\[0\] parsing time`, err.Error())
+}
+
+func Test_Machine_Parser_Null_Paths(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Task",
+    "States": {
+      "Task": {
+        "Type": "Task",
+        "Resource": "r",
+        "InputPath": null,
+        "ResultPath": null,
+        "Catch": [{ "ErrorEquals": ["States.ALL"], "ResultPath": null, "Next": "Done" }],
+        "End": true
+      },
+      "Done": { "Type": "Succeed" }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	task := sm.States["Task"].(*state.TaskState)
+	assert.True(t, task.InputPath.IsNull())
+	assert.True(t, task.ResultPath.IsNull())
+	assert.False(t, task.OutputPath.IsNull())
+	assert.True(t, task.Catch[0].ResultPath.IsNull())
+
+	raw, err := json.Marshal(task)
+	assert.NoError(t, err)
+	assert.Regexp(t, `"ResultPath":null`, string(raw))
+}
+
 // BASIC TYPE TESTS
 
 func Test_Machine_Parser_AllTypes(t *testing.T) {
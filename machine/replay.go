@@ -0,0 +1,314 @@
+// Replaying AWS execution histories against the local State Machine
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/sfn"
+	steperrors "github.com/coinbase/step/errors"
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// ReplayResult is the local Execution of a replayed history with the first Divergence from it, nil if there is none
+type ReplayResult struct {
+	Execution    *Execution
+	ExpectedPath []string // States visited by the recorded execution
+	Divergence   *Divergence
+}
+
+// Divergence is the first State where the local Execution differs from the recorded one
+type Divergence struct {
+	Index  int // of the State in the Path
+	State  string
+	Reason string // "Path", "Choice", "Input", "Output" or "Status"
+
+	Expected interface{} // recorded by AWS
+	Actual   interface{} // of the local Execution
+}
+
+func (d *Divergence) String() string {
+	return fmt.Sprintf("%v diverged at State %q (%v): expected %v, got %v", d.Reason, d.State, d.Index, *jsonStr(d.Expected), *jsonStr(d.Actual))
+}
+
+// visit is a State entered with its input and last output as JSON, "" if it did not exit
+type visit struct {
+	name   string
+	input  string
+	output string
+}
+
+type recordedResult struct {
+	output string
+	err    *steperrors.StatesError
+}
+
+type recording struct {
+	input   string
+	status  string
+	visits  []visit
+	results map[string][]recordedResult // by Task name, in order
+}
+
+// Replay executes a copy of the machine with the input of an AWS execution history, e.g. from execution.GetHistory,
+// every Task returning the result recorded for it in order. It compares the States visited with their input and output,
+// and the Choices made, to the recorded execution. Data flows as in AWS (StrictDataFlow) and a Retry is not a new visit.
+func (sm *StateMachine) Replay(history []*sfn.HistoryEvent) (*ReplayResult, error) {
+	raw, err := json.Marshal(sm)
+	if err != nil {
+		return nil, err
+	}
+
+	replay, err := FromJSON(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	recorded := recordHistory(history, replay.States)
+
+	for name := range replay.Tasks() {
+		if err := replay.SetTaskHandler(name, replayHandler(name, recorded.results[name])); err != nil {
+			return nil, err
+		}
+	}
+
+	exec, err := replay.ExecuteContext(state.WithStrictDataFlow(context.Background()), recorded.input)
+	if exec == nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{Execution: exec, ExpectedPath: []string{}}
+	for _, v := range recorded.visits {
+		result.ExpectedPath = append(result.ExpectedPath, v.name)
+	}
+
+	result.Divergence = diverged(replay.States, recorded, exec)
+	return result, nil
+}
+
+// recordHistory reads the visits of states and the result of every Task from history
+func recordHistory(history []*sfn.HistoryEvent, states States) *recording {
+	recorded := &recording{input: "{}", status: "RUNNING", results: map[string][]recordedResult{}}
+
+	byID := map[int64]*sfn.HistoryEvent{}
+	for _, event := range history {
+		if event.Id != nil {
+			byID[*event.Id] = event
+		}
+	}
+
+	// Task events follow PreviousEventId back to the State they are in
+	stateName := func(event *sfn.HistoryEvent) string {
+		for event != nil {
+			if event.StateEnteredEventDetails != nil {
+				return to.Strs(event.StateEnteredEventDetails.Name)
+			}
+
+			if event.PreviousEventId == nil {
+				return ""
+			}
+			event = byID[*event.PreviousEventId]
+		}
+		return ""
+	}
+
+	addResult := func(event *sfn.HistoryEvent, result recordedResult) {
+		name := stateName(event)
+		recorded.results[name] = append(recorded.results[name], result)
+	}
+
+	failed := func(errorName *string, cause *string) recordedResult {
+		return recordedResult{err: &steperrors.StatesError{Name: to.Strs(errorName), Cause: to.Strs(cause)}}
+	}
+
+	for _, event := range history {
+		switch {
+		case event.ExecutionStartedEventDetails != nil:
+			recorded.input = to.Strs(event.ExecutionStartedEventDetails.Input)
+		case event.StateEnteredEventDetails != nil:
+			if _, ok := states[to.Strs(event.StateEnteredEventDetails.Name)]; ok {
+				recorded.visits = append(recorded.visits, visit{
+					name:  to.Strs(event.StateEnteredEventDetails.Name),
+					input: to.Strs(event.StateEnteredEventDetails.Input),
+				})
+			}
+		case event.StateExitedEventDetails != nil:
+			last := len(recorded.visits) - 1
+			if last >= 0 && recorded.visits[last].name == to.Strs(event.StateExitedEventDetails.Name) {
+				recorded.visits[last].output = to.Strs(event.StateExitedEventDetails.Output)
+			}
+		case event.TaskSucceededEventDetails != nil:
+			addResult(event, recordedResult{output: to.Strs(event.TaskSucceededEventDetails.Output)})
+		case event.LambdaFunctionSucceededEventDetails != nil:
+			addResult(event, recordedResult{output: to.Strs(event.LambdaFunctionSucceededEventDetails.Output)})
+		case event.TaskFailedEventDetails != nil:
+			details := event.TaskFailedEventDetails
+			addResult(event, failed(details.Error, details.Cause))
+		case event.LambdaFunctionFailedEventDetails != nil:
+			details := event.LambdaFunctionFailedEventDetails
+			addResult(event, failed(details.Error, details.Cause))
+		case event.TaskTimedOutEventDetails != nil:
+			details := event.TaskTimedOutEventDetails
+			addResult(event, failed(details.Error, details.Cause))
+		case event.LambdaFunctionTimedOutEventDetails != nil:
+			details := event.LambdaFunctionTimedOutEventDetails
+			addResult(event, failed(details.Error, details.Cause))
+		}
+
+		switch to.Strs(event.Type) {
+		case "ExecutionSucceeded":
+			recorded.status = "SUCCEEDED"
+		case "ExecutionFailed":
+			recorded.status = "FAILED"
+		case "ExecutionTimedOut":
+			recorded.status = "TIMED_OUT"
+		case "ExecutionAborted":
+			recorded.status = "ABORTED"
+		}
+	}
+
+	return recorded
+}
+
+// replayHandler returns the recorded results of a Task in order,
+// sending them to the task token of a .waitForTaskToken Task
+func replayHandler(name string, results []recordedResult) func(context.Context, interface{}) (interface{}, error) {
+	var lock sync.Mutex
+	invocation := 0
+
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		lock.Lock()
+		i := invocation
+		invocation++
+		lock.Unlock()
+
+		if i >= len(results) {
+			return nil, fmt.Errorf("Replay Error: Task %v has no recorded result for invocation %v", name, i)
+		}
+
+		var output interface{}
+		var err error
+		if results[i].err != nil {
+			err = *results[i].err
+		} else if jsonErr := json.Unmarshal([]byte(results[i].output), &output); jsonErr != nil {
+			return nil, fmt.Errorf("Replay Error: Task %v invocation %v output: %v", name, i, jsonErr)
+		}
+
+		task, _ := state.ContextObject(ctx)["Task"].(map[string]interface{})
+		token, ok := task["Token"].(string)
+		if !ok {
+			return output, err
+		}
+
+		callbacks := state.ContextCallbacks(ctx)
+		if taskErr, isTaskErr := err.(steperrors.StatesError); isTaskErr {
+			return map[string]interface{}{}, callbacks.SendTaskFailure(token, taskErr.Name, taskErr.Cause)
+		}
+		return map[string]interface{}{}, callbacks.SendTaskSuccess(token, output)
+	}
+}
+
+// localVisits returns the States exec visited, a State entered again by a Retry is the same visit
+func localVisits(exec *Execution) []visit {
+	visits := []visit{}
+	entered := 0
+
+	for _, event := range exec.ExecutionHistory {
+		if event.Branch != "" {
+			continue
+		}
+
+		switch {
+		case event.StateEnteredEventDetails != nil:
+			retried := entered > 0 && entered <= len(exec.Edges) && isRetry(exec.Edges[entered-1])
+			entered++
+			if retried {
+				continue
+			}
+
+			visits = append(visits, visit{
+				name:  to.Strs(event.StateEnteredEventDetails.Name),
+				input: to.Strs(event.StateEnteredEventDetails.Input),
+			})
+		case event.StateExitedEventDetails != nil:
+			if len(visits) > 0 {
+				visits[len(visits)-1].output = to.Strs(event.StateExitedEventDetails.Output)
+			}
+		}
+	}
+
+	return visits
+}
+
+func isRetry(edge Edge) bool {
+	return strings.HasPrefix(edge.Label, "Retry[")
+}
+
+// diverged returns the first Divergence of exec from the recorded execution, or nil
+func diverged(states States, recorded *recording, exec *Execution) *Divergence {
+	local := localVisits(exec)
+
+	for i, expected := range recorded.visits {
+		if i >= len(local) {
+			return &Divergence{Index: i, State: expected.name, Reason: "Path", Expected: expected.name, Actual: nil}
+		}
+
+		actual := local[i]
+		if actual.name != expected.name {
+			if i > 0 {
+				if _, ok := states[local[i-1].name].(*state.ChoiceState); ok {
+					return &Divergence{Index: i - 1, State: local[i-1].name, Reason: "Choice", Expected: expected.name, Actual: actual.name}
+				}
+			}
+			return &Divergence{Index: i, State: expected.name, Reason: "Path", Expected: expected.name, Actual: actual.name}
+		}
+
+		if d := dataDiverged(i, expected.name, "Input", expected.input, actual.input); d != nil {
+			return d
+		}
+
+		if expected.output != "" && actual.output != "" {
+			if d := dataDiverged(i, expected.name, "Output", expected.output, actual.output); d != nil {
+				return d
+			}
+		}
+	}
+
+	// A running execution has not recorded its remaining States
+	if recorded.status == "RUNNING" {
+		return nil
+	}
+
+	if len(local) > len(recorded.visits) {
+		extra := local[len(recorded.visits)]
+		return &Divergence{Index: len(recorded.visits), State: extra.name, Reason: "Path", Expected: nil, Actual: extra.name}
+	}
+
+	if exec.Status() != recorded.status {
+		last := ""
+		if len(local) > 0 {
+			last = local[len(local)-1].name
+		}
+		return &Divergence{Index: len(local) - 1, State: last, Reason: "Status", Expected: recorded.status, Actual: exec.Status()}
+	}
+
+	return nil
+}
+
+// dataDiverged compares the JSON of expected and actual ignoring formatting
+func dataDiverged(index int, name string, reason string, expected string, actual string) *Divergence {
+	var expectedValue, actualValue interface{}
+	json.Unmarshal([]byte(expected), &expectedValue)
+	json.Unmarshal([]byte(actual), &actualValue)
+
+	if reflect.DeepEqual(expectedValue, actualValue) {
+		return nil
+	}
+
+	return &Divergence{Index: index, State: name, Reason: reason, Expected: expectedValue, Actual: actualValue}
+}
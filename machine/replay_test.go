@@ -0,0 +1,152 @@
+package machine
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sfn"
+	"github.com/coinbase/step/aws/mocks"
+	"github.com/coinbase/step/execution"
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+var replayMachine = `{
+  "StartAt": "Fetch",
+  "States": {
+    "Fetch": {
+      "Type": "Task",
+      "Resource": "arn:aws:lambda:us-east-1:000000000000:function:fetch",
+      "Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 2 }],
+      "ResultPath": "$.fetched",
+      "Next": "Big?"
+    },
+    "Big?": {
+      "Type": "Choice",
+      "Choices": [{ "Variable": "$.fetched.size", "NumericGreaterThan": 10, "Next": "Big" }],
+      "Default": "Small"
+    },
+    "Big": { "Type": "Pass", "Result": "big", "ResultPath": "$.kind", "End": true },
+    "Small": { "Type": "Pass", "Result": "small", "ResultPath": "$.kind", "End": true }
+  }
+}`
+
+// recordedHistory executes the machine JSON as AWS would, to produce a history to replay
+func recordedHistory(t *testing.T, machineJSON string, size int) []*sfn.HistoryEvent {
+	sm, err := FromJSON([]byte(machineJSON))
+	assert.NoError(t, err)
+
+	sm.StrictDataFlow = true
+	assert.NoError(t, sm.SetTaskHandler("Fetch", func(_ context.Context, _ interface{}) (interface{}, error) {
+		return map[string]interface{}{"size": size}, nil
+	}))
+
+	exec, err := sm.Execute(map[string]interface{}{"id": "a"})
+	assert.NoError(t, err)
+	return exec.HistoryOutput(false).Events
+}
+
+func Test_Replay_No_Divergence(t *testing.T) {
+	sm, err := FromJSON([]byte(replayMachine))
+	assert.NoError(t, err)
+
+	result, err := sm.Replay(recordedHistory(t, replayMachine, 20))
+	assert.NoError(t, err)
+
+	assert.Nil(t, result.Divergence)
+	assert.Equal(t, []string{"Fetch", "Big?", "Big"}, result.ExpectedPath)
+	assert.Equal(t, []string{"Fetch", "Big?", "Big"}, result.Execution.Path())
+
+	// The machine replayed is a copy, its handlers are unchanged
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+}
+
+func Test_Replay_Choice_Divergence(t *testing.T) {
+	changed := strings.Replace(replayMachine, `"NumericGreaterThan": 10`, `"NumericGreaterThan": 50`, 1)
+	sm, err := FromJSON([]byte(changed))
+	assert.NoError(t, err)
+
+	result, err := sm.Replay(recordedHistory(t, replayMachine, 20))
+	assert.NoError(t, err)
+
+	assert.Equal(t, &Divergence{Index: 1, State: "Big?", Reason: "Choice", Expected: "Big", Actual: "Small"}, result.Divergence)
+	assert.Equal(t, `Choice diverged at State "Big?" (1): expected "Big", got "Small"`, result.Divergence.String())
+}
+
+func Test_Replay_Output_Divergence(t *testing.T) {
+	changed := strings.Replace(replayMachine, `"ResultPath": "$.fetched"`, `"ResultSelector": { "size.$": "$.size" }, "ResultPath": "$.fetched"`, 1)
+	changed = strings.Replace(changed, `"size.$": "$.size"`, `"bytes.$": "$.size"`, 1)
+	sm, err := FromJSON([]byte(changed))
+	assert.NoError(t, err)
+
+	result, err := sm.Replay(recordedHistory(t, replayMachine, 20))
+	assert.NoError(t, err)
+
+	assert.NotNil(t, result.Divergence)
+	assert.Equal(t, "Output", result.Divergence.Reason)
+	assert.Equal(t, "Fetch", result.Divergence.State)
+	assert.Equal(t, map[string]interface{}{"id": "a", "fetched": map[string]interface{}{"size": 20.0}}, result.Divergence.Expected)
+}
+
+// awsEvent builds a history event like the AWS GetExecutionHistory API returns
+func awsEvent(id int64, previous int64, eventType string) *sfn.HistoryEvent {
+	return &sfn.HistoryEvent{Id: to.Int64p(id), PreviousEventId: to.Int64p(previous), Type: to.Strp(eventType), Timestamp: to.Timep(time.Now())}
+}
+
+func Test_Replay_AWS_History_With_Retry(t *testing.T) {
+	started := awsEvent(1, 0, "ExecutionStarted")
+	started.ExecutionStartedEventDetails = &sfn.ExecutionStartedEventDetails{Input: to.Strp(`{"id": "a"}`)}
+
+	entered := awsEvent(2, 1, "TaskStateEntered")
+	entered.StateEnteredEventDetails = &sfn.StateEnteredEventDetails{Name: to.Strp("Fetch"), Input: to.Strp(`{"id": "a"}`)}
+
+	// AWS retries the Lambda within the same State
+	failed := awsEvent(5, 4, "LambdaFunctionFailed")
+	failed.LambdaFunctionFailedEventDetails = &sfn.LambdaFunctionFailedEventDetails{Error: to.Strp("Timeout"), Cause: to.Strp("slow")}
+
+	succeeded := awsEvent(8, 7, "LambdaFunctionSucceeded")
+	succeeded.LambdaFunctionSucceededEventDetails = &sfn.LambdaFunctionSucceededEventDetails{Output: to.Strp(`{"size": 3}`)}
+
+	exited := awsEvent(9, 8, "TaskStateExited")
+	exited.StateExitedEventDetails = &sfn.StateExitedEventDetails{Name: to.Strp("Fetch"), Output: to.Strp(`{"id": "a", "fetched": {"size": 3}}`)}
+
+	choice := awsEvent(10, 9, "ChoiceStateEntered")
+	choice.StateEnteredEventDetails = &sfn.StateEnteredEventDetails{Name: to.Strp("Big?"), Input: to.Strp(`{"id": "a", "fetched": {"size": 3}}`)}
+
+	small := awsEvent(12, 11, "PassStateEntered")
+	small.StateEnteredEventDetails = &sfn.StateEnteredEventDetails{Name: to.Strp("Small"), Input: to.Strp(`{"id": "a", "fetched": {"size": 3}}`)}
+
+	sfnc := &mocks.MockSFNClient{GetExecutionHistoryResp: &sfn.GetExecutionHistoryOutput{Events: []*sfn.HistoryEvent{
+		started,
+		entered,
+		awsEvent(3, 2, "LambdaFunctionScheduled"),
+		awsEvent(4, 3, "LambdaFunctionStarted"),
+		failed,
+		awsEvent(6, 5, "LambdaFunctionScheduled"),
+		awsEvent(7, 6, "LambdaFunctionStarted"),
+		succeeded,
+		exited,
+		choice,
+		awsEvent(11, 10, "ChoiceStateExited"),
+		small,
+		awsEvent(13, 12, "PassStateExited"),
+		awsEvent(14, 13, "ExecutionSucceeded"),
+	}}}
+
+	history, err := execution.GetHistory(sfnc, to.Strp("arn"))
+	assert.NoError(t, err)
+
+	sm, err := FromJSON([]byte(replayMachine))
+	assert.NoError(t, err)
+
+	result, err := sm.Replay(history)
+	assert.NoError(t, err)
+
+	assert.Nil(t, result.Divergence)
+	assert.Equal(t, []string{"Fetch", "Big?", "Small"}, result.ExpectedPath)
+	assert.Equal(t, []string{"Fetch", "Fetch", "Big?", "Small"}, result.Execution.Path())
+	assert.Equal(t, "small", result.Execution.Output["kind"])
+}
@@ -0,0 +1,97 @@
+package machine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/coinbase/step/machine/state"
+)
+
+// RunningExecution is an Execution running in the background, e.g. paused on ".waitForTaskToken" Tasks
+// until SendTaskSuccess, SendTaskFailure or SendTaskHeartbeat is called with their $$.Task.Token
+type RunningExecution struct {
+	*state.Callbacks
+
+	done   chan struct{}
+	cancel context.CancelFunc
+
+	lock    sync.Mutex
+	started *Execution // once ExecuteContext has created it
+
+	exec *Execution
+	err  error
+}
+
+// Start executes the machine in the background, cancelling ctx or calling Stop aborts it
+func (sm *StateMachine) Start(ctx context.Context, input interface{}) *RunningExecution {
+	ctx, cancel := context.WithCancel(ctx)
+
+	running := &RunningExecution{
+		Callbacks: state.NewCallbacks(),
+		done:      make(chan struct{}),
+		cancel:    cancel,
+	}
+
+	start, _ := ctx.Value(executionStartKey{}).(executionStart)
+	start.started = func(exec *Execution) {
+		running.lock.Lock()
+		defer running.lock.Unlock()
+		running.started = exec
+	}
+	ctx = context.WithValue(ctx, executionStartKey{}, start)
+
+	go func() {
+		defer close(running.done)
+		defer cancel()
+		running.exec, running.err = sm.ExecuteContext(state.WithCallbacks(ctx, running.Callbacks), input)
+	}()
+
+	return running
+}
+
+// Wait blocks until the execution ends and returns it
+func (r *RunningExecution) Wait() (*Execution, error) {
+	<-r.done
+	return r.exec, r.err
+}
+
+// Execution returns the execution so far, or nil if it has not started yet.
+// Only its History, Status and Children are safe to read until it ends
+func (r *RunningExecution) Execution() *Execution {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.started
+}
+
+// Done is closed when the execution ends
+func (r *RunningExecution) Done() <-chan struct{} {
+	return r.done
+}
+
+// Stop aborts the execution and waits for it to end
+func (r *RunningExecution) Stop() (*Execution, error) {
+	r.cancel()
+	return r.Wait()
+}
+
+// WaitForTaskToken blocks until a Task waits for its task token and returns the token,
+// it fails if the execution ends first
+func (r *RunningExecution) WaitForTaskToken() (string, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-r.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	token, err := r.Callbacks.WaitForTaskToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("Execution ended without waiting for a task token")
+	}
+	return token, nil
+}
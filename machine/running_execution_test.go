@@ -0,0 +1,126 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+var approvalMachine = `{
+  "StartAt": "Approval",
+  "States": {
+    "Approval": {
+      "Type": "Task",
+      "Resource": "arn:aws:states:::sqs:sendMessage.waitForTaskToken",
+      "Parameters": { "QueueUrl": "approvals", "MessageBody": { "token.$": "$$.Task.Token", "id.$": "$.id" } },
+      "ResultPath": "$.approval",
+      "HeartbeatSeconds": 1,
+      "Catch": [{ "ErrorEquals": ["Rejected"], "ResultPath": "$.error", "Next": "Rejected" }],
+      "End": true
+    },
+    "Rejected": { "Type": "Fail", "Error": "Rejected" }
+  }
+}`
+
+func startApproval(t *testing.T) (*RunningExecution, *state.FakeServices) {
+	sm, err := FromJSON([]byte(approvalMachine))
+	assert.NoError(t, err)
+
+	services := state.NewFakeServices()
+	sm.Integrations = services.Integrations()
+
+	return sm.Start(context.Background(), map[string]interface{}{"id": "a"}), services
+}
+
+// sentMessage waits for the Approval Task to send its message, the token waits just before it is sent
+func sentMessage(t *testing.T, services *state.FakeServices) string {
+	for i := 0; i < 500; i++ {
+		if messages := services.Messages("approvals"); len(messages) > 0 {
+			return messages[0]
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("no message sent")
+	return ""
+}
+
+func Test_RunningExecution_SendTaskSuccess(t *testing.T) {
+	running, services := startApproval(t)
+
+	token, err := running.WaitForTaskToken()
+	assert.NoError(t, err)
+
+	// The token is sent in the message like AWS would
+	var message map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(sentMessage(t, services)), &message))
+	assert.Equal(t, token, message["token"])
+
+	assert.NoError(t, running.SendTaskHeartbeat(token))
+	assert.NoError(t, running.SendTaskSuccess(token, map[string]interface{}{"approved": true}))
+	assert.Error(t, running.SendTaskSuccess(token, map[string]interface{}{}))
+
+	exec, err := running.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"approved": true}, exec.Output["approval"])
+}
+
+func Test_RunningExecution_SendTaskFailure(t *testing.T) {
+	running, _ := startApproval(t)
+
+	token, err := running.WaitForTaskToken()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{token}, running.Tokens())
+
+	assert.NoError(t, running.SendTaskFailure(token, "Rejected", "no"))
+
+	exec, err := running.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, []string{"Approval", "Rejected"}, exec.Path())
+	assert.Equal(t, map[string]interface{}{"Error": "Rejected", "Cause": "no"}, exec.LastOutput["error"])
+}
+
+func Test_RunningExecution_Heartbeat_Timeout(t *testing.T) {
+	running, _ := startApproval(t)
+
+	_, err := running.WaitForTaskToken()
+	assert.NoError(t, err)
+
+	exec, err := running.Wait()
+	assert.Error(t, err)
+	assert.Regexp(t, "States.Timeout", err.Error())
+	assert.Empty(t, running.Tokens())
+
+	_, err = running.WaitForTaskToken()
+	assert.Error(t, err)
+	assert.NotNil(t, exec)
+}
+
+func Test_Machine_WaitForTaskToken_Handler_Callback(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+    "StartAt": "Callback",
+    "States": {
+      "Callback": {
+        "Type": "Task",
+        "Resource": "arn:aws:states:::lambda:invoke.waitForTaskToken",
+        "Parameters": { "token.$": "$$.Task.Token" },
+        "End": true
+      }
+    }
+  }`))
+	assert.NoError(t, err)
+
+	sm.SetTaskHandler("Callback", func(ctx context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+		token := input["token"].(string)
+		go state.ContextCallbacks(ctx).SendTaskSuccess(token, map[string]interface{}{"called": "back"})
+		return map[string]interface{}{"ignored": true}, nil
+	})
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, "back", exec.Output["called"])
+	assert.Nil(t, exec.Output["ignored"])
+}
@@ -0,0 +1,228 @@
+// Snapshots to resume or redrive local Executions
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+// Snapshot is the progress of an Execution after a State transition, serializable as JSON.
+// Next is the State to enter with Data, or for a failed Execution the State that failed with its original input.
+type Snapshot struct {
+	ExecutionArn       string
+	ParentExecutionArn string `json:",omitempty"`
+
+	Status string // RUNNING, SUCCEEDED, FAILED, TIMED_OUT or ABORTED
+
+	Next *string `json:",omitempty"`
+	Data interface{}
+
+	// Retries are the attempts made by each Retrier of a State, by State name,
+	// prefixed with the Branch for States of Parallel and Map States, e.g. "Parallel[0]/Task"
+	Retries map[string][]int `json:",omitempty"`
+
+	History     []HistoryEvent
+	Edges       []Edge            `json:",omitempty"`
+	BranchEdges map[string][]Edge `json:",omitempty"`
+}
+
+// ParseSnapshot parses the JSON of a Snapshot
+func ParseSnapshot(raw []byte) (*Snapshot, error) {
+	var snapshot Snapshot
+	if err := json.Unmarshal(raw, &snapshot); err != nil {
+		return nil, err
+	}
+
+	if len(snapshot.History) == 0 {
+		return nil, fmt.Errorf("Snapshot Error: History must have the ExecutionStarted event")
+	}
+
+	return &snapshot, nil
+}
+
+// Status returns the Status of the Execution from its last event, RUNNING until it ends,
+// safe to call while the Execution runs
+func (sm *Execution) Status() string {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	if len(sm.ExecutionHistory) == 0 {
+		return "RUNNING"
+	}
+
+	switch to.Strs(sm.ExecutionHistory[len(sm.ExecutionHistory)-1].Type) {
+	case "ExecutionSucceeded":
+		return "SUCCEEDED"
+	case "ExecutionFailed":
+		return "FAILED"
+	case "ExecutionTimedOut":
+		return "TIMED_OUT"
+	case "ExecutionAborted":
+		return "ABORTED"
+	}
+	return "RUNNING"
+}
+
+// Snapshot returns the progress of the Execution so far
+func (sm *Execution) Snapshot() *Snapshot {
+	snapshot := &Snapshot{
+		ExecutionArn:       sm.ExecutionArn,
+		ParentExecutionArn: sm.ParentExecutionArn,
+		Status:             sm.Status(),
+		Retries:            map[string][]int{},
+		History:            sm.History(),
+		Edges:              append([]Edge{}, sm.Edges...),
+		BranchEdges:        sm.branchEdges(),
+	}
+
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	snapshot.Next = sm.next
+
+	// Copy the Data so the Snapshot does not share it with the running Execution
+	if sm.data != nil {
+		raw, _ := json.Marshal(sm.data)
+		json.Unmarshal(raw, &snapshot.Data)
+	}
+
+	for _, retries := range []map[string][]int{sm.retries, sm.branchRetries} {
+		for name, attempts := range retries {
+			snapshot.Retries[name] = append([]int{}, attempts...)
+		}
+	}
+
+	return snapshot
+}
+
+// branchEdges returns a copy of the BranchEdges, safe to call while the Execution runs
+func (sm *Execution) branchEdges() map[string][]Edge {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+
+	if len(sm.BranchEdges) == 0 {
+		return nil
+	}
+
+	edges := map[string][]Edge{}
+	for branch, branchEdges := range sm.BranchEdges {
+		edges[branch] = append([]Edge{}, branchEdges...)
+	}
+	return edges
+}
+
+// checkpoint records next is the State to enter with data, a Branch records only its retries in the parent
+func (sm *Execution) checkpoint(next *string, data interface{}, retries map[string][]int) {
+	if sm.parent != nil {
+		sm.parent.setBranchRetries(sm.branch, retries)
+		return
+	}
+
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	sm.next = next
+	sm.data = data
+	sm.retries = retries
+}
+
+func (sm *Execution) setBranchRetries(branch string, retries map[string][]int) {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	if sm.branchRetries == nil {
+		sm.branchRetries = map[string][]int{}
+	}
+	for name, attempts := range retries {
+		sm.branchRetries[branch+"/"+name] = attempts
+	}
+}
+
+// takeResumeRetries returns the resumed attempts of the States of branch, only the first time the branch runs
+func (sm *Execution) takeResumeRetries(branch string, states map[string]state.State) map[string][]int {
+	sm.lock.Lock()
+	defer sm.lock.Unlock()
+
+	retries := map[string][]int{}
+	for key, attempts := range sm.resumeRetries {
+		name := strings.TrimPrefix(key, branch+"/")
+		if _, ok := states[name]; ok && name != key {
+			retries[name] = attempts
+			delete(sm.resumeRetries, key)
+		}
+	}
+	return retries
+}
+
+// Resume continues the Execution of snapshot, see ResumeContext
+func (sm *StateMachine) Resume(snapshot *Snapshot) (*Execution, error) {
+	return sm.ResumeContext(context.Background(), snapshot)
+}
+
+// ResumeContext continues a RUNNING snapshot from its Next State with its Data and Retry attempts,
+// including those of the Branches of a Parallel or Map Next State, e.g. after a crash. Like an AWS redrive, a FAILED, TIMED_OUT or ABORTED snapshot restarts
+// from the State that failed with that States original input and no Retry attempts.
+// The Execution keeps the History, Edges and ARN of the snapshot.
+func (sm *StateMachine) ResumeContext(ctx context.Context, snapshot *Snapshot) (*Execution, error) {
+	if err := sm.Validate(); err != nil {
+		return nil, err
+	}
+
+	if len(snapshot.History) == 0 {
+		return nil, fmt.Errorf("Snapshot Error: History must have the ExecutionStarted event")
+	}
+
+	if snapshot.Status == "SUCCEEDED" || snapshot.Next == nil {
+		return nil, fmt.Errorf("Snapshot Error: Execution %q has no State to resume", snapshot.ExecutionArn)
+	}
+
+	if _, ok := sm.States[*snapshot.Next]; !ok {
+		return nil, fmt.Errorf("Snapshot Error: Unknown State %q", *snapshot.Next)
+	}
+
+	exec := &Execution{
+		ExecutionArn:       snapshot.ExecutionArn,
+		ParentExecutionArn: snapshot.ParentExecutionArn,
+		ExecutionHistory:   append([]HistoryEvent{}, snapshot.History...),
+		Edges:              append([]Edge{}, snapshot.Edges...),
+		clock:              sm.clock(),
+	}
+
+	for branch, edges := range snapshot.BranchEdges {
+		for _, edge := range edges {
+			exec.addBranchEdge(branch, edge)
+		}
+	}
+
+	// The Branches of the Next State continue with their Retry attempts
+	if snapshot.Status == "RUNNING" {
+		exec.retries = map[string][]int{}
+		exec.branchRetries = map[string][]int{}
+		exec.resumeRetries = map[string][]int{}
+		for name, attempts := range snapshot.Retries {
+			if _, ok := sm.States[name]; ok {
+				exec.retries[name] = attempts
+				continue
+			}
+
+			exec.branchRetries[name] = attempts
+			if strings.HasPrefix(name, *snapshot.Next+"[") {
+				exec.resumeRetries[name] = attempts
+			}
+		}
+	} else {
+		exec.addEvent(createEvent(exec.now(), "ExecutionRedriven"))
+	}
+
+	var execInput interface{}
+	if details := snapshot.History[0].ExecutionStartedEventDetails; details != nil {
+		json.Unmarshal([]byte(to.Strs(details.Input)), &execInput)
+	}
+
+	return sm.run(ctx, exec, execInput, snapshot.Next, snapshot.Data)
+}
@@ -0,0 +1,269 @@
+package machine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/coinbase/step/machine/state"
+	"github.com/stretchr/testify/assert"
+)
+
+var snapshotMachine = `{
+  "StartAt": "Start",
+  "States": {
+    "Start": { "Type": "Pass", "Result": "started", "ResultPath": "$.start", "Next": "Lock" },
+    "Lock": {
+      "Type": "Task",
+      "Resource": "arn:aws:lambda:us-east-1:000000000000:function:lock",
+      "Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 1 }],
+      "ResultPath": "$.lock",
+      "Next": "Deploy"
+    },
+    "Deploy": {
+      "Type": "Task",
+      "Resource": "arn:aws:lambda:us-east-1:000000000000:function:deploy",
+      "ResultPath": "$.deploy",
+      "End": true
+    }
+  }
+}`
+
+// snapshotMachineWith returns a new machine whose Lock and Deploy Tasks fail the given number of times
+func snapshotMachineWith(t *testing.T, lockFailures int, deployFailures int) *StateMachine {
+	sm, err := FromJSON([]byte(snapshotMachine))
+	assert.NoError(t, err)
+
+	failing := func(name string, failures int) func(context.Context, interface{}) (interface{}, error) {
+		calls := 0
+		return func(_ context.Context, _ interface{}) (interface{}, error) {
+			calls++
+			if calls <= failures {
+				return nil, fmt.Errorf("%v failed", name)
+			}
+			return map[string]interface{}{"ok": true}, nil
+		}
+	}
+
+	assert.NoError(t, sm.SetTaskHandler("Lock", failing("Lock", lockFailures)))
+	assert.NoError(t, sm.SetTaskHandler("Deploy", failing("Deploy", deployFailures)))
+	return sm
+}
+
+// crashAt saves every Snapshot as JSON and stops the Execution once the Snapshot matches
+func crashAt(sm *StateMachine, crash func(*Snapshot) bool) *[]byte {
+	saved := []byte{}
+	sm.Checkpoint = func(snapshot *Snapshot) error {
+		raw, err := json.Marshal(snapshot)
+		if err != nil {
+			return err
+		}
+		saved = raw
+
+		if crash(snapshot) {
+			return fmt.Errorf("crashed")
+		}
+		return nil
+	}
+	return &saved
+}
+
+func Test_Snapshot_Resume_After_Crash(t *testing.T) {
+	sm := snapshotMachineWith(t, 0, 0)
+	saved := crashAt(sm, func(s *Snapshot) bool { return *s.Next == "Deploy" })
+
+	crashed, err := sm.Execute(map[string]interface{}{"id": "a"})
+	assert.Error(t, err)
+
+	snapshot, err := ParseSnapshot(*saved)
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", snapshot.Status)
+	assert.Equal(t, "Deploy", *snapshot.Next)
+	assert.Equal(t, map[string]interface{}{"id": "a", "start": "started", "lock": map[string]interface{}{"ok": true}}, snapshot.Data)
+
+	// A new process resumes from the saved Snapshot
+	resumed := snapshotMachineWith(t, 0, 0)
+	exec, err := resumed.Resume(snapshot)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "SUCCEEDED", exec.Status())
+	assert.Equal(t, crashed.ExecutionArn, exec.ExecutionArn)
+	assert.Equal(t, []string{"Start", "Lock", "Deploy"}, exec.Path())
+	assert.Equal(t, map[string]interface{}{"ok": true}, exec.Output["deploy"])
+	assert.Equal(t, `{"id":"a"}`, *exec.ExecutionHistory[0].ExecutionStartedEventDetails.Input)
+
+	for i, event := range exec.ExecutionHistory {
+		assert.Equal(t, int64(i+1), *event.Id)
+	}
+}
+
+func Test_Snapshot_Resume_Keeps_Retry_Attempts(t *testing.T) {
+	sm := snapshotMachineWith(t, 1, 0)
+	saved := crashAt(sm, func(s *Snapshot) bool { return len(s.Retries["Lock"]) > 0 })
+
+	_, err := sm.Execute(map[string]interface{}{})
+	assert.Error(t, err)
+
+	snapshot, err := ParseSnapshot(*saved)
+	assert.NoError(t, err)
+	assert.Equal(t, "Lock", *snapshot.Next)
+	assert.Equal(t, []int{1}, snapshot.Retries["Lock"])
+
+	// The Retrier has used its only attempt, so failing again fails the Execution
+	resumed := snapshotMachineWith(t, 1, 0)
+	exec, err := resumed.Resume(snapshot)
+	assert.Error(t, err)
+	assert.Equal(t, "FAILED", exec.Status())
+	assert.Equal(t, []string{"Start", "Lock", "Lock"}, exec.Path())
+}
+
+func Test_Snapshot_Redrive_Failed_Execution(t *testing.T) {
+	sm := snapshotMachineWith(t, 0, 1)
+
+	failed, err := sm.Execute(map[string]interface{}{"id": "a"})
+	assert.Error(t, err)
+
+	snapshot := failed.Snapshot()
+	assert.Equal(t, "FAILED", snapshot.Status)
+	assert.Equal(t, "Deploy", *snapshot.Next)
+	assert.Equal(t, map[string]interface{}{"id": "a", "start": "started", "lock": map[string]interface{}{"ok": true}}, snapshot.Data)
+
+	// Deploy succeeds the second time
+	exec, err := sm.Resume(snapshot)
+	assert.NoError(t, err)
+	assert.Equal(t, "SUCCEEDED", exec.Status())
+	assert.Equal(t, []string{"Start", "Lock", "Deploy", "Deploy"}, exec.Path())
+
+	types := []string{}
+	for _, event := range exec.ExecutionHistory {
+		types = append(types, *event.Type)
+	}
+	assert.Contains(t, types, "ExecutionFailed")
+	assert.Contains(t, types, "ExecutionRedriven")
+	assert.Equal(t, "ExecutionSucceeded", types[len(types)-1])
+
+	// The failed Execution is unchanged
+	assert.Equal(t, "FAILED", failed.Status())
+}
+
+func Test_Snapshot_Resume_Succeeded_Errors(t *testing.T) {
+	sm := snapshotMachineWith(t, 0, 0)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	_, err = sm.Resume(exec.Snapshot())
+	assert.Error(t, err)
+
+	_, err = ParseSnapshot([]byte(`{"Status": "RUNNING"}`))
+	assert.Error(t, err)
+}
+
+func Test_Snapshot_Branch_Retry_Attempts(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+  "StartAt": "Parallel",
+  "States": {
+    "Parallel": {
+      "Type": "Parallel",
+      "Branches": [{
+        "StartAt": "Lock",
+        "States": {
+          "Lock": {
+            "Type": "Task",
+            "Resource": "arn:aws:lambda:us-east-1:000000000000:function:lock",
+            "Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 2 }],
+            "End": true
+          }
+        }
+      }],
+      "ResultPath": "$.parallel",
+      "Next": "Done"
+    },
+    "Done": { "Type": "Pass", "End": true }
+  }
+}`))
+	assert.NoError(t, err)
+
+	calls := 0
+	services := state.NewFakeServices()
+	services.SetFunction("lock", func(_ context.Context, _ interface{}) (interface{}, error) {
+		calls++
+		if calls == 1 {
+			return nil, fmt.Errorf("Lock failed")
+		}
+		return map[string]interface{}{"ok": true}, nil
+	})
+	sm.Integrations = services.Integrations()
+
+	saved := crashAt(sm, func(s *Snapshot) bool { return *s.Next == "Done" })
+
+	_, err = sm.Execute(map[string]interface{}{"id": "a"})
+	assert.Error(t, err)
+
+	snapshot, err := ParseSnapshot(*saved)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string][]int{"Parallel[0]/Lock": {1}}, snapshot.Retries)
+
+	// The Data of a Snapshot is a copy
+	exec, err := sm.Resume(snapshot)
+	assert.NoError(t, err)
+
+	first := exec.Snapshot()
+	first.Data.(map[string]interface{})["id"] = "changed"
+	assert.Equal(t, "a", exec.Snapshot().Data.(map[string]interface{})["id"])
+	assert.Equal(t, map[string][]int{"Parallel[0]/Lock": {1}}, exec.Snapshot().Retries)
+}
+
+func Test_Snapshot_Resume_Keeps_Branch_Retry_Attempts(t *testing.T) {
+	sm, err := FromJSON([]byte(`{
+  "StartAt": "Parallel",
+  "States": {
+    "Parallel": {
+      "Type": "Parallel",
+      "Branches": [{
+        "StartAt": "Lock",
+        "States": {
+          "Lock": {
+            "Type": "Task",
+            "Resource": "arn:aws:lambda:us-east-1:000000000000:function:lock",
+            "Retry": [{ "ErrorEquals": ["States.ALL"], "MaxAttempts": 1 }],
+            "End": true
+          }
+        }
+      }],
+      "End": true
+    }
+  }
+}`))
+	assert.NoError(t, err)
+
+	// The second call saves a Snapshot while the Parallel runs, as if it crashed there
+	calls := 0
+	var snapshot *Snapshot
+	services := state.NewFakeServices()
+	services.SetFunction("lock", func(ctx context.Context, _ interface{}) (interface{}, error) {
+		calls++
+		switch calls {
+		case 1, 3:
+			return nil, fmt.Errorf("Lock failed")
+		case 2:
+			snapshot = ctx.Value(executionKey{}).(*Execution).Snapshot()
+		}
+		return map[string]interface{}{"ok": true}, nil
+	})
+	sm.Integrations = services.Integrations()
+
+	_, err = sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "RUNNING", snapshot.Status)
+	assert.Equal(t, "Parallel", *snapshot.Next)
+	assert.Equal(t, map[string][]int{"Parallel[0]/Lock": {1}}, snapshot.Retries)
+
+	// The resumed Branch has used its only attempt, so failing again fails the Execution
+	exec, err := sm.Resume(snapshot)
+	assert.Error(t, err)
+	assert.Equal(t, "FAILED", exec.Status())
+	assert.Equal(t, 3, calls)
+}
@@ -16,10 +16,11 @@ type ActionState struct {
 	Comment    *string `json:",omitempty"`
 	ActionName *string `json:",omitempty"`
 
-	InputPath  *jsonpath.Path `json:",omitempty"`
-	OutputPath *jsonpath.Path `json:",omitempty"`
-	ResultPath *jsonpath.Path `json:",omitempty"`
-	Parameters interface{}    `json:",omitempty"`
+	InputPath      *jsonpath.Path `json:",omitempty"`
+	OutputPath     *jsonpath.Path `json:",omitempty"`
+	ResultPath     *jsonpath.Path `json:",omitempty"`
+	ResultSelector interface{}    `json:",omitempty"`
+	Parameters     interface{}    `json:",omitempty"`
 
 	Catch []*Catcher `json:",omitempty"`
 	Retry []*Retrier `json:",omitempty"`
@@ -57,14 +58,20 @@ func (s *ActionState) process(ctx context.Context, input interface{}) (interface
 // Input must include the Action name in $.Action
 func (s *ActionState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
-		processCatcher(s.Catch,
+		processCatcher(s.Name(), s.Catch,
 			processRetrier(s.Name(), s.Retry,
 				inputOutput(
 					s.InputPath,
 					s.OutputPath,
 					withParams(
 						s.Parameters,
-						result(s.ResultPath, s.process),
+						result(s.ResultPath,
+							withResultSelector(s.ResultSelector,
+								recordTask(s.ActionName, s.TimeoutSeconds,
+									withTimeout(s.TimeoutSeconds, s.HeartbeatSeconds, s.process),
+								),
+							),
+						),
 					),
 				),
 			),
@@ -73,7 +80,7 @@ func (s *ActionState) Execute(ctx context.Context, input interface{}) (output in
 }
 
 func (s *ActionState) Validate() error {
-	s.SetType(to.Strp("Action"))
+	setType(s, "Action")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
@@ -89,10 +96,18 @@ func (s *ActionState) Validate() error {
 		}
 	}
 
+	if err := timeoutValid(s.TimeoutSeconds, s.HeartbeatSeconds); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
 	if err := catchValid(s.Catch); err != nil {
 		return err
 	}
 
+	if err := resultSelectorValid(s.ResultSelector); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
 	if err := retryValid(s.Retry); err != nil {
 		return err
 	}
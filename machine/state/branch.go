@@ -0,0 +1,33 @@
+package state
+
+import (
+	"context"
+	"fmt"
+)
+
+type branchKey struct{}
+
+// WithBranch returns a Context for the Branch index of the Parallel or Map State name,
+// nested in the Branch of ctx, e.g. "Parallel[0]" or "Parallel[0]/Map[3]"
+func WithBranch(ctx context.Context, name *string, index int) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	branch := fmt.Sprintf("%v[%v]", *name, index)
+	if parent := ContextBranch(ctx); parent != "" {
+		branch = parent + "/" + branch
+	}
+
+	return context.WithValue(ctx, branchKey{}, branch)
+}
+
+// ContextBranch returns the Branch of ctx, or "" outside of Parallel and Map States
+func ContextBranch(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+
+	branch, _ := ctx.Value(branchKey{}).(string)
+	return branch
+}
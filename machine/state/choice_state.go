@@ -2,11 +2,14 @@ package state
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
+	"github.com/coinbase/step/errors"
 	"github.com/coinbase/step/jsonpath"
-	"github.com/coinbase/step/utils/to"
 )
 
 type ChoiceState struct {
@@ -52,16 +55,50 @@ type ChoiceRule struct {
 	TimestampLessThanEquals    *time.Time `json:",omitempty"`
 	TimestampGreaterThanEquals *time.Time `json:",omitempty"`
 
+	StringMatches *string `json:",omitempty"` // * is a wildcard, \* a literal *
+
+	StringEqualsPath            *jsonpath.Path `json:",omitempty"`
+	StringLessThanPath          *jsonpath.Path `json:",omitempty"`
+	StringGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	StringLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	StringGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	NumericEqualsPath            *jsonpath.Path `json:",omitempty"`
+	NumericLessThanPath          *jsonpath.Path `json:",omitempty"`
+	NumericGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	NumericLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	NumericGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	BooleanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	TimestampEqualsPath            *jsonpath.Path `json:",omitempty"`
+	TimestampLessThanPath          *jsonpath.Path `json:",omitempty"`
+	TimestampGreaterThanPath       *jsonpath.Path `json:",omitempty"`
+	TimestampLessThanEqualsPath    *jsonpath.Path `json:",omitempty"`
+	TimestampGreaterThanEqualsPath *jsonpath.Path `json:",omitempty"`
+
+	IsPresent   *bool `json:",omitempty"`
+	IsNull      *bool `json:",omitempty"`
+	IsString    *bool `json:",omitempty"`
+	IsNumeric   *bool `json:",omitempty"`
+	IsBoolean   *bool `json:",omitempty"`
+	IsTimestamp *bool `json:",omitempty"`
+
 	And []*ChoiceRule `json:",omitempty"`
 	Or  []*ChoiceRule `json:",omitempty"`
 	Not *ChoiceRule   `json:",omitempty"`
 }
 
 func (s *ChoiceState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-	next := chooseNextState(input, s.Default, s.Choices)
+	next, choice := chooseNextState(input, s.Default, s.Choices)
 	if next == nil {
-		return nil, nil, fmt.Errorf("State Choice Error")
+		return nil, nil, errors.StatesError{Name: "States.NoChoiceMatched", Cause: fmt.Sprintf("No Choice of %v matched and there is no Default", *s.Name())}
 	}
+
+	if recorder := ContextTransitionRecorder(ctx); recorder != nil {
+		recorder.Chose(*s.Name(), choice, *next)
+	}
+
 	return input, next, nil
 }
 
@@ -75,13 +112,14 @@ func (s *ChoiceState) Execute(ctx context.Context, input interface{}) (output in
 	)(ctx, input)
 }
 
-func chooseNextState(input interface{}, default_choice *string, choices []*Choice) *string {
-	for _, choice := range choices {
+// chooseNextState returns the Next of the first matching Choice and its index, or the default and -1
+func chooseNextState(input interface{}, default_choice *string, choices []*Choice) (*string, int) {
+	for i, choice := range choices {
 		if choiceRulePositive(input, &choice.ChoiceRule) {
-			return choice.Next
+			return choice.Next, i
 		}
 	}
-	return default_choice
+	return default_choice, -1
 }
 
 func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
@@ -109,6 +147,36 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 		return !choiceRulePositive(input, cr.Not)
 	}
 
+	// Compare against the value of another path in the input
+	if literal, ok, err := pathChoiceRule(input, cr); ok {
+		if err != nil {
+			return false
+		}
+		return choiceRulePositive(input, literal)
+	}
+
+	// Type tests
+	if cr.IsPresent != nil {
+		_, err := cr.Variable.Get(input)
+		return (err == nil) == *cr.IsPresent
+	}
+
+	if cr.IsNull != nil || cr.IsString != nil || cr.IsNumeric != nil || cr.IsBoolean != nil || cr.IsTimestamp != nil {
+		value, err := cr.Variable.Get(input)
+		if err != nil {
+			return false // not present
+		}
+		return typeTestPositive(value, cr)
+	}
+
+	if cr.StringMatches != nil {
+		vstr, err := cr.Variable.GetString(input)
+		if err != nil {
+			return false
+		}
+		return stringMatches(*vstr, *cr.StringMatches)
+	}
+
 	if cr.StringEquals != nil {
 		vstr, err := cr.Variable.GetString(input)
 		if err != nil {
@@ -241,10 +309,99 @@ func choiceRulePositive(input interface{}, cr *ChoiceRule) bool {
 	return false
 }
 
+// pathChoiceRule turns a ...Path comparison into its literal comparison using the value at the path
+// ok is false if cr is not a Path comparison
+func pathChoiceRule(input interface{}, cr *ChoiceRule) (literal *ChoiceRule, ok bool, err error) {
+	literal = &ChoiceRule{Variable: cr.Variable}
+
+	switch {
+	case cr.StringEqualsPath != nil:
+		literal.StringEquals, err = cr.StringEqualsPath.GetString(input)
+	case cr.StringLessThanPath != nil:
+		literal.StringLessThan, err = cr.StringLessThanPath.GetString(input)
+	case cr.StringGreaterThanPath != nil:
+		literal.StringGreaterThan, err = cr.StringGreaterThanPath.GetString(input)
+	case cr.StringLessThanEqualsPath != nil:
+		literal.StringLessThanEquals, err = cr.StringLessThanEqualsPath.GetString(input)
+	case cr.StringGreaterThanEqualsPath != nil:
+		literal.StringGreaterThanEquals, err = cr.StringGreaterThanEqualsPath.GetString(input)
+	case cr.NumericEqualsPath != nil:
+		literal.NumericEquals, err = cr.NumericEqualsPath.GetNumber(input)
+	case cr.NumericLessThanPath != nil:
+		literal.NumericLessThan, err = cr.NumericLessThanPath.GetNumber(input)
+	case cr.NumericGreaterThanPath != nil:
+		literal.NumericGreaterThan, err = cr.NumericGreaterThanPath.GetNumber(input)
+	case cr.NumericLessThanEqualsPath != nil:
+		literal.NumericLessThanEquals, err = cr.NumericLessThanEqualsPath.GetNumber(input)
+	case cr.NumericGreaterThanEqualsPath != nil:
+		literal.NumericGreaterThanEquals, err = cr.NumericGreaterThanEqualsPath.GetNumber(input)
+	case cr.BooleanEqualsPath != nil:
+		literal.BooleanEquals, err = cr.BooleanEqualsPath.GetBool(input)
+	case cr.TimestampEqualsPath != nil:
+		literal.TimestampEquals, err = cr.TimestampEqualsPath.GetTime(input)
+	case cr.TimestampLessThanPath != nil:
+		literal.TimestampLessThan, err = cr.TimestampLessThanPath.GetTime(input)
+	case cr.TimestampGreaterThanPath != nil:
+		literal.TimestampGreaterThan, err = cr.TimestampGreaterThanPath.GetTime(input)
+	case cr.TimestampLessThanEqualsPath != nil:
+		literal.TimestampLessThanEquals, err = cr.TimestampLessThanEqualsPath.GetTime(input)
+	case cr.TimestampGreaterThanEqualsPath != nil:
+		literal.TimestampGreaterThanEquals, err = cr.TimestampGreaterThanEqualsPath.GetTime(input)
+	default:
+		return nil, false, nil
+	}
+
+	return literal, true, err
+}
+
+// typeTestPositive checks the Is... type test of cr against a present value
+func typeTestPositive(value interface{}, cr *ChoiceRule) bool {
+	switch {
+	case cr.IsNull != nil:
+		return (value == nil) == *cr.IsNull
+	case cr.IsString != nil:
+		_, isString := value.(string)
+		return isString == *cr.IsString
+	case cr.IsNumeric != nil:
+		_, isNumeric := toNumber(value)
+		return isNumeric == *cr.IsNumeric
+	case cr.IsBoolean != nil:
+		_, isBool := value.(bool)
+		return isBool == *cr.IsBoolean
+	case cr.IsTimestamp != nil:
+		str, isString := value.(string)
+		_, err := time.Parse(time.RFC3339, str)
+		return (isString && err == nil) == *cr.IsTimestamp
+	}
+	return false
+}
+
+// stringMatches matches str against pattern where * matches any characters,
+// \* matches a literal * and \\ a literal \
+func stringMatches(str string, pattern string) bool {
+	var re strings.Builder
+	re.WriteString("^")
+
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		case pattern[i] == '*':
+			re.WriteString("(?s:.*)")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+		}
+	}
+
+	re.WriteString("$")
+	return regexp.MustCompile(re.String()).MatchString(str)
+}
+
 // VALIDATION LOGIC
 
 func (s *ChoiceState) Validate() error {
-	s.SetType(to.Strp("Choice"))
+	setType(s, "Choice")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
@@ -254,10 +411,10 @@ func (s *ChoiceState) Validate() error {
 		return fmt.Errorf("%v Must have Choices", errorPrefix(s))
 	}
 
-	for _, c := range s.Choices {
+	for i, c := range s.Choices {
 		err := validateChoice(c)
 		if err != nil {
-			return fmt.Errorf("%v %v", errorPrefix(s), err)
+			return fmt.Errorf("%v Choices[%v] %v", errorPrefix(s), i, err)
 		}
 	}
 
@@ -265,49 +422,22 @@ func (s *ChoiceState) Validate() error {
 }
 
 func validateChoice(c *Choice) error {
+	if c == nil {
+		return fmt.Errorf("Choice is null")
+	}
 
 	if c.Next == nil {
 		return fmt.Errorf("Choice must have Next")
 	}
 
-	all_choice_rules := recursiveAllChoiceRule(&c.ChoiceRule)
-
-	for _, cr := range all_choice_rules {
-		if err := validateChoiceRule(cr); err != nil {
-			return err
-		}
-	}
-
-	return nil
+	return validateChoiceRule(&c.ChoiceRule)
 }
 
-func recursiveAllChoiceRule(c *ChoiceRule) []*ChoiceRule {
+func validateChoiceRule(c *ChoiceRule) error {
 	if c == nil {
-		return []*ChoiceRule{}
-	}
-
-	crs := []*ChoiceRule{c}
-
-	if c.Not != nil {
-		crs = append(crs, c.Not)
-	}
-
-	if c.And != nil {
-		for _, cr := range c.And {
-			crs = append(crs, recursiveAllChoiceRule(cr)...)
-		}
+		return fmt.Errorf("Rule is null")
 	}
 
-	if c.Or != nil {
-		for _, cr := range c.Or {
-			crs = append(crs, recursiveAllChoiceRule(cr)...)
-		}
-	}
-
-	return crs
-}
-
-func validateChoiceRule(c *ChoiceRule) error {
 	// Exactly One Comparison Operator
 	all_comparison_operators := []bool{
 		c.Not != nil,
@@ -329,6 +459,29 @@ func validateChoiceRule(c *ChoiceRule) error {
 		c.TimestampGreaterThan != nil,
 		c.TimestampLessThanEquals != nil,
 		c.TimestampGreaterThanEquals != nil,
+		c.StringMatches != nil,
+		c.StringEqualsPath != nil,
+		c.StringLessThanPath != nil,
+		c.StringGreaterThanPath != nil,
+		c.StringLessThanEqualsPath != nil,
+		c.StringGreaterThanEqualsPath != nil,
+		c.NumericEqualsPath != nil,
+		c.NumericLessThanPath != nil,
+		c.NumericGreaterThanPath != nil,
+		c.NumericLessThanEqualsPath != nil,
+		c.NumericGreaterThanEqualsPath != nil,
+		c.BooleanEqualsPath != nil,
+		c.TimestampEqualsPath != nil,
+		c.TimestampLessThanPath != nil,
+		c.TimestampGreaterThanPath != nil,
+		c.TimestampLessThanEqualsPath != nil,
+		c.TimestampGreaterThanEqualsPath != nil,
+		c.IsPresent != nil,
+		c.IsNull != nil,
+		c.IsString != nil,
+		c.IsNumeric != nil,
+		c.IsBoolean != nil,
+		c.IsTimestamp != nil,
 	}
 
 	count := 0
@@ -363,6 +516,52 @@ func validateChoiceRule(c *ChoiceRule) error {
 		return fmt.Errorf("Or Must have elements")
 	}
 
+	// Nested rules are named by their position e.g. And[1].Not
+	for i, cr := range c.And {
+		if err := validateChoiceRule(cr); err != nil {
+			return fmt.Errorf("And[%v] %v", i, err)
+		}
+	}
+
+	for i, cr := range c.Or {
+		if err := validateChoiceRule(cr); err != nil {
+			return fmt.Errorf("Or[%v] %v", i, err)
+		}
+	}
+
+	if c.Not != nil {
+		if err := validateChoiceRule(c.Not); err != nil {
+			return fmt.Errorf("Not %v", err)
+		}
+	}
+
+	return nil
+}
+
+// UnmarshalJSON parses each Choice on its own so errors,
+// e.g. a Timestamp that is not RFC3339, name the index of the Choice
+func (s *ChoiceState) UnmarshalJSON(b []byte) error {
+	type choiceState ChoiceState // without this method
+
+	var raw struct {
+		choiceState
+		Choices []json.RawMessage
+	}
+
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+
+	*s = ChoiceState(raw.choiceState)
+
+	for i, rawChoice := range raw.Choices {
+		var c *Choice
+		if err := json.Unmarshal(rawChoice, &c); err != nil {
+			return fmt.Errorf("Choices[%v] %v", i, err)
+		}
+		s.Choices = append(s.Choices, c)
+	}
+
 	return nil
 }
 
@@ -1,6 +1,7 @@
 package state
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/coinbase/step/utils/to"
@@ -492,3 +493,151 @@ func Test_ChoiceState_NotAllowed2DeeplyEmbeddedComparisonOperators(t *testing.T)
 	assert.Error(t, err)
 	assert.Regexp(t, "Not Exactly One comparison Operator", err.Error())
 }
+
+func Test_ChoiceState_Validate_Names_Rule_Index(t *testing.T) {
+	cases := map[string]string{
+		`{ "Next": "A", "StringEquals": "a" }`:                                               `Choices\[1\] Variable Not defined`,
+		`{ "Next": "A", "Variable": "$.a", "And": [{ "Variable": "$.a", "IsNull": true }] }`: `Choices\[1\] Variable defined with Not And Or defined`,
+		`{ "Next": "A", "Variable": "$.a", "Or": [{ "Variable": "$.a", "IsNull": true }] }`:  `Choices\[1\] Variable defined with Not And Or defined`,
+		`{ "Next": "A", "Or": [{ "Variable": "$.a", "IsNull": true }, { "IsNull": true }] }`: `Choices\[1\] Or\[1\] Variable Not defined`,
+		`{ "Next": "A", "And": [{ "Not": { "Variable": "$.a", "And": [] } }] }`:              `Choices\[1\] And\[0\] Not Variable defined with Not And Or defined`,
+		`{ "Next": "A", "And": [null] }`:                                                     `Choices\[1\] And\[0\] Rule is null`,
+		`{ "Variable": "$.a", "IsNull": true }`:                                              `Choices\[1\] Choice must have Next`,
+		`null`:                                                                               `Choices\[1\] Choice is null`,
+	}
+
+	for choice, expected := range cases {
+		state := parseChoiceState([]byte(`{"Default": "Fail", "Choices": [
+			{ "Variable": "$.a", "IsPresent": true, "Next": "A" },
+			`+choice+`
+		]}`), t)
+
+		err := state.Validate()
+		if assert.Error(t, err, choice) {
+			assert.Regexp(t, `^ChoiceState\(TestState\) Error: `+expected, err.Error(), choice)
+		}
+	}
+}
+
+func Test_ChoiceState_Parse_Bad_Timestamp(t *testing.T) {
+	var state ChoiceState
+	err := json.Unmarshal([]byte(`{"Default": "Fail", "Choices": [
+		{ "Variable": "$.a", "IsPresent": true, "Next": "A" },
+		{ "Variable": "$.a", "TimestampEquals": "2007-01-02", "Next": "A" }
+	]}`), &state)
+
+	assert.Error(t, err)
+	assert.Regexp(t, `Choices\[1\] parsing time`, err.Error())
+}
+
+// Type Tests
+
+func Test_ChoiceState_TypeTests(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.null", "IsNull": true, "Next": "PassIsNull" },
+			{ "Variable": "$.timestamp", "IsTimestamp": true, "Next": "PassIsTimestamp" },
+			{ "Variable": "$.string", "IsString": true, "Next": "PassIsString" },
+			{ "Variable": "$.numeric", "IsNumeric": true, "Next": "PassIsNumeric" },
+			{ "Variable": "$.boolean", "IsBoolean": true, "Next": "PassIsBoolean" },
+			{ "Variable": "$.present", "IsPresent": true, "Next": "PassIsPresent" },
+			{ "Variable": "$.absent", "IsPresent": false, "Next": "PassIsNotPresent" }
+		]
+	}`), t)
+
+	present := map[string]interface{}{"absent": 1}
+
+	cases := map[string]map[string]interface{}{
+		"PassIsNull":       {"absent": 1, "null": nil},
+		"PassIsTimestamp":  {"absent": 1, "timestamp": "2007-01-02T15:04:05Z"},
+		"PassIsString":     {"absent": 1, "string": "s", "timestamp": "s"},
+		"PassIsNumeric":    {"absent": 1, "numeric": 1.5, "string": 1},
+		"PassIsBoolean":    {"absent": 1, "boolean": false},
+		"PassIsPresent":    {"absent": 1, "present": nil, "boolean": "false"},
+		"PassIsNotPresent": {"null": "not null"},
+	}
+
+	for next, input := range cases {
+		testState(state, stateTestData{Input: input, Next: to.Strp(next)}, t)
+	}
+
+	// Nothing matches
+	_, _, err := state.Execute(nil, present)
+	assert.Error(t, err)
+}
+
+func Test_ChoiceState_StringMatches(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.file", "StringMatches": "log-*.txt", "Next": "PassLog" },
+			{ "Variable": "$.file", "StringMatches": "star\\*\\\\*", "Next": "PassStar" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	cases := map[string]string{
+		"log-.txt":         "PassLog",
+		"log-2007-01.txt":  "PassLog",
+		"log-2007-01.txts": "Fail",
+		"logs-1.txt":       "Fail",
+		`star*\`:           "PassStar",
+		`star*\anything`:   "PassStar",
+		`starx\`:           "Fail",
+	}
+
+	for file, next := range cases {
+		testState(state, stateTestData{
+			Input: map[string]interface{}{"file": file},
+			Next:  to.Strp(next),
+		}, t)
+	}
+}
+
+func Test_ChoiceState_PathComparisons(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [
+			{ "Variable": "$.a", "StringEqualsPath": "$.b", "Next": "PassStringEqualsPath" },
+			{ "Variable": "$.a", "StringLessThanPath": "$.b", "Next": "PassStringLessThanPath" },
+			{ "Variable": "$.n", "NumericGreaterThanPath": "$.m", "Next": "PassNumericGreaterThanPath" },
+			{ "Variable": "$.n", "NumericLessThanEqualsPath": "$.m", "Next": "PassNumericLessThanEqualsPath" },
+			{ "Variable": "$.bool", "BooleanEqualsPath": "$.other", "Next": "PassBooleanEqualsPath" },
+			{ "Variable": "$.t", "TimestampLessThanEqualsPath": "$.u", "Next": "PassTimestampLessThanEqualsPath" },
+			{ "Variable": "$.t", "TimestampGreaterThanPath": "$.u", "Next": "PassTimestampGreaterThanPath" }
+		],
+		"Default": "Fail"
+	}`), t)
+
+	cases := []struct {
+		input map[string]interface{}
+		next  string
+	}{
+		{map[string]interface{}{"a": "x", "b": "x"}, "PassStringEqualsPath"},
+		{map[string]interface{}{"a": "x", "b": "y"}, "PassStringLessThanPath"},
+		{map[string]interface{}{"a": "y", "b": "x"}, "Fail"},
+		{map[string]interface{}{"n": 2, "m": 1.5}, "PassNumericGreaterThanPath"},
+		{map[string]interface{}{"n": 1.5, "m": 1.5}, "PassNumericLessThanEqualsPath"},
+		{map[string]interface{}{"bool": true, "other": true}, "PassBooleanEqualsPath"},
+		{map[string]interface{}{"bool": true, "other": false}, "Fail"},
+		{map[string]interface{}{"t": "2007-01-02T15:04:05Z", "u": "2007-01-02T15:04:05Z"}, "PassTimestampLessThanEqualsPath"},
+		{map[string]interface{}{"t": "2008-01-02T15:04:05Z", "u": "2007-01-02T15:04:05Z"}, "PassTimestampGreaterThanPath"},
+		// Missing or wrong type paths do not match
+		{map[string]interface{}{"a": "x"}, "Fail"},
+		{map[string]interface{}{"n": 1, "m": "1"}, "Fail"},
+	}
+
+	for _, c := range cases {
+		testState(state, stateTestData{Input: c.input, Next: to.Strp(c.next)}, t)
+	}
+}
+
+func Test_ChoiceState_Validate_New_Operators(t *testing.T) {
+	state := parseChoiceState([]byte(`{
+		"Choices": [{ "Variable": "$.a", "IsPresent": true, "StringEqualsPath": "$.b", "Next": "Pass" }]
+	}`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseChoiceState([]byte(`{
+		"Choices": [{ "Variable": "$.a", "StringMatches": "*", "Next": "Pass" }]
+	}`), t)
+	assert.NoError(t, state.Validate())
+}
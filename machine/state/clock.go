@@ -0,0 +1,134 @@
+package state
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock is the time source used by Wait states and Retry intervals
+type Clock interface {
+	Now() time.Time
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// clockKey stores the Clock in a context.Context
+type clockKey struct{}
+
+// WithClock returns a Context holding the Clock states wait with
+func WithClock(ctx context.Context, clock Clock) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// ContextClock returns the Clock from ctx, defaulting to a FakeClock starting now
+func ContextClock(ctx context.Context) Clock {
+	if ctx != nil {
+		if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+			return clock
+		}
+	}
+	return NewFakeClock(time.Now())
+}
+
+// RealClock actually waits
+type RealClock struct{}
+
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+func (RealClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// FakeClock simulates time, Sleep advances the virtual time instantly
+// Concurrent branches each get a Fork of the clock, so their waits overlap like real time
+type FakeClock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	if ctx != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	c.Advance(d)
+	return nil
+}
+
+// Advance moves the virtual time forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Fork returns a FakeClock starting at the time of c, for a branch running concurrently with others
+func (c *FakeClock) Fork() *FakeClock {
+	return NewFakeClock(c.Now())
+}
+
+// Join advances c to the latest time of forks, once their branches have ended
+func (c *FakeClock) Join(forks ...*FakeClock) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, fork := range forks {
+		if now := fork.Now(); now.After(c.now) {
+			c.now = now
+		}
+	}
+}
+
+// branchClocks returns n Clocks for branches running concurrently, forks of the FakeClock of ctx,
+// join advances it to when the last branch ended. Any other Clock is shared by the branches.
+func branchClocks(ctx context.Context, n int) (clocks []Clock, join func()) {
+	clock := ContextClock(ctx)
+	clocks = make([]Clock, n)
+
+	fake, ok := clock.(*FakeClock)
+	if !ok {
+		for i := range clocks {
+			clocks[i] = clock
+		}
+		return clocks, func() {}
+	}
+
+	forks := make([]*FakeClock, n)
+	for i := range forks {
+		forks[i] = fake.Fork()
+		clocks[i] = forks[i]
+	}
+
+	return clocks, func() { fake.Join(forks...) }
+}
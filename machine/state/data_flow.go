@@ -0,0 +1,120 @@
+package state
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/jsonpath"
+)
+
+// Strict Data Flow follows the AWS processing order exactly:
+// InputPath -> Parameters -> ResultSelector -> ResultPath -> OutputPath
+// There is no merging of maps, null paths discard data and
+// a ResultPath that cannot be applied fails with States.ResultPathMatchFailure
+
+type strictDataFlowKey struct{}
+
+type stateInputKey struct{}
+
+// WithStrictDataFlow returns a Context in which states use Strict Data Flow
+func WithStrictDataFlow(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, strictDataFlowKey{}, true)
+}
+
+// StrictDataFlow returns true if ctx was made with WithStrictDataFlow
+func StrictDataFlow(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+
+	strict, _ := ctx.Value(strictDataFlowKey{}).(bool)
+	return strict
+}
+
+// stateInput returns the raw input of the state, before InputPath, that ResultPath is applied to
+func stateInput(ctx context.Context, input interface{}) interface{} {
+	if ctx != nil {
+		if raw, ok := ctx.Value(stateInputKey{}).(rawInput); ok {
+			return raw.input
+		}
+	}
+	return input
+}
+
+// rawInput wraps the state input so a nil input is still found in the context
+type rawInput struct {
+	input interface{}
+}
+
+func strictInputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec Execution) Execution {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		// A null InputPath passes an empty object
+		var effectiveInput interface{} = map[string]interface{}{}
+		if !inputPath.IsNull() {
+			var err error
+			if effectiveInput, err = inputPath.Get(input); err != nil {
+				return nil, nil, fmt.Errorf("Input Error: %v", err)
+			}
+		}
+
+		output, next, err := exec(context.WithValue(ctx, stateInputKey{}, rawInput{input}), effectiveInput)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// A null OutputPath outputs an empty object
+		if outputPath.IsNull() {
+			return map[string]interface{}{}, next, nil
+		}
+
+		output, err = outputPath.Get(output)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Output Error: %v", err)
+		}
+
+		return output, next, nil
+	}
+}
+
+func strictResult(resultPath *jsonpath.Path, exec Execution) Execution {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		result, next, err := exec(ctx, input)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		output, err := applyResultPath(resultPath, stateInput(ctx, input), result)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return output, next, nil
+	}
+}
+
+// applyResultPath returns a copy of input with result at resultPath,
+// a null ResultPath discards the result and "$" replaces the input
+func applyResultPath(resultPath *jsonpath.Path, input interface{}, result interface{}) (interface{}, error) {
+	switch {
+	case resultPath.IsNull():
+		return input, nil
+	case resultPath.IsRoot():
+		return result, nil
+	case !resultPath.CanSet(input):
+		return nil, errors.StatesError{
+			Name:  "States.ResultPathMatchFailure",
+			Cause: fmt.Sprintf("Unable to apply ResultPath %v to input", resultPath),
+		}
+	}
+
+	copied, err := copyJSON(input)
+	if err != nil {
+		return nil, err
+	}
+
+	return resultPath.Set(copied, result)
+}
@@ -0,0 +1,32 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// ErrorRecorder records the errors States Retry and Catch, with the index of the Retrier or Catcher used
+type ErrorRecorder interface {
+	Retried(stateName string, retrier int, err error, attempt int, interval time.Duration)
+	Caught(stateName string, catcher int, err error, next string)
+}
+
+type errorRecorderKey struct{}
+
+// WithErrorRecorder returns a Context holding the ErrorRecorder Retriers and Catchers report to
+func WithErrorRecorder(ctx context.Context, recorder ErrorRecorder) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, errorRecorderKey{}, recorder)
+}
+
+// ContextErrorRecorder returns the ErrorRecorder from ctx, or nil
+func ContextErrorRecorder(ctx context.Context) ErrorRecorder {
+	if ctx == nil {
+		return nil
+	}
+
+	recorder, _ := ctx.Value(errorRecorderKey{}).(ErrorRecorder)
+	return recorder
+}
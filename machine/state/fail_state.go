@@ -3,9 +3,11 @@ package state
 import (
 	"context"
 	"fmt"
+	"strings"
 
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/jsonpath"
 	"github.com/coinbase/step/utils/is"
-	"github.com/coinbase/step/utils/to"
 )
 
 type FailState struct {
@@ -16,27 +18,97 @@ type FailState struct {
 
 	Error *string `json:",omitempty"`
 	Cause *string `json:",omitempty"`
+
+	// ErrorPath and CausePath are paths or intrinsic functions resolved against the input
+	ErrorPath *string `json:",omitempty"`
+	CausePath *string `json:",omitempty"`
+}
+
+func (s *FailState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
+	errorName, err := failString(s.Error, s.ErrorPath, input, ContextObject(ctx))
+	if err != nil {
+		return nil, nil, errors.StatesError{Name: "States.Runtime", Cause: fmt.Sprintf("%v ErrorPath %v", errorPrefix(s), err)}
+	}
+
+	cause, err := failString(s.Cause, s.CausePath, input, ContextObject(ctx))
+	if err != nil {
+		return nil, nil, errors.StatesError{Name: "States.Runtime", Cause: fmt.Sprintf("%v CausePath %v", errorPrefix(s), err)}
+	}
+
+	return errorOutput(&errorName, &cause), nil, errors.FailError{StatesError: errors.StatesError{Name: errorName, Cause: cause}}
 }
 
-func (s *FailState) Execute(_ context.Context, input interface{}) (output interface{}, next *string, err error) {
-	cause := "Undefined"
-	if s.Cause != nil {
-		cause = *(s.Cause)
+// failString returns the static value, or resolves the path or intrinsic function
+func failString(value *string, path *string, input interface{}, contextObject interface{}) (string, error) {
+	if path == nil {
+		if value == nil {
+			return "", nil
+		}
+		return *value, nil
+	}
+
+	var resolved interface{}
+	var err error
+
+	if IsIntrinsic(*path) {
+		resolved, err = EvaluateIntrinsic(*path, input, contextObject)
+	} else {
+		resolved, err = (&intrinsicPath{path: *path}).eval(input, contextObject)
+	}
+
+	if err != nil {
+		return "", err
 	}
-	return errorOutput(s.Error, s.Cause), nil, fmt.Errorf("Fail State with Cause: %v", cause)
+
+	str, ok := resolved.(string)
+	if !ok {
+		return "", fmt.Errorf("%v must resolve to a string", *path)
+	}
+
+	return str, nil
+}
+
+func failPathValid(name string, value *string, path *string) error {
+	if path == nil {
+		return nil
+	}
+
+	if value != nil {
+		return fmt.Errorf("%v and %vPath both defined", name, name)
+	}
+
+	if IsIntrinsic(*path) {
+		_, err := parseIntrinsic(*path)
+		return err
+	}
+
+	if !strings.HasPrefix(*path, "$") {
+		return fmt.Errorf("%vPath must be a path or intrinsic function", name)
+	}
+
+	_, err := jsonpath.NewPath(contextPath(*path))
+	return err
 }
 
 func (s *FailState) Validate() error {
-	s.SetType(to.Strp("Fail"))
+	setType(s, "Fail")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
-	if is.EmptyStr(s.Error) {
+	if is.EmptyStr(s.Error) && s.ErrorPath == nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), "must contain Error")
 	}
 
+	if err := failPathValid("Error", s.Error, s.ErrorPath); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if err := failPathValid("Cause", s.Cause, s.CausePath); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
 	return nil
 }
 
@@ -0,0 +1,76 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FailState_Static_Error_And_Cause(t *testing.T) {
+	state := parseFailState([]byte(`{ "Error": "DeployFailed", "Cause": "Bad Release" }`), t)
+	assert.NoError(t, state.Validate())
+
+	output, next, err := state.Execute(context.Background(), map[string]interface{}{})
+	assert.Nil(t, next)
+	assert.Equal(t, errors.FailError{StatesError: errors.StatesError{Name: "DeployFailed", Cause: "Bad Release"}}, err)
+	assert.Equal(t, map[string]interface{}{"Error": "DeployFailed", "Cause": "Bad Release"}, output)
+	assert.Equal(t, "DeployFailed", to.ErrorType(err))
+}
+
+func Test_FailState_ErrorPath_And_CausePath(t *testing.T) {
+	state := parseFailState([]byte(`{
+		"ErrorPath": "$.error.name",
+		"CausePath": "States.Format('{} failed: {}', $$.Execution.Id, $.error.cause)"
+	}`), t)
+	assert.NoError(t, state.Validate())
+
+	ctx := WithContextObject(context.Background(), map[string]interface{}{
+		"Execution": map[string]interface{}{"Id": "exec"},
+	})
+
+	output, _, err := state.Execute(ctx, map[string]interface{}{
+		"error": map[string]interface{}{"name": "HealthError", "cause": "unhealthy"},
+	})
+
+	assert.Equal(t, errors.FailError{StatesError: errors.StatesError{Name: "HealthError", Cause: "exec failed: unhealthy"}}, err)
+	assert.Equal(t, map[string]interface{}{"Error": "HealthError", "Cause": "exec failed: unhealthy"}, output)
+}
+
+func Test_FailState_ErrorPath_Must_Resolve_To_String(t *testing.T) {
+	state := parseFailState([]byte(`{ "Error": "E", "CausePath": "$.cause" }`), t)
+
+	_, _, err := state.Execute(context.Background(), map[string]interface{}{"cause": 1})
+	assert.Error(t, err)
+	assert.Equal(t, "States.Runtime", to.ErrorType(err))
+	assert.Regexp(t, "CausePath .* must resolve to a string", err.Error())
+
+	_, _, err = state.Execute(context.Background(), map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, "States.Runtime", to.ErrorType(err))
+	assert.Regexp(t, "CausePath", err.Error())
+}
+
+func Test_FailState_Error_Message(t *testing.T) {
+	assert.Equal(t, "Fail State with Cause: Bad Release", errors.FailError{StatesError: errors.StatesError{Name: "DeployFailed", Cause: "Bad Release"}}.Error())
+	assert.Equal(t, "Fail State with Cause: Undefined", errors.FailError{StatesError: errors.StatesError{Name: "DeployFailed"}}.Error())
+}
+
+func Test_FailState_Validate(t *testing.T) {
+	cases := map[string]string{
+		`{}`:                                   "must contain Error",
+		`{ "Error": "E", "ErrorPath": "$.e" }`: "Error and ErrorPath both defined",
+		`{ "Error": "E", "Cause": "C", "CausePath": "$.c" }`: "Cause and CausePath both defined",
+		`{ "ErrorPath": "e" }`:                               "ErrorPath must be a path or intrinsic function",
+		`{ "ErrorPath": "States.Format('{}', $.e" }`:         "Intrinsic Error",
+	}
+
+	for raw, expected := range cases {
+		err := parseFailState([]byte(raw), t).Validate()
+		if assert.Error(t, err, raw) {
+			assert.Regexp(t, expected, err.Error(), raw)
+		}
+	}
+}
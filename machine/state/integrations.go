@@ -0,0 +1,76 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// Integration handles the Resource of a Task state like the AWS service integration,
+// it is called with the Task input after Parameters and returns the service response
+type Integration func(ctx context.Context, resource string, parameters interface{}) (interface{}, error)
+
+// Integrations is a registry of Integrations keyed by Resource ARN pattern,
+// where * matches any characters, e.g. "arn:aws:states:::sqs:sendMessage*"
+type Integrations struct {
+	lock         sync.RWMutex
+	patterns     []string
+	integrations map[string]Integration
+}
+
+func NewIntegrations() *Integrations {
+	return &Integrations{integrations: map[string]Integration{}}
+}
+
+// Register adds an Integration for every Resource matching pattern,
+// it is preferred over the Integrations registered before it
+func (i *Integrations) Register(pattern string, integration Integration) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	if _, ok := i.integrations[pattern]; !ok {
+		i.patterns = append(i.patterns, pattern)
+	}
+	i.integrations[pattern] = integration
+}
+
+// Find returns the Integration of resource, an exact pattern first then the last registered match
+func (i *Integrations) Find(resource string) (Integration, bool) {
+	if i == nil {
+		return nil, false
+	}
+
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	if integration, ok := i.integrations[resource]; ok {
+		return integration, true
+	}
+
+	for p := len(i.patterns) - 1; p >= 0; p-- {
+		if stringMatches(resource, i.patterns[p]) {
+			return i.integrations[i.patterns[p]], true
+		}
+	}
+
+	return nil, false
+}
+
+type integrationsKey struct{}
+
+// WithIntegrations returns a Context holding the Integrations Task states without a TaskHandler use
+func WithIntegrations(ctx context.Context, integrations *Integrations) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, integrationsKey{}, integrations)
+}
+
+// ContextIntegrations returns the Integrations from ctx, or nil
+func ContextIntegrations(ctx context.Context) *Integrations {
+	if ctx == nil {
+		return nil
+	}
+
+	integrations, _ := ctx.Value(integrationsKey{}).(*Integrations)
+	return integrations
+}
@@ -0,0 +1,754 @@
+package state
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	mathrand "math/rand"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/jsonpath"
+)
+
+// Intrinsic Functions e.g. "States.Format('Hello {}', $.name)"
+// They can be used as the value of any ".$" key in Parameters, Result and ItemSelector
+
+const intrinsicPrefix = "States."
+
+// IsIntrinsic returns true if the value of a ".$" key is an Intrinsic Function call
+func IsIntrinsic(value string) bool {
+	return strings.HasPrefix(strings.TrimSpace(value), intrinsicPrefix)
+}
+
+// EvaluateIntrinsic parses and evaluates an Intrinsic Function,
+// paths are resolved against input and "$$" paths against contextObject.
+// A function that fails to evaluate returns States.IntrinsicFailure
+func EvaluateIntrinsic(expression string, input interface{}, contextObject interface{}) (interface{}, error) {
+	node, err := parseIntrinsic(expression)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := node.eval(input, contextObject)
+	if err != nil {
+		return nil, errors.StatesError{Name: "States.IntrinsicFailure", Cause: err.Error()}
+	}
+
+	return output, nil
+}
+
+func parseIntrinsic(expression string) (intrinsicNode, error) {
+	p := &intrinsicParser{expr: expression}
+
+	p.skipSpace()
+	if !strings.HasPrefix(p.rest(), intrinsicPrefix) {
+		return nil, p.errorf("must start with %v", intrinsicPrefix)
+	}
+
+	node, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace()
+	if p.pos != len(p.expr) {
+		return nil, p.errorf("unexpected %q after function", p.rest())
+	}
+
+	return node, nil
+}
+
+//////
+// Parser
+//////
+
+type intrinsicNode interface {
+	eval(input interface{}, contextObject interface{}) (interface{}, error)
+}
+
+type intrinsicLiteral struct {
+	value interface{}
+	raw   string // string literals keep \{ and \} for States.Format
+}
+
+type intrinsicPath struct {
+	path string
+}
+
+type intrinsicCall struct {
+	name string
+	args []intrinsicNode
+}
+
+type intrinsicParser struct {
+	expr string
+	pos  int
+}
+
+func (p *intrinsicParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Intrinsic Error at %v in %q: %v", p.pos, p.expr, fmt.Sprintf(format, args...))
+}
+
+func (p *intrinsicParser) rest() string {
+	return p.expr[p.pos:]
+}
+
+func (p *intrinsicParser) skipSpace() {
+	for p.pos < len(p.expr) && (p.expr[p.pos] == ' ' || p.expr[p.pos] == '\t' || p.expr[p.pos] == '\n') {
+		p.pos++
+	}
+}
+
+func (p *intrinsicParser) parseExpr() (intrinsicNode, error) {
+	p.skipSpace()
+
+	if p.pos >= len(p.expr) {
+		return nil, p.errorf("unexpected end of expression")
+	}
+
+	c := p.expr[p.pos]
+	rest := p.rest()
+
+	switch {
+	case c == '\'':
+		return p.parseString()
+	case c == '$':
+		return p.parsePath()
+	case strings.HasPrefix(rest, intrinsicPrefix):
+		return p.parseCall()
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	}
+
+	// Keywords are whole tokens, e.g. trueX is not true
+	token := p.token()
+	if value, ok := intrinsicKeywords[token]; ok {
+		p.pos += len(token)
+		return &intrinsicLiteral{value: value}, nil
+	}
+
+	if token == "" {
+		token = string(c)
+	}
+	return nil, p.errorf("unexpected %q", token)
+}
+
+func (p *intrinsicParser) parseCall() (intrinsicNode, error) {
+	call := &intrinsicCall{}
+
+	start := p.pos
+	for p.pos < len(p.expr) && (isIdentifier(p.expr[p.pos]) || p.expr[p.pos] == '.') {
+		p.pos++
+	}
+	call.name = p.expr[start:p.pos]
+
+	if _, ok := intrinsicFunctions[call.name]; !ok && call.name != "States.Format" {
+		p.pos = start
+		return nil, p.errorf("unknown function %q", call.name)
+	}
+
+	p.skipSpace()
+	if p.pos >= len(p.expr) || p.expr[p.pos] != '(' {
+		return nil, p.errorf("expected ( after %v", call.name)
+	}
+	p.pos++
+
+	p.skipSpace()
+	if p.pos < len(p.expr) && p.expr[p.pos] == ')' {
+		p.pos++
+		return call, nil
+	}
+
+	for {
+		arg, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+
+		p.skipSpace()
+		if p.pos >= len(p.expr) {
+			return nil, p.errorf("expected ) to close %v", call.name)
+		}
+
+		switch p.expr[p.pos] {
+		case ',':
+			p.pos++
+		case ')':
+			p.pos++
+			return call, nil
+		default:
+			return nil, p.errorf("expected , or ) in %v", call.name)
+		}
+	}
+}
+
+// parseString reads a single quoted string, \' and \\ are unescaped
+func (p *intrinsicParser) parseString() (intrinsicNode, error) {
+	start := p.pos
+	p.pos++ // opening quote
+
+	var raw strings.Builder
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		switch {
+		case c == '\\' && p.pos+1 < len(p.expr):
+			next := p.expr[p.pos+1]
+			if next == '\'' || next == '\\' {
+				raw.WriteByte(next)
+			} else {
+				// Keep other escapes e.g. \{ for States.Format
+				raw.WriteByte(c)
+				raw.WriteByte(next)
+			}
+			p.pos += 2
+		case c == '\'':
+			p.pos++
+			return &intrinsicLiteral{value: unescapeBraces(raw.String()), raw: raw.String()}, nil
+		default:
+			raw.WriteByte(c)
+			p.pos++
+		}
+	}
+
+	p.pos = start
+	return nil, p.errorf("unterminated string")
+}
+
+// parsePath reads a JSON path up to the next , or ) outside of brackets and quotes
+func (p *intrinsicParser) parsePath() (intrinsicNode, error) {
+	start := p.pos
+	depth := 0
+	var quote byte
+
+	for p.pos < len(p.expr) {
+		c := p.expr[p.pos]
+		if quote != 0 {
+			if c == '\\' {
+				p.pos++
+			} else if c == quote {
+				quote = 0
+			}
+		} else if c == '\'' || c == '"' {
+			quote = c
+		} else if c == '[' {
+			depth++
+		} else if c == ']' {
+			depth--
+		} else if depth == 0 && (c == ',' || c == ')' || c == ' ') {
+			break
+		}
+		p.pos++
+	}
+
+	path := p.expr[start:p.pos]
+	if _, err := jsonpath.NewPath(contextPath(path)); err != nil {
+		p.pos = start
+		return nil, p.errorf("bad path %q: %v", path, err)
+	}
+
+	return &intrinsicPath{path: path}, nil
+}
+
+// parseNumber reads a JSON number token, e.g. 1, -2.5 or 1e3
+func (p *intrinsicParser) parseNumber() (intrinsicNode, error) {
+	token := p.token()
+	if !intrinsicNumber.MatchString(token) {
+		return nil, p.errorf("bad number %q", token)
+	}
+
+	number, err := strconv.ParseFloat(token, 64)
+	if err != nil {
+		return nil, p.errorf("bad number %q: %v", token, err)
+	}
+
+	p.pos += len(token)
+	return &intrinsicLiteral{value: number}, nil
+}
+
+// token returns the rest of the expression up to the next , or ) or space that ends an argument
+func (p *intrinsicParser) token() string {
+	end := p.pos
+	for end < len(p.expr) && strings.IndexByte(", \t\n)", p.expr[end]) < 0 {
+		end++
+	}
+	return p.expr[p.pos:end]
+}
+
+var intrinsicNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+var intrinsicKeywords = map[string]interface{}{"true": true, "false": false, "null": nil}
+
+func isIdentifier(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// contextPath turns a "$$" Context Object path into a normal path
+func contextPath(path string) string {
+	if strings.HasPrefix(path, "$$") {
+		return path[1:]
+	}
+	return path
+}
+
+func unescapeBraces(str string) string {
+	return strings.NewReplacer(`\{`, "{", `\}`, "}").Replace(str)
+}
+
+//////
+// Evaluation
+//////
+
+func (l *intrinsicLiteral) eval(_ interface{}, _ interface{}) (interface{}, error) {
+	return l.value, nil
+}
+
+func (ip *intrinsicPath) eval(input interface{}, contextObject interface{}) (interface{}, error) {
+	if strings.HasPrefix(ip.path, "$$") {
+		input = contextObject
+	}
+
+	path, err := jsonpath.NewPath(contextPath(ip.path))
+	if err != nil {
+		return nil, err
+	}
+
+	return path.Get(input)
+}
+
+func (c *intrinsicCall) eval(input interface{}, contextObject interface{}) (interface{}, error) {
+	args := []interface{}{}
+	for _, arg := range c.args {
+		value, err := arg.eval(input, contextObject)
+		if err != nil {
+			return nil, fmt.Errorf("%v Error: %v", c.name, err)
+		}
+		args = append(args, value)
+	}
+
+	if c.name == "States.Format" {
+		return c.format(args)
+	}
+
+	fn := intrinsicFunctions[c.name]
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("%v Error: wrong number of arguments %v", c.name, len(args))
+	}
+
+	output, err := fn.call(args)
+	if err != nil {
+		return nil, fmt.Errorf("%v Error: %v", c.name, err)
+	}
+
+	return output, nil
+}
+
+// format replaces each {} in the template with the next argument
+func (c *intrinsicCall) format(args []interface{}) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("States.Format Error: requires a template")
+	}
+
+	template, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("States.Format Error: template must be a string")
+	}
+
+	// A literal template keeps its escaped braces
+	if literal, ok := c.args[0].(*intrinsicLiteral); ok {
+		template = literal.raw
+	}
+
+	var out strings.Builder
+	next := 1
+	for i := 0; i < len(template); i++ {
+		switch {
+		case template[i] == '\\' && i+1 < len(template) && (template[i+1] == '{' || template[i+1] == '}'):
+			out.WriteByte(template[i+1])
+			i++
+		case template[i] == '{' && i+1 < len(template) && template[i+1] == '}':
+			if next >= len(args) {
+				return nil, fmt.Errorf("States.Format Error: more {} than arguments")
+			}
+			out.WriteString(formatValue(args[next]))
+			next++
+			i++
+		default:
+			out.WriteByte(template[i])
+		}
+	}
+
+	if next != len(args) {
+		return nil, fmt.Errorf("States.Format Error: more arguments than {}")
+	}
+
+	return out.String(), nil
+}
+
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case nil:
+		return "null"
+	}
+
+	if number, ok := toNumber(value); ok {
+		return strconv.FormatFloat(number, 'f', -1, 64)
+	}
+
+	raw, _ := json.Marshal(value)
+	return string(raw)
+}
+
+//////
+// Functions
+//////
+
+type intrinsicFunction struct {
+	minArgs int
+	maxArgs int // -1 is unlimited
+	call    func(args []interface{}) (interface{}, error)
+}
+
+var intrinsicFunctions = map[string]intrinsicFunction{
+	"States.StringToJson": {1, 1, func(args []interface{}) (interface{}, error) {
+		str, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(str), &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}},
+
+	"States.JsonToString": {1, 1, func(args []interface{}) (interface{}, error) {
+		raw, err := json.Marshal(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return string(raw), nil
+	}},
+
+	"States.Array": {0, -1, func(args []interface{}) (interface{}, error) {
+		return append([]interface{}{}, args...), nil
+	}},
+
+	"States.ArrayPartition": {2, 2, func(args []interface{}) (interface{}, error) {
+		array, err := arrayArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		size, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if size <= 0 {
+			return nil, fmt.Errorf("chunk size must be positive")
+		}
+
+		chunks := []interface{}{}
+		for start := 0; start < len(array); start += size {
+			end := start + size
+			if end > len(array) {
+				end = len(array)
+			}
+			chunks = append(chunks, append([]interface{}{}, array[start:end]...))
+		}
+		return chunks, nil
+	}},
+
+	"States.ArrayContains": {2, 2, func(args []interface{}) (interface{}, error) {
+		array, err := arrayArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range array {
+			if jsonEqual(item, args[1]) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}},
+
+	"States.ArrayRange": {3, 3, func(args []interface{}) (interface{}, error) {
+		start, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		step, err := intArg(args[2])
+		if err != nil {
+			return nil, err
+		}
+
+		if step == 0 {
+			return nil, fmt.Errorf("step cannot be 0")
+		}
+
+		// The range includes end
+		values := []interface{}{}
+		for i := start; (step > 0 && i <= end) || (step < 0 && i >= end); i += step {
+			if len(values) >= 1000 {
+				return nil, fmt.Errorf("range has more than 1000 items")
+			}
+			values = append(values, float64(i))
+		}
+		return values, nil
+	}},
+
+	"States.ArrayGetItem": {2, 2, func(args []interface{}) (interface{}, error) {
+		array, err := arrayArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		index, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if index < 0 || index >= len(array) {
+			return nil, fmt.Errorf("index %v out of range", index)
+		}
+		return array[index], nil
+	}},
+
+	"States.ArrayLength": {1, 1, func(args []interface{}) (interface{}, error) {
+		array, err := arrayArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return float64(len(array)), nil
+	}},
+
+	"States.ArrayUnique": {1, 1, func(args []interface{}) (interface{}, error) {
+		array, err := arrayArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		unique := []interface{}{}
+		for _, item := range array {
+			found := false
+			for _, u := range unique {
+				if jsonEqual(item, u) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				unique = append(unique, item)
+			}
+		}
+		return unique, nil
+	}},
+
+	"States.Base64Encode": {1, 1, func(args []interface{}) (interface{}, error) {
+		str, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return base64.StdEncoding.EncodeToString([]byte(str)), nil
+	}},
+
+	"States.Base64Decode": {1, 1, func(args []interface{}) (interface{}, error) {
+		str, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, err
+		}
+		return string(decoded), nil
+	}},
+
+	"States.Hash": {2, 2, func(args []interface{}) (interface{}, error) {
+		algorithm, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		var h hash.Hash
+		switch algorithm {
+		case "MD5":
+			h = md5.New()
+		case "SHA-1":
+			h = sha1.New()
+		case "SHA-256":
+			h = sha256.New()
+		case "SHA-384":
+			h = sha512.New384()
+		case "SHA-512":
+			h = sha512.New()
+		default:
+			return nil, fmt.Errorf("unknown algorithm %q", algorithm)
+		}
+
+		h.Write([]byte(formatValue(args[0])))
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}},
+
+	// MathRandom returns an integer from start (inclusive) to end (exclusive), an optional seed makes it repeatable
+	"States.MathRandom": {2, 3, func(args []interface{}) (interface{}, error) {
+		start, err := intArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := intArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		if end <= start {
+			return nil, fmt.Errorf("end must be greater than start")
+		}
+
+		if len(args) == 3 {
+			seed, err := intArg(args[2])
+			if err != nil {
+				return nil, err
+			}
+			return float64(start + mathrand.New(mathrand.NewSource(int64(seed))).Intn(end-start)), nil
+		}
+
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(end-start)))
+		if err != nil {
+			return nil, err
+		}
+		return float64(start + int(n.Int64())), nil
+	}},
+
+	"States.MathAdd": {2, 2, func(args []interface{}) (interface{}, error) {
+		a, ok := toNumber(args[0])
+		if !ok {
+			return nil, fmt.Errorf("arguments must be numbers")
+		}
+
+		b, ok := toNumber(args[1])
+		if !ok {
+			return nil, fmt.Errorf("arguments must be numbers")
+		}
+		return a + b, nil
+	}},
+
+	// StringSplit splits on every character of the delimiter
+	"States.StringSplit": {2, 2, func(args []interface{}) (interface{}, error) {
+		str, err := stringArg(args[0])
+		if err != nil {
+			return nil, err
+		}
+
+		delimiters, err := stringArg(args[1])
+		if err != nil {
+			return nil, err
+		}
+
+		parts := []interface{}{}
+		for _, part := range strings.FieldsFunc(str, func(r rune) bool { return strings.ContainsRune(delimiters, r) }) {
+			parts = append(parts, part)
+		}
+		return parts, nil
+	}},
+
+	"States.UUID": {0, 0, func(args []interface{}) (interface{}, error) {
+		return newUUID()
+	}},
+}
+
+func stringArg(arg interface{}) (string, error) {
+	str, ok := arg.(string)
+	if !ok {
+		return "", fmt.Errorf("argument %v must be a string", formatValue(arg))
+	}
+	return str, nil
+}
+
+func arrayArg(arg interface{}) ([]interface{}, error) {
+	if array, ok := arg.([]interface{}); ok {
+		return array, nil
+	}
+
+	// Inputs built in Go can hold typed slices e.g. []string
+	value := reflect.ValueOf(arg)
+	if value.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("argument %v must be an array", formatValue(arg))
+	}
+
+	array := []interface{}{}
+	for i := 0; i < value.Len(); i++ {
+		array = append(array, value.Index(i).Interface())
+	}
+	return array, nil
+}
+
+func intArg(arg interface{}) (int, error) {
+	number, ok := toNumber(arg)
+	if !ok || number != math.Trunc(number) {
+		return 0, fmt.Errorf("argument %v must be an integer", formatValue(arg))
+	}
+	return int(number), nil
+}
+
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+// jsonEqual compares values as JSON so 1 and 1.0 are equal
+func jsonEqual(a interface{}, b interface{}) bool {
+	araw, aerr := json.Marshal(a)
+	braw, berr := json.Marshal(b)
+	return aerr == nil && berr == nil && string(araw) == string(braw)
+}
+
+// newUUID returns a random version 4 UUID
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
@@ -0,0 +1,152 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+var intrinsicInput = map[string]interface{}{
+	"name":   "Bob",
+	"count":  3.0,
+	"list":   []interface{}{1.0, 2.0, 2.0, 3.0},
+	"json":   `{"a":"b"}`,
+	"object": map[string]interface{}{"a": "b"},
+	"csv":    "a,b;c",
+}
+
+func evalIntrinsic(expression string, t *testing.T) interface{} {
+	output, err := EvaluateIntrinsic(expression, intrinsicInput, map[string]interface{}{
+		"Execution": map[string]interface{}{"Id": "exec-id"},
+	})
+	assert.NoError(t, err)
+	return output
+}
+
+func Test_Intrinsic_Format(t *testing.T) {
+	assert.Equal(t, "Hello Bob", evalIntrinsic(`States.Format('Hello {}', $.name)`, t))
+	assert.Equal(t, "Bob has 3 in exec-id", evalIntrinsic(`States.Format('{} has {} in {}', $.name, $.count, $$.Execution.Id)`, t))
+	assert.Equal(t, `{Bob} it's \`, evalIntrinsic(`States.Format('\{{}\} it\'s \\', $.name)`, t))
+	assert.Equal(t, `{"a":"b"} true null`, evalIntrinsic(`States.Format('{} {} {}', $.object, true, null)`, t))
+}
+
+func Test_Intrinsic_Nested(t *testing.T) {
+	assert.Equal(t, "length 4", evalIntrinsic(`States.Format('length {}', States.ArrayLength($.list))`, t))
+	assert.Equal(t, "b", evalIntrinsic(`States.ArrayGetItem(States.StringSplit($.csv, ',;'), 1)`, t))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, evalIntrinsic(`States.StringToJson(States.JsonToString($.object))`, t))
+}
+
+func Test_Intrinsic_Json(t *testing.T) {
+	assert.Equal(t, map[string]interface{}{"a": "b"}, evalIntrinsic(`States.StringToJson($.json)`, t))
+	assert.Equal(t, `{"a":"b"}`, evalIntrinsic(`States.JsonToString($.object)`, t))
+}
+
+func Test_Intrinsic_Arrays(t *testing.T) {
+	assert.Equal(t, []interface{}{"Bob", 1.0, true}, evalIntrinsic(`States.Array($.name, 1, true)`, t))
+	assert.Equal(t, []interface{}{}, evalIntrinsic(`States.Array()`, t))
+
+	assert.Equal(t, []interface{}{
+		[]interface{}{1.0, 2.0, 2.0},
+		[]interface{}{3.0},
+	}, evalIntrinsic(`States.ArrayPartition($.list, 3)`, t))
+
+	assert.Equal(t, true, evalIntrinsic(`States.ArrayContains($.list, 3)`, t))
+	assert.Equal(t, false, evalIntrinsic(`States.ArrayContains($.list, 5)`, t))
+	assert.Equal(t, []interface{}{1.0, 3.0, 5.0}, evalIntrinsic(`States.ArrayRange(1, 6, 2)`, t))
+	assert.Equal(t, []interface{}{3.0, 2.0, 1.0}, evalIntrinsic(`States.ArrayRange(3, 1, -1)`, t))
+	assert.Equal(t, 2.0, evalIntrinsic(`States.ArrayGetItem($.list, 1)`, t))
+	assert.Equal(t, 4.0, evalIntrinsic(`States.ArrayLength($.list)`, t))
+	assert.Equal(t, []interface{}{1.0, 2.0, 3.0}, evalIntrinsic(`States.ArrayUnique($.list)`, t))
+}
+
+func Test_Intrinsic_Encoding(t *testing.T) {
+	assert.Equal(t, "Qm9i", evalIntrinsic(`States.Base64Encode($.name)`, t))
+	assert.Equal(t, "Bob", evalIntrinsic(`States.Base64Decode('Qm9i')`, t))
+	assert.Equal(t, "2fc1c0beb992cd7096975cfebf9d5c3b", evalIntrinsic(`States.Hash($.name, 'MD5')`, t))
+	assert.Equal(t, "cd9fb1e148ccd8442e5aa74904cc73bf6fb54d1d54d333bd596aa9bb4bb4e961", evalIntrinsic(`States.Hash('Bob', 'SHA-256')`, t))
+}
+
+func Test_Intrinsic_Math(t *testing.T) {
+	assert.Equal(t, 4.5, evalIntrinsic(`States.MathAdd($.count, 1.5)`, t))
+	assert.Equal(t, 2.0, evalIntrinsic(`States.MathAdd($.count, -1)`, t))
+
+	random := evalIntrinsic(`States.MathRandom(1, 10)`, t).(float64)
+	assert.True(t, random >= 1 && random < 10)
+
+	// Seeded values repeat
+	assert.Equal(t, evalIntrinsic(`States.MathRandom(1, 1000, 7)`, t), evalIntrinsic(`States.MathRandom(1, 1000, 7)`, t))
+}
+
+func Test_Intrinsic_StringSplit_UUID(t *testing.T) {
+	assert.Equal(t, []interface{}{"a", "b", "c"}, evalIntrinsic(`States.StringSplit($.csv, ',;')`, t))
+	assert.Regexp(t, `^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, evalIntrinsic(`States.UUID()`, t))
+}
+
+func Test_Intrinsic_Errors(t *testing.T) {
+	cases := map[string]string{
+		`States.Unknown($.name)`:               `at 0 .* unknown function "States.Unknown"`,
+		`States.Format('Hello {}', $.name`:     `at 32 .* expected \) to close States.Format`,
+		`States.Format('Hello {}, $.name)`:     `at 14 .* unterminated string`,
+		`States.Format('Hello {}', $.name) x`:  `at 34 .* unexpected "x" after function`,
+		`States.Format('Hello {}', @)`:         `at 26 .* unexpected "@"`,
+		`States.Format('Hello {} {}', $.name)`: `more \{\} than arguments`,
+		`States.ArrayLength($.name)`:           `must be an array`,
+		`States.ArrayLength($.list, 1)`:        `wrong number of arguments`,
+		`States.ArrayGetItem($.list, 9)`:       `index 9 out of range`,
+		`States.Hash($.name, 'SHA-0')`:         `unknown algorithm`,
+		`States.Format('{}', $.missing)`:       `JSON path not found`,
+		`States.ArrayPartition($.list, 1.5)`:   `must be an integer`,
+		`States.StringToJson('{')`:             `States.StringToJson Error`,
+		`States.Array(trueX)`:                  `at 13 .* unexpected "trueX"`,
+		`States.Array(nullable, 1)`:            `at 13 .* unexpected "nullable"`,
+		`States.MathAdd(1-2, 1)`:               `at 15 .* bad number "1-2"`,
+		`States.MathAdd(-, 1)`:                 `at 15 .* bad number "-"`,
+		`States.MathAdd(1e, 1)`:                `at 15 .* bad number "1e"`,
+		`States.MathAdd(-Inf, 1)`:              `at 15 .* bad number "-Inf"`,
+	}
+
+	for expression, expected := range cases {
+		_, err := EvaluateIntrinsic(expression, intrinsicInput, nil)
+		if assert.Error(t, err, expression) {
+			assert.Regexp(t, expected, err.Error(), expression)
+		}
+	}
+}
+
+func Test_Intrinsic_Literals(t *testing.T) {
+	assert.Equal(t, []interface{}{true, false, nil}, evalIntrinsic(`States.Array(true,false , null)`, t))
+	assert.Equal(t, []interface{}{-2.5, 1000.0, 0.0}, evalIntrinsic(`States.Array(-2.5, 1e3,0)`, t))
+}
+
+func Test_Intrinsic_Evaluation_Failure(t *testing.T) {
+	_, err := EvaluateIntrinsic(`States.ArrayLength($.name)`, intrinsicInput, nil)
+	assert.Equal(t, "States.IntrinsicFailure", to.ErrorType(err))
+
+	// A parse error is not an evaluation failure
+	_, err = EvaluateIntrinsic(`States.Unknown($.name)`, intrinsicInput, nil)
+	assert.NotEqual(t, "States.IntrinsicFailure", to.ErrorType(err))
+}
+
+func Test_PassState_Result_Intrinsic(t *testing.T) {
+	state := parsePassState([]byte(`{
+		"Next": "Pass",
+		"Result": {
+			"greeting.$": "States.Format('Hello {}', $.name)",
+			"nested": { "size.$": "States.ArrayLength($.list)" }
+		},
+		"ResultPath": "$.out"
+	}`), t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"name": "Bob", "list": []interface{}{1, 2}},
+		Output: map[string]interface{}{
+			"name": "Bob",
+			"list": []interface{}{1, 2},
+			"out": map[string]interface{}{
+				"greeting": "Hello Bob",
+				"nested":   map[string]interface{}{"size": 2.0},
+			},
+		},
+	}, t)
+}
@@ -0,0 +1,251 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	steperrors "github.com/coinbase/step/errors"
+	"github.com/coinbase/step/jsonpath"
+)
+
+type MapState struct {
+	stateStr // Include Defaults
+
+	Type    *string
+	Comment *string `json:",omitempty"`
+
+	InputPath      *jsonpath.Path `json:",omitempty"`
+	OutputPath     *jsonpath.Path `json:",omitempty"`
+	ResultPath     *jsonpath.Path `json:",omitempty"`
+	ResultSelector interface{}    `json:",omitempty"`
+	ItemsPath      *jsonpath.Path `json:",omitempty"`
+
+	// ItemSelector (or the legacy Parameters) selects the input of each item
+	// "$$.Map.Item.Value" and "$$.Map.Item.Index" refer to the current item
+	ItemSelector interface{} `json:",omitempty"`
+	Parameters   interface{} `json:",omitempty"`
+
+	// Iterator (or the newer ItemProcessor) is parsed by the machine package as a nested State Machine
+	Iterator      Machine `json:",omitempty"`
+	ItemProcessor Machine `json:",omitempty"`
+
+	MaxConcurrency             int      `json:",omitempty"` // 0 is no limit
+	ToleratedFailurePercentage *float64 `json:",omitempty"`
+
+	Catch []*Catcher `json:",omitempty"`
+	Retry []*Retrier `json:",omitempty"`
+
+	Next *string `json:",omitempty"`
+	End  *bool   `json:",omitempty"`
+}
+
+func (s *MapState) processor() Machine {
+	if s.ItemProcessor != nil {
+		return s.ItemProcessor
+	}
+	return s.Iterator
+}
+
+func (s *MapState) itemSelector() interface{} {
+	if s.ItemSelector != nil {
+		return s.ItemSelector
+	}
+	return s.Parameters
+}
+
+// itemInput returns the input for the item at index, using the ItemSelector if defined
+func (s *MapState) itemInput(ctx context.Context, input interface{}, index int, item interface{}) (interface{}, error) {
+	if s.itemSelector() == nil {
+		// Each item gets its own copy
+		return copyJSON(item)
+	}
+
+	contextObject := map[string]interface{}{}
+	for k, v := range ContextObject(ctx) {
+		contextObject[k] = v
+	}
+	contextObject["Map"] = map[string]interface{}{
+		"Item": map[string]interface{}{"Index": index, "Value": item},
+	}
+
+	selected, err := replaceParamsJSONPath(s.itemSelector(), input, contextObject)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyJSON(selected)
+}
+
+// process runs the processor over every item with at most MaxConcurrency at once
+// Without ToleratedFailurePercentage the first failure cancels the remaining items
+func (s *MapState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
+	items_value, err := s.ItemsPath.Get(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	items, ok := items_value.([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("ItemsPath must select an array")
+	}
+
+	// Select all item inputs before starting any items
+	itemInputs := make([]interface{}, len(items))
+	for i, item := range items {
+		if itemInputs[i], err = s.itemInput(ctx, input, i, item); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	concurrency := s.MaxConcurrency
+	if concurrency <= 0 || concurrency > len(items) {
+		concurrency = len(items)
+	}
+
+	outputs := make([]interface{}, len(items))
+	errs := make([]error, len(items))
+
+	// Each of the concurrency slots has a Clock, an item starts when the previous item in its slot ended
+	clocks, joinClocks := branchClocks(ctx, concurrency)
+	slots := make(chan Clock, concurrency)
+	for _, clock := range clocks {
+		slots <- clock
+	}
+
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+
+	for i, itemInput := range itemInputs {
+		wg.Add(1)
+		clock := <-slots
+		go func(i int, itemInput interface{}, clock Clock) {
+			defer wg.Done()
+			defer func() { slots <- clock }()
+
+			itemCtx := WithClock(WithBranch(ctx, s.Name(), i), clock)
+			outputs[i], errs[i] = s.processor().ExecuteBranch(itemCtx, itemInput)
+			if errs[i] != nil && s.ToleratedFailurePercentage == nil {
+				failOnce.Do(cancel)
+			}
+		}(i, itemInput, clock)
+	}
+
+	wg.Wait()
+	joinClocks()
+
+	failures := 0
+	var firstErr error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failures++
+		outputs[i] = errorOutputFromError(err)
+
+		// Report the failure that caused the cancellation, not a cancelled item
+		if firstErr == nil && !errors.Is(err, context.Canceled) {
+			firstErr = fmt.Errorf("Item %v: %w", i, err)
+		}
+	}
+
+	if failures > 0 && firstErr == nil {
+		firstErr = context.Canceled
+	}
+
+	if failures == 0 {
+		return outputs, nextState(s.Next, s.End), nil
+	}
+
+	// Cancelled from outside e.g. by a failing sibling, the items did not fail
+	if parent.Err() != nil {
+		return nil, nil, parent.Err()
+	}
+
+	if s.ToleratedFailurePercentage == nil {
+		return nil, nil, steperrors.StatesError{Name: "States.BranchFailed", Cause: firstErr.Error()}
+	}
+
+	if percentage := 100 * float64(failures) / float64(len(items)); percentage > *s.ToleratedFailurePercentage {
+		return nil, nil, steperrors.StatesError{
+			Name:  "States.ExceedToleratedFailureThreshold",
+			Cause: fmt.Sprintf("%v of %v items failed, %v", failures, len(items), firstErr),
+		}
+	}
+
+	return outputs, nextState(s.Next, s.End), nil
+}
+
+func (s *MapState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
+	return processError(s,
+		processCatcher(s.Name(), s.Catch,
+			processRetrier(s.Name(), s.Retry,
+				inputOutput(
+					s.InputPath,
+					s.OutputPath,
+					result(s.ResultPath, withResultSelector(s.ResultSelector, s.process)),
+				),
+			),
+		),
+	)(ctx, input)
+}
+
+func (s *MapState) Validate() error {
+	setType(s, "Map")
+
+	if err := ValidateNameAndType(s); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if err := endValid(s.Next, s.End); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if (s.Iterator == nil) == (s.ItemProcessor == nil) {
+		return fmt.Errorf("%v Exactly One (Iterator,ItemProcessor)", errorPrefix(s))
+	}
+
+	if err := s.processor().Validate(); err != nil {
+		return fmt.Errorf("%v Processor %v", errorPrefix(s), err)
+	}
+
+	if s.ItemSelector != nil && s.Parameters != nil {
+		return fmt.Errorf("%v ItemSelector and Parameters both defined", errorPrefix(s))
+	}
+
+	if s.MaxConcurrency < 0 {
+		return fmt.Errorf("%v MaxConcurrency must be positive", errorPrefix(s))
+	}
+
+	if p := s.ToleratedFailurePercentage; p != nil && (*p < 0 || *p > 100) {
+		return fmt.Errorf("%v ToleratedFailurePercentage must be between 0 and 100", errorPrefix(s))
+	}
+
+	if err := catchValid(s.Catch); err != nil {
+		return err
+	}
+
+	if err := resultSelectorValid(s.ResultSelector); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if err := retryValid(s.Retry); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *MapState) SetType(t *string) {
+	s.Type = t
+}
+
+func (s *MapState) GetType() *string {
+	return s.Type
+}
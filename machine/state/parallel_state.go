@@ -3,8 +3,10 @@ package state
 import (
 	"context"
 	"fmt"
+	"sync"
 
-	"github.com/coinbase/step/utils/to"
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/jsonpath"
 )
 
 type ParallelState struct {
@@ -12,19 +14,132 @@ type ParallelState struct {
 
 	Type    *string
 	Comment *string `json:",omitempty"`
+
+	InputPath      *jsonpath.Path `json:",omitempty"`
+	OutputPath     *jsonpath.Path `json:",omitempty"`
+	ResultPath     *jsonpath.Path `json:",omitempty"`
+	ResultSelector interface{}    `json:",omitempty"`
+	Parameters     interface{}    `json:",omitempty"`
+
+	// Branches are parsed by the machine package as nested State Machines
+	Branches []Machine `json:",omitempty"`
+
+	Catch []*Catcher `json:",omitempty"`
+	Retry []*Retrier `json:",omitempty"`
+
+	Next *string `json:",omitempty"`
+	End  *bool   `json:",omitempty"`
 }
 
-func (s *ParallelState) Execute(_ context.Context, input interface{}) (output interface{}, next *string, err error) {
-	return input, nil, nil
+// process runs all branches concurrently, the first branch to fail cancels the rest
+func (s *ParallelState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	outputs := make([]interface{}, len(s.Branches))
+
+	var wg sync.WaitGroup
+	var failOnce sync.Once
+	var branchErr error
+
+	// Branches wait concurrently, the State ends with the last of them
+	clocks, joinClocks := branchClocks(ctx, len(s.Branches))
+
+	for i, branch := range s.Branches {
+		// Each branch gets its own copy of the input
+		branchInput, err := copyJSON(input)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		wg.Add(1)
+		go func(i int, branch Machine, branchInput interface{}) {
+			defer wg.Done()
+
+			branchCtx := WithClock(WithBranch(ctx, s.Name(), i), clocks[i])
+			output, err := branch.ExecuteBranch(branchCtx, branchInput)
+			if err != nil {
+				failOnce.Do(func() {
+					branchErr = fmt.Errorf("Branch %v: %w", i, err)
+					cancel()
+				})
+				return
+			}
+
+			outputs[i] = output
+		}(i, branch, branchInput)
+	}
+
+	wg.Wait()
+	joinClocks()
+
+	// Cancelled from outside e.g. by a failing sibling, the branches did not fail
+	if branchErr != nil && parent.Err() != nil {
+		return nil, nil, branchErr
+	}
+
+	if branchErr != nil {
+		return nil, nil, errors.StatesError{Name: "States.BranchFailed", Cause: branchErr.Error()}
+	}
+
+	return outputs, nextState(s.Next, s.End), nil
+}
+
+func (s *ParallelState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
+	return processError(s,
+		processCatcher(s.Name(), s.Catch,
+			processRetrier(s.Name(), s.Retry,
+				inputOutput(
+					s.InputPath,
+					s.OutputPath,
+					withParams(
+						s.Parameters,
+						result(s.ResultPath, withResultSelector(s.ResultSelector, s.process)),
+					),
+				),
+			),
+		),
+	)(ctx, input)
 }
 
 func (s *ParallelState) Validate() error {
-	s.SetType(to.Strp("Parallel"))
+	setType(s, "Parallel")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
 	}
 
+	if err := endValid(s.Next, s.End); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if len(s.Branches) == 0 {
+		return fmt.Errorf("%v Must have Branches", errorPrefix(s))
+	}
+
+	for i, branch := range s.Branches {
+		if branch == nil {
+			return fmt.Errorf("%v Branch %v is nil", errorPrefix(s), i)
+		}
+
+		if err := branch.Validate(); err != nil {
+			return fmt.Errorf("%v Branch %v %v", errorPrefix(s), i, err)
+		}
+	}
+
+	if err := catchValid(s.Catch); err != nil {
+		return err
+	}
+
+	if err := resultSelectorValid(s.ResultSelector); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
+	if err := retryValid(s.Retry); err != nil {
+		return err
+	}
+
 	return nil
 }
 
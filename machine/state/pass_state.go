@@ -5,7 +5,6 @@ import (
 	"fmt"
 
 	"github.com/coinbase/step/jsonpath"
-	"github.com/coinbase/step/utils/to"
 )
 
 type PassState struct {
@@ -35,7 +34,7 @@ func (s *PassState) Execute(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *PassState) process(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
-	resolvedResult, err := replaceParamsJSONPath(s.Result, input)
+	resolvedResult, err := replaceParamsJSONPath(s.Result, input, ContextObject(ctx))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -43,7 +42,7 @@ func (s *PassState) process(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *PassState) Validate() error {
-	s.SetType(to.Strp("Pass"))
+	setType(s, "Pass")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
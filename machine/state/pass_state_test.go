@@ -141,3 +141,25 @@ func Test_PassState_BadOutputPath(t *testing.T) {
 		Error: to.Strp("Output Error"),
 	}, t)
 }
+
+func Test_PassState_Result_JSONPath_Filter(t *testing.T) {
+	state := parsePassState([]byte(`{
+		"Next": "Pass",
+		"Result": {
+			"cheap.$": "$.items[?(@.price < 10)].name",
+			"last.$": "$.items[-1]['item name']"
+		},
+		"ResultPath": "$.found",
+		"OutputPath": "$.found"
+	}`), t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"name": "a", "price": 5.0, "item name": "A"},
+				map[string]interface{}{"name": "b", "price": 15.0, "item name": "B"},
+			},
+		},
+		Output: map[string]interface{}{"cheap": []interface{}{"a"}, "last": "B"},
+	}, t)
+}
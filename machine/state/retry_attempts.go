@@ -0,0 +1,75 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// RetryAttempts counts the retries made by each Retrier of each State during one Execution,
+// so Executions of the same State Machine do not share their attempts
+type RetryAttempts struct {
+	lock     sync.Mutex
+	attempts map[string][]int // by State name, then Retrier index
+}
+
+// NewRetryAttempts returns the counts starting from attempts, e.g. restored from a Snapshot
+func NewRetryAttempts(attempts map[string][]int) *RetryAttempts {
+	r := &RetryAttempts{attempts: map[string][]int{}}
+	for name, counts := range attempts {
+		r.attempts[name] = append([]int{}, counts...)
+	}
+	return r
+}
+
+// Attempts returns a copy of the attempts of every State that has retried
+func (r *RetryAttempts) Attempts() map[string][]int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	attempts := map[string][]int{}
+	for name, counts := range r.attempts {
+		attempts[name] = append([]int{}, counts...)
+	}
+	return attempts
+}
+
+// retry counts an attempt of Retrier i of the State name and returns it,
+// or false if the Retrier has already made maxAttempts
+func (r *RetryAttempts) retry(name string, i int, maxAttempts int) (int, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	counts := r.attempts[name]
+	for len(counts) <= i {
+		counts = append(counts, 0)
+	}
+
+	if counts[i] >= maxAttempts {
+		return counts[i], false
+	}
+
+	counts[i]++
+	r.attempts[name] = counts
+	return counts[i], true
+}
+
+// retryAttemptsKey stores the RetryAttempts in a context.Context
+type retryAttemptsKey struct{}
+
+// WithRetryAttempts returns a Context holding the RetryAttempts of the Execution
+func WithRetryAttempts(ctx context.Context, attempts *RetryAttempts) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, retryAttemptsKey{}, attempts)
+}
+
+// ContextRetryAttempts returns the RetryAttempts from ctx, or nil
+func ContextRetryAttempts(ctx context.Context) *RetryAttempts {
+	if ctx == nil {
+		return nil
+	}
+
+	attempts, _ := ctx.Value(retryAttemptsKey{}).(*RetryAttempts)
+	return attempts
+}
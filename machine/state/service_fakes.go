@@ -0,0 +1,338 @@
+package state
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/handler"
+)
+
+// FakeServices are in memory fakes of the AWS services Task states commonly integrate with,
+// they take the Parameters and return the response shapes of the real integrations
+type FakeServices struct {
+	lock sync.Mutex
+
+	functions map[string]interface{}              // Lambda handlers by function name
+	queues    map[string][]string                 // SQS message bodies by QueueUrl
+	topics    map[string][]string                 // SNS messages by TopicArn
+	tables    map[string][]map[string]interface{} // DynamoDB items by TableName
+	objects   map[string]string                   // S3 object bodies by Bucket/Key
+}
+
+func NewFakeServices() *FakeServices {
+	return &FakeServices{
+		functions: map[string]interface{}{},
+		queues:    map[string][]string{},
+		topics:    map[string][]string{},
+		tables:    map[string][]map[string]interface{}{},
+		objects:   map[string]string{},
+	}
+}
+
+// Integrations returns a registry with the fakes of Lambda, SQS, SNS, DynamoDB and S3
+func (f *FakeServices) Integrations() *Integrations {
+	integrations := NewIntegrations()
+
+	integrations.Register("arn:aws:lambda:*:*:function:*", f.lambdaFunction)
+	integrations.Register("arn:aws:states:::lambda:invoke*", f.lambdaInvoke)
+	integrations.Register("arn:aws:states:::sqs:sendMessage*", f.sqsSendMessage)
+	integrations.Register("arn:aws:states:::aws-sdk:sqs:sendMessage", f.sqsSendMessage)
+	integrations.Register("arn:aws:states:::sns:publish*", f.snsPublish)
+	integrations.Register("arn:aws:states:::aws-sdk:sns:publish", f.snsPublish)
+	integrations.Register("arn:aws:states:::dynamodb:putItem", f.dynamodbPutItem)
+	integrations.Register("arn:aws:states:::aws-sdk:dynamodb:putItem", f.dynamodbPutItem)
+	integrations.Register("arn:aws:states:::dynamodb:getItem", f.dynamodbGetItem)
+	integrations.Register("arn:aws:states:::aws-sdk:dynamodb:getItem", f.dynamodbGetItem)
+	integrations.Register("arn:aws:states:::aws-sdk:s3:getObject", f.s3GetObject)
+
+	return integrations
+}
+
+// SetFunction sets the handler of the Lambda function name
+func (f *FakeServices) SetFunction(name string, handlerFn interface{}) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.functions[name] = handlerFn
+}
+
+// PutObject stores an S3 object
+func (f *FakeServices) PutObject(bucket string, key string, body string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.objects[bucket+"/"+key] = body
+}
+
+// Messages returns the message bodies sent to the SQS queue
+func (f *FakeServices) Messages(queueURL string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.queues[queueURL]...)
+}
+
+// Published returns the messages published to the SNS topic
+func (f *FakeServices) Published(topicArn string) []string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]string{}, f.topics[topicArn]...)
+}
+
+// Items returns the items put in the DynamoDB table
+func (f *FakeServices) Items(table string) []map[string]interface{} {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return append([]map[string]interface{}{}, f.tables[table]...)
+}
+
+// lambdaFunction invokes the function of a Lambda ARN Resource with the whole input and returns its result
+func (f *FakeServices) lambdaFunction(ctx context.Context, resource string, parameters interface{}) (interface{}, error) {
+	return f.invoke(ctx, resource, parameters)
+}
+
+// lambdaInvoke invokes FunctionName with the Payload and returns the Lambda Invoke response
+func (f *FakeServices) lambdaInvoke(ctx context.Context, resource string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := paramString(params, "FunctionName")
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := f.invoke(ctx, name, params["Payload"])
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"ExecutedVersion": "$LATEST",
+		"Payload":         payload,
+		"StatusCode":      200,
+	}, nil
+}
+
+func (f *FakeServices) invoke(ctx context.Context, function string, payload interface{}) (interface{}, error) {
+	name := lambdaFunctionName(function)
+
+	f.lock.Lock()
+	handlerFn, ok := f.functions[name]
+	f.lock.Unlock()
+
+	if !ok {
+		return nil, errors.StatesError{Name: "Lambda.ResourceNotFoundException", Cause: fmt.Sprintf("Function not found: %v", function)}
+	}
+
+	result, err := handler.CallHandlerFunction(handlerFn, ctx, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return copyJSON(result)
+}
+
+// lambdaFunctionName returns the name of a function name, partial ARN or ARN, without version or alias
+func lambdaFunctionName(function string) string {
+	parts := strings.Split(function, ":")
+
+	switch {
+	case len(parts) >= 7 && parts[0] == "arn":
+		return parts[6]
+	case len(parts) >= 3 && parts[1] == "function":
+		// account:function:name
+		return parts[2]
+	}
+
+	return parts[0]
+}
+
+func (f *FakeServices) sqsSendMessage(_ context.Context, _ string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	queueURL, err := paramString(params, "QueueUrl")
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := messageString(params, "MessageBody")
+	if err != nil {
+		return nil, err
+	}
+
+	messageID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	f.queues[queueURL] = append(f.queues[queueURL], body)
+	f.lock.Unlock()
+
+	return map[string]interface{}{
+		"MD5OfMessageBody": fmt.Sprintf("%x", md5.Sum([]byte(body))),
+		"MessageId":        messageID,
+	}, nil
+}
+
+func (f *FakeServices) snsPublish(_ context.Context, _ string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	topicArn, err := paramString(params, "TopicArn")
+	if err != nil {
+		return nil, err
+	}
+
+	message, err := messageString(params, "Message")
+	if err != nil {
+		return nil, err
+	}
+
+	messageID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	f.topics[topicArn] = append(f.topics[topicArn], message)
+	f.lock.Unlock()
+
+	return map[string]interface{}{"MessageId": messageID}, nil
+}
+
+func (f *FakeServices) dynamodbPutItem(_ context.Context, _ string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := paramString(params, "TableName")
+	if err != nil {
+		return nil, err
+	}
+
+	item, ok := params["Item"].(map[string]interface{})
+	if !ok {
+		return nil, errors.StatesError{Name: "DynamoDB.ValidationException", Cause: "Item must be an object of attribute values"}
+	}
+
+	f.lock.Lock()
+	f.tables[table] = append(f.tables[table], item)
+	f.lock.Unlock()
+
+	return map[string]interface{}{}, nil
+}
+
+// dynamodbGetItem returns the last item put with every attribute of Key
+func (f *FakeServices) dynamodbGetItem(_ context.Context, _ string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := paramString(params, "TableName")
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := params["Key"].(map[string]interface{})
+	if !ok {
+		return nil, errors.StatesError{Name: "DynamoDB.ValidationException", Cause: "Key must be an object of attribute values"}
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	items := f.tables[table]
+	for i := len(items) - 1; i >= 0; i-- {
+		if itemHasKey(items[i], key) {
+			return map[string]interface{}{"Item": items[i]}, nil
+		}
+	}
+
+	return map[string]interface{}{}, nil
+}
+
+func itemHasKey(item map[string]interface{}, key map[string]interface{}) bool {
+	for name, value := range key {
+		if !reflect.DeepEqual(item[name], value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *FakeServices) s3GetObject(_ context.Context, _ string, parameters interface{}) (interface{}, error) {
+	params, err := integrationParams(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	bucket, err := paramString(params, "Bucket")
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := paramString(params, "Key")
+	if err != nil {
+		return nil, err
+	}
+
+	f.lock.Lock()
+	body, ok := f.objects[bucket+"/"+key]
+	f.lock.Unlock()
+
+	if !ok {
+		return nil, errors.StatesError{Name: "S3.NoSuchKeyException", Cause: "The specified key does not exist."}
+	}
+
+	return map[string]interface{}{
+		"Body":          body,
+		"ContentLength": len(body),
+		"ContentType":   "application/octet-stream",
+		"ETag":          fmt.Sprintf("\"%x\"", md5.Sum([]byte(body))),
+	}, nil
+}
+
+func integrationParams(parameters interface{}) (map[string]interface{}, error) {
+	params, ok := parameters.(map[string]interface{})
+	if !ok {
+		return nil, errors.StatesError{Name: "States.Runtime", Cause: "Parameters must be an object"}
+	}
+	return params, nil
+}
+
+func paramString(params map[string]interface{}, name string) (string, error) {
+	str, ok := params[name].(string)
+	if !ok || str == "" {
+		return "", errors.StatesError{Name: "States.Runtime", Cause: fmt.Sprintf("Parameters %v must be a string", name)}
+	}
+	return str, nil
+}
+
+// messageString returns a string parameter, serializing JSON values as Step Functions does
+func messageString(params map[string]interface{}, name string) (string, error) {
+	switch value := params[name].(type) {
+	case nil:
+		return "", errors.StatesError{Name: "States.Runtime", Cause: fmt.Sprintf("Parameters %v is required", name)}
+	case string:
+		return value, nil
+	default:
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(raw), nil
+	}
+}
@@ -0,0 +1,145 @@
+package state
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func executeIntegration(services *FakeServices, taskJSON string, input interface{}, t *testing.T) (interface{}, error) {
+	state := parseTaskState([]byte(taskJSON), t)
+	assert.NoError(t, state.Validate())
+
+	ctx := WithIntegrations(context.Background(), services.Integrations())
+	output, _, err := state.Execute(ctx, input)
+	return output, err
+}
+
+func Test_FakeServices_LambdaInvoke(t *testing.T) {
+	services := NewFakeServices()
+	services.SetFunction("double", func(_ context.Context, input map[string]interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"n": input["n"].(float64) * 2}, nil
+	})
+
+	output, err := executeIntegration(services, `{
+    "Resource": "arn:aws:states:::lambda:invoke",
+    "Parameters": { "FunctionName": "arn:aws:lambda:us-east-1:000000000000:function:double:live", "Payload": { "n.$": "$.n" } },
+    "ResultSelector": { "n.$": "$.Payload.n", "status.$": "$.StatusCode" },
+    "ResultPath": "$.result",
+    "End": true
+  }`, map[string]interface{}{"n": 2}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"n": 4.0, "status": 200.0}, output.(map[string]interface{})["result"])
+
+	// A Lambda ARN Resource is called with the whole input
+	output, err = executeIntegration(services, `{
+    "Resource": "arn:aws:lambda:us-east-1:000000000000:function:double",
+    "End": true
+  }`, map[string]interface{}{"n": 3}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 6.0, output.(map[string]interface{})["n"])
+
+	_, err = executeIntegration(services, `{
+    "Resource": "arn:aws:states:::lambda:invoke",
+    "Parameters": { "FunctionName": "missing" },
+    "Catch": [{ "ErrorEquals": ["Lambda.ResourceNotFoundException"], "Next": "Caught" }],
+    "End": true
+  }`, map[string]interface{}{}, t)
+
+	assert.NoError(t, err)
+}
+
+func Test_FakeServices_SQS_SNS(t *testing.T) {
+	services := NewFakeServices()
+
+	output, err := executeIntegration(services, `{
+    "Resource": "arn:aws:states:::sqs:sendMessage",
+    "Parameters": { "QueueUrl": "https://sqs/queue", "MessageBody": { "id.$": "$.id" } },
+    "End": true
+  }`, map[string]interface{}{"id": "a"}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`{"id":"a"}`}, services.Messages("https://sqs/queue"))
+	assert.Len(t, output.(map[string]interface{})["MD5OfMessageBody"], 32)
+	assert.NotEmpty(t, output.(map[string]interface{})["MessageId"])
+
+	_, err = executeIntegration(services, `{
+    "Resource": "arn:aws:states:::sns:publish",
+    "Parameters": { "TopicArn": "arn:aws:sns:us-east-1:000000000000:topic", "Message": "hello" },
+    "End": true
+  }`, map[string]interface{}{}, t)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"hello"}, services.Published("arn:aws:sns:us-east-1:000000000000:topic"))
+
+	_, err = executeIntegration(services, `{
+    "Resource": "arn:aws:states:::sqs:sendMessage",
+    "Parameters": { "MessageBody": "no queue" },
+    "End": true
+  }`, map[string]interface{}{}, t)
+
+	assert.Error(t, err)
+	assert.Regexp(t, "QueueUrl must be a string", err.Error())
+}
+
+func Test_FakeServices_DynamoDB_S3(t *testing.T) {
+	services := NewFakeServices()
+
+	_, err := executeIntegration(services, `{
+    "Resource": "arn:aws:states:::dynamodb:putItem",
+    "Parameters": { "TableName": "t", "Item": { "id": { "S": "1" }, "v": { "N": "2" } } },
+    "End": true
+  }`, map[string]interface{}{}, t)
+	assert.NoError(t, err)
+	assert.Len(t, services.Items("t"), 1)
+
+	output, err := executeIntegration(services, `{
+    "Resource": "arn:aws:states:::dynamodb:getItem",
+    "Parameters": { "TableName": "t", "Key": { "id": { "S": "1" } } },
+    "ResultPath": "$.get",
+    "End": true
+  }`, map[string]interface{}{}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"Item": map[string]interface{}{"id": map[string]interface{}{"S": "1"}, "v": map[string]interface{}{"N": "2"}},
+	}, output.(map[string]interface{})["get"])
+
+	services.PutObject("bucket", "key", "body")
+	output, err = executeIntegration(services, `{
+    "Resource": "arn:aws:states:::aws-sdk:s3:getObject",
+    "Parameters": { "Bucket": "bucket", "Key": "key" },
+    "End": true
+  }`, map[string]interface{}{}, t)
+	assert.NoError(t, err)
+	assert.Equal(t, "body", output.(map[string]interface{})["Body"])
+	assert.Equal(t, 4.0, output.(map[string]interface{})["ContentLength"])
+
+	_, err = executeIntegration(services, `{
+    "Resource": "arn:aws:states:::aws-sdk:s3:getObject",
+    "Parameters": { "Bucket": "bucket", "Key": "missing" },
+    "End": true
+  }`, map[string]interface{}{}, t)
+	assert.Error(t, err)
+	assert.Regexp(t, "S3.NoSuchKeyException", err.Error())
+}
+
+func Test_Integrations_Register(t *testing.T) {
+	integrations := NewIntegrations()
+	custom := func(_ context.Context, resource string, _ interface{}) (interface{}, error) {
+		return map[string]interface{}{"resource": resource}, nil
+	}
+
+	integrations.Register("arn:aws:states:::*", custom)
+	_, ok := integrations.Find("arn:aws:states:::ecs:runTask.sync")
+	assert.True(t, ok)
+
+	_, ok = integrations.Find("arn:aws:lambda:us-east-1:0:function:f")
+	assert.False(t, ok)
+
+	var nilIntegrations *Integrations
+	_, ok = nilIntegrations.Find("r")
+	assert.False(t, ok)
+}
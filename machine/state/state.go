@@ -2,11 +2,17 @@ package state
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/handler"
 	"github.com/coinbase/step/jsonpath"
 	"github.com/coinbase/step/utils/is"
 	"github.com/coinbase/step/utils/to"
@@ -30,10 +36,40 @@ type State interface {
 	GetType() *string
 }
 
+// Machine is a nested State Machine e.g. a Parallel Branch
+type Machine interface {
+	ExecuteBranch(context.Context, interface{}) (interface{}, error)
+	Validate() error
+}
+
 type stateStr struct {
 	name *string `json:"-"`
 }
 
+// contextObjectKey stores the Context Object in a context.Context
+type contextObjectKey struct{}
+
+// WithContextObject returns a Context holding the Context Object "$$" paths are resolved against
+func WithContextObject(ctx context.Context, contextObject map[string]interface{}) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, contextObjectKey{}, contextObject)
+}
+
+// ContextObject returns the Context Object from ctx, e.g. {"Map": {"Item": {"Index": 0, "Value": ...}}}
+func ContextObject(ctx context.Context) map[string]interface{} {
+	if ctx == nil {
+		return map[string]interface{}{}
+	}
+
+	if contextObject, ok := ctx.Value(contextObjectKey{}).(map[string]interface{}); ok {
+		return contextObject
+	}
+
+	return map[string]interface{}{}
+}
+
 type Catcher struct {
 	ErrorEquals []*string      `json:",omitempty"`
 	ResultPath  *jsonpath.Path `json:",omitempty"`
@@ -45,10 +81,77 @@ type Retrier struct {
 	IntervalSeconds *int      `json:",omitempty"`
 	MaxAttempts     *int      `json:",omitempty"`
 	BackoffRate     *float64  `json:",omitempty"`
-	attempts        int       `json:"-"` // Used to remember attempts
+	attempts        int       `json:"-"` // Used to remember attempts outside an Execution
+}
+
+// retry counts an attempt of the Retrier, index i of the State name, and returns it,
+// or false once it has made MaxAttempts (default 3). Attempts are counted in the RetryAttempts of the Execution,
+// or on the Retrier itself for a State executed on its own.
+func (r *Retrier) retry(ctx context.Context, name *string, i int) (int, bool) {
+	maxAttempts := 3
+	if r.MaxAttempts != nil {
+		maxAttempts = *r.MaxAttempts
+	}
+
+	if attempts := ContextRetryAttempts(ctx); attempts != nil && name != nil {
+		return attempts.retry(*name, i, maxAttempts)
+	}
+
+	if r.attempts >= maxAttempts {
+		return r.attempts, false
+	}
+
+	r.attempts++
+	return r.attempts, true
+}
+
+// interval returns the wait before the attempt, IntervalSeconds (default 1)
+// multiplied by BackoffRate (default 2.0) for every attempt after the first
+func (r *Retrier) interval(attempt int) time.Duration {
+	interval := 1.0
+	if r.IntervalSeconds != nil {
+		interval = float64(*r.IntervalSeconds)
+	}
+
+	backoffRate := 2.0
+	if r.BackoffRate != nil {
+		backoffRate = *r.BackoffRate
+	}
+
+	return secondsDuration(interval * math.Pow(backoffRate, float64(attempt-1)))
+}
+
+// mergeMaps returns a new map with the keys of base overwritten by over, neither is changed
+func mergeMaps(base map[string]interface{}, over map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
+
+// copyJSON deep copies a JSON value, unlike to.FromJSON a string stays a string
+func copyJSON(value interface{}) (interface{}, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var copied interface{}
+	if err := json.Unmarshal(raw, &copied); err != nil {
+		return nil, err
+	}
+
+	return copied, nil
 }
 
 func errorOutputFromError(err error) map[string]interface{} {
+	if taskErr, ok := err.(errors.StatesError); ok {
+		return errorOutput(&taskErr.Name, &taskErr.Cause)
+	}
 	return errorOutput(to.Strp(to.ErrorType(err)), to.Strp(err.Error()))
 }
 
@@ -112,23 +215,28 @@ func processRetrier(retryName *string, retriers []*Retrier, exec Execution) Exec
 		}
 
 		// Is Error in a Retrier
-		for _, retrier := range retriers {
-			// If the error type is defined in the retrier AND we have not attempted the retry yet
-			if retrier.MaxAttempts == nil {
-				// Default retries is 3
-				retrier.MaxAttempts = to.Intp(3)
-			}
-
+		for i, retrier := range retriers {
 			// Match on first retrier
 			if errorIncluded(retrier.ErrorEquals, err) {
-				if retrier.attempts < *retrier.MaxAttempts {
-					retrier.attempts++
-					// Returns the name of the state to the state-machine to re-execute
-					return input, retryName, nil
-				} else {
+				attempt, ok := retrier.retry(ctx, retryName, i)
+				if !ok {
 					// Finished retrying so continue
 					return output, next, err
 				}
+
+				interval := retrier.interval(attempt)
+
+				if recorder := ContextErrorRecorder(ctx); recorder != nil && retryName != nil {
+					recorder.Retried(*retryName, i, err, attempt, interval)
+				}
+
+				// Wait the backed off interval before retrying
+				if err := ContextClock(ctx).Sleep(ctx, interval); err != nil {
+					return nil, nil, err
+				}
+
+				// Returns the name of the state to the state-machine to re-execute
+				return input, retryName, nil
 			}
 		}
 
@@ -137,7 +245,7 @@ func processRetrier(retryName *string, retriers []*Retrier, exec Execution) Exec
 	}
 }
 
-func processCatcher(catchers []*Catcher, exec Execution) Execution {
+func processCatcher(catchName *string, catchers []*Catcher, exec Execution) Execution {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		output, next, err := exec(ctx, input)
 
@@ -145,10 +253,18 @@ func processCatcher(catchers []*Catcher, exec Execution) Execution {
 			return output, next, err
 		}
 
-		for _, catcher := range catchers {
+		for i, catcher := range catchers {
 			if errorIncluded(catcher.ErrorEquals, err) {
+				if recorder := ContextErrorRecorder(ctx); recorder != nil && catchName != nil {
+					recorder.Caught(*catchName, i, err, to.Strs(catcher.Next))
+				}
 
 				eo := errorOutputFromError(err)
+				if StrictDataFlow(ctx) {
+					output, err := applyResultPath(catcher.ResultPath, input, eo)
+					return output, catcher.Next, err
+				}
+
 				output, err := catcher.ResultPath.Set(input, eo)
 
 				return output, catcher.Next, err
@@ -164,6 +280,11 @@ func processError(s State, exec Execution) Execution {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		output, next, err := exec(ctx, input)
 
+		// A cancelled State keeps its cancellation, e.g. for a Map to tell a cancelled item from a failed one
+		if err != nil && ctx != nil && ctx.Err() != nil {
+			return nil, nil, fmt.Errorf("%v %w", errorPrefix(s), err)
+		}
+
 		if err != nil {
 			return nil, nil, fmt.Errorf("%v %v", errorPrefix(s), err.Error())
 		}
@@ -172,6 +293,10 @@ func processError(s State, exec Execution) Execution {
 }
 func inputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec Execution) Execution {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		if StrictDataFlow(ctx) {
+			return strictInputOutput(inputPath, outputPath, exec)(ctx, input)
+		}
+
 		origInput := input
 		input, err := inputPath.Get(input)
 
@@ -187,13 +312,13 @@ func inputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec Execu
 
 		// Auto-merge output into original input and return only when output is a map
 		// TODO: see if this magic is doing more harm
-		if out, ok := output.(map[string]interface{}); ok {
-			for k, v := range out {
-				origInput.(map[string]interface{})[k] = v
-			}
+		out, outIsMap := output.(map[string]interface{})
+		orig, origIsMap := origInput.(map[string]interface{})
+		if outIsMap && origIsMap {
+			output = mergeMaps(orig, out)
 		}
 
-		output, err = outputPath.Get(origInput)
+		output, err = outputPath.Get(output)
 
 		if err != nil {
 			return nil, nil, fmt.Errorf("Output Error: %v", err)
@@ -203,13 +328,74 @@ func inputOutput(inputPath *jsonpath.Path, outputPath *jsonpath.Path, exec Execu
 	}
 }
 
+// withTimeout fails exec with States.Timeout if it takes longer than timeoutSeconds,
+// or longer than heartbeatSeconds between heartbeats sent with handler.Heartbeat.
+// Timeouts are real time, as handlers run in real time, the Clock only simulates Wait and Retry intervals.
+// The handler is cancelled through ctx and always waited for, it must return once ctx is done.
+func withTimeout(timeoutSeconds int, heartbeatSeconds int, exec Execution) Execution {
+	return func(parent context.Context, input interface{}) (interface{}, *string, error) {
+		if timeoutSeconds <= 0 && heartbeatSeconds <= 0 {
+			return exec(parent, input)
+		}
+
+		if parent == nil {
+			parent = context.Background()
+		}
+
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeoutSeconds > 0 {
+			ctx, cancel = context.WithTimeout(parent, time.Duration(timeoutSeconds)*time.Second)
+		} else {
+			ctx, cancel = context.WithCancel(parent)
+		}
+		defer cancel()
+
+		if heartbeatSeconds > 0 {
+			heartbeatInterval := time.Duration(heartbeatSeconds) * time.Second
+
+			var lock sync.Mutex
+			heartbeatTimer := time.AfterFunc(heartbeatInterval, cancel)
+			defer heartbeatTimer.Stop()
+
+			ctx = handler.WithHeartbeat(ctx, func() error {
+				lock.Lock()
+				defer lock.Unlock()
+
+				if ctx.Err() != nil {
+					return errors.StatesError{Name: "States.Timeout", Cause: "Heartbeat after timeout"}
+				}
+
+				heartbeatTimer.Reset(heartbeatInterval)
+				return nil
+			})
+		}
+
+		output, next, err := exec(ctx, input)
+		if ctx.Err() == nil {
+			return output, next, err
+		}
+
+		// Cancelled from outside e.g. by a failing Parallel Branch
+		if parent.Err() != nil {
+			return nil, nil, parent.Err()
+		}
+
+		// A result after the timeout is too late, like in AWS
+		return nil, nil, errors.StatesError{
+			Name:  "States.Timeout",
+			Cause: fmt.Sprintf("exceeded TimeoutSeconds(%v) or HeartbeatSeconds(%v)", timeoutSeconds, heartbeatSeconds),
+		}
+	}
+}
+
 func withParams(params interface{}, exec Execution) Execution {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
 		if params == nil {
 			return exec(ctx, input)
 		}
 		// Loop through the input replace values with JSON paths
-		input, err := replaceParamsJSONPath(params, input)
+		input, err := replaceParamsJSONPath(params, input, ContextObject(ctx))
 		if err != nil {
 			return nil, nil, err
 		}
@@ -218,11 +404,41 @@ func withParams(params interface{}, exec Execution) Execution {
 	}
 }
 
+// withResultSelector reshapes the result of exec using the ".$" keys of selector before ResultPath is applied
+func withResultSelector(selector interface{}, exec Execution) Execution {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		result, next, err := exec(ctx, input)
+		if err != nil || selector == nil {
+			return result, next, err
+		}
+
+		result, err = replaceParamsJSONPath(selector, result, ContextObject(ctx))
+		if err != nil {
+			return nil, nil, fmt.Errorf("ResultSelector Error: %v", err)
+		}
+
+		return result, next, nil
+	}
+}
+
+func resultSelectorValid(selector interface{}) error {
+	if selector == nil {
+		return nil
+	}
+
+	if _, ok := selector.(map[string]interface{}); !ok {
+		return fmt.Errorf("ResultSelector must be an object")
+	}
+
+	return nil
+}
+
 func hasHandlebars(input string) bool {
 	return strings.Contains(input, openBraces) && strings.Contains(input, closeBraces)
 }
 
-func replaceParamsJSONPath(params interface{}, input interface{}) (interface{}, error) {
+// replaceParamsJSONPath resolves the ".$" keys of params against input, "$$" paths against contextObject
+func replaceParamsJSONPath(params interface{}, input interface{}, contextObject interface{}) (interface{}, error) {
 	switch params.(type) {
 	case map[string]interface{}:
 		newParams := map[string]interface{}{}
@@ -233,7 +449,13 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 				// value must be a JSON path string!
 				if valueStr, ok := value.(string); ok {
 					// TODO: This should be extracted later
-					if hasHandlebars(valueStr) {
+					if IsIntrinsic(valueStr) {
+						newValue, err := EvaluateIntrinsic(valueStr, input, contextObject)
+						if err != nil {
+							return nil, err
+						}
+						newParams[key] = newValue
+					} else if hasHandlebars(valueStr) {
 						var oldValueStr string // prevent infinit loop below
 						// Resolve all handlebars
 						for hasHandlebars(valueStr) && oldValueStr != valueStr {
@@ -267,12 +489,17 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 						}
 						newParams[key] = valueStr
 					} else {
-						// resolve direct path
+						// resolve direct path, "$$" paths are in the Context Object
+						pathInput := input
+						if strings.HasPrefix(valueStr, "$$") {
+							valueStr = valueStr[1:]
+							pathInput = contextObject
+						}
 						path, err := jsonpath.NewPath(valueStr)
 						if err != nil {
 							return nil, err
 						}
-						newValue, err := path.Get(input)
+						newValue, err := path.Get(pathInput)
 						if err != nil {
 							return nil, err
 						}
@@ -282,7 +509,7 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 					return nil, fmt.Errorf("value to key %q is not string", key)
 				}
 			} else {
-				newValue, err := replaceParamsJSONPath(value, input)
+				newValue, err := replaceParamsJSONPath(value, input, contextObject)
 				if err != nil {
 					return nil, err
 				}
@@ -296,12 +523,21 @@ func replaceParamsJSONPath(params interface{}, input interface{}) (interface{},
 
 func result(resultPath *jsonpath.Path, exec Execution) Execution {
 	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		if StrictDataFlow(ctx) {
+			return strictResult(resultPath, exec)(ctx, input)
+		}
+
 		result, next, err := exec(ctx, input)
 
 		if err != nil {
 			return nil, nil, err
 		}
 
+		// Only a map can be set into the input, anything else at the root replaces it
+		if _, isMap := result.(map[string]interface{}); result != nil && !isMap && resultPath.IsRoot() {
+			return result, next, nil
+		}
+
 		if result != nil {
 			// merge result if original result and new result are both map
 			originalValue, getPathError := resultPath.Get(input)
@@ -309,10 +545,7 @@ func result(resultPath *jsonpath.Path, exec Execution) Execution {
 				originalMapResult, originalReslutIsMap := originalValue.(map[string]interface{})
 				newMapResult, newReslutIsMap := result.(map[string]interface{})
 				if originalReslutIsMap && newReslutIsMap {
-					for k, v := range newMapResult {
-						originalMapResult[k] = v
-					}
-					result = originalMapResult
+					result = mergeMaps(originalMapResult, newMapResult)
 				}
 			}
 			// Set result in the input
@@ -357,6 +590,14 @@ func errorPrefix(s State) string {
 	return fmt.Sprintf("%vState Error:", *s.GetType())
 }
 
+// setType sets the Type of s to stateType only when it differs,
+// so validating a parsed State does not write to it while it may be executing
+func setType(s State, stateType string) {
+	if t := s.GetType(); t == nil || *t != stateType {
+		s.SetType(&stateType)
+	}
+}
+
 func ValidateNameAndType(s State) error {
 	if is.EmptyStr(s.Name()) {
 		return fmt.Errorf("Must have Name")
@@ -369,6 +610,18 @@ func ValidateNameAndType(s State) error {
 	return nil
 }
 
+func timeoutValid(timeoutSeconds int, heartbeatSeconds int) error {
+	if timeoutSeconds < 0 || heartbeatSeconds < 0 {
+		return fmt.Errorf("TimeoutSeconds and HeartbeatSeconds must be positive")
+	}
+
+	if timeoutSeconds > 0 && heartbeatSeconds >= timeoutSeconds {
+		return fmt.Errorf("HeartbeatSeconds must be smaller than TimeoutSeconds")
+	}
+
+	return nil
+}
+
 func retryValid(retry []*Retrier) error {
 	if retry == nil {
 		return nil
@@ -416,6 +669,7 @@ func errorEqualsValid(errorEquals []*string, last bool) error {
 				"States.Permissions",
 				"States.ResultPathMatchFailure",
 				"States.BranchFailed",
+				"States.ExceedToleratedFailureThreshold",
 				"States.NoChoiceMatched":
 			default:
 				return fmt.Errorf("Unknown States.* error found %q", *e)
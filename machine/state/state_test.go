@@ -71,6 +71,15 @@ func parseWaitState(b []byte, t *testing.T) *WaitState {
 	return &p
 }
 
+func parseFailState(b []byte, t *testing.T) *FailState {
+	var p FailState
+	err := json.Unmarshal(b, &p)
+	assert.NoError(t, err)
+	p.SetName(to.Strp("TestState"))
+	p.SetType(to.Strp("Fail"))
+	return &p
+}
+
 func parseTaskState(b []byte, t *testing.T) *TaskState {
 	var p TaskState
 	err := json.Unmarshal(b, &p)
@@ -0,0 +1,63 @@
+package state
+
+import (
+	"context"
+)
+
+// TaskRecorder records the calls to Task handlers, e.g. as TaskScheduled,
+// TaskStarted, TaskSucceeded and TaskFailed events in an execution history
+type TaskRecorder interface {
+	TaskScheduled(resource string, parameters interface{}, timeoutSeconds int)
+	TaskStarted(resource string)
+	TaskSucceeded(resource string, output interface{})
+	TaskFailed(resource string, err error)
+}
+
+type taskRecorderKey struct{}
+
+// WithTaskRecorder returns a Context holding the TaskRecorder Task states report to
+func WithTaskRecorder(ctx context.Context, recorder TaskRecorder) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, taskRecorderKey{}, recorder)
+}
+
+// ContextTaskRecorder returns the TaskRecorder from ctx, or nil
+func ContextTaskRecorder(ctx context.Context) TaskRecorder {
+	if ctx == nil {
+		return nil
+	}
+
+	recorder, _ := ctx.Value(taskRecorderKey{}).(TaskRecorder)
+	return recorder
+}
+
+// recordTask reports the call of the handler in exec with the input after Parameters,
+// and its raw output before ResultSelector
+func recordTask(resource *string, timeoutSeconds int, exec Execution) Execution {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		recorder := ContextTaskRecorder(ctx)
+		if recorder == nil {
+			return exec(ctx, input)
+		}
+
+		resourceStr := ""
+		if resource != nil {
+			resourceStr = *resource
+		}
+
+		recorder.TaskScheduled(resourceStr, input, timeoutSeconds)
+		recorder.TaskStarted(resourceStr)
+
+		output, next, err := exec(ctx, input)
+
+		if err != nil {
+			recorder.TaskFailed(resourceStr, err)
+		} else {
+			recorder.TaskSucceeded(resourceStr, output)
+		}
+
+		return output, next, err
+	}
+}
@@ -15,10 +15,11 @@ type TaskState struct {
 	Type    *string
 	Comment *string `json:",omitempty"`
 
-	InputPath  *jsonpath.Path `json:",omitempty"`
-	OutputPath *jsonpath.Path `json:",omitempty"`
-	ResultPath *jsonpath.Path `json:",omitempty"`
-	Parameters interface{}    `json:",omitempty"`
+	InputPath      *jsonpath.Path `json:",omitempty"`
+	OutputPath     *jsonpath.Path `json:",omitempty"`
+	ResultPath     *jsonpath.Path `json:",omitempty"`
+	ResultSelector interface{}    `json:",omitempty"`
+	Parameters     interface{}    `json:",omitempty"`
 
 	Resource *string `json:",omitempty"`
 
@@ -40,12 +41,25 @@ func (s *TaskState) SetTaskHandler(resourcefn interface{}) {
 }
 
 func (s *TaskState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-	result, err := handler.CallHandlerFunction(s.TaskHandler, ctx, input)
+	result, err := s.call(ctx, input)
 
 	if err != nil {
 		return nil, nil, err
 	}
 
+	// The result of a .waitForTaskToken Resource is the output sent to the task token
+	if isWaitForTaskToken(s.Resource) {
+		result, err = waitForCallback(ctx, s.HeartbeatSeconds)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// A null result stays null
+	if result == nil {
+		return nil, nextState(s.Next, s.End), nil
+	}
+
 	result, err = to.FromJSON(result)
 
 	if err != nil {
@@ -55,17 +69,36 @@ func (s *TaskState) process(ctx context.Context, input interface{}) (interface{}
 	return result, nextState(s.Next, s.End), nil
 }
 
+// call uses the TaskHandler, or without one the Integration registered for the Resource
+func (s *TaskState) call(ctx context.Context, input interface{}) (interface{}, error) {
+	if s.TaskHandler == nil && s.Resource != nil {
+		if integration, ok := ContextIntegrations(ctx).Find(*s.Resource); ok {
+			return integration(ctx, *s.Resource, input)
+		}
+	}
+
+	return handler.CallHandlerFunction(s.TaskHandler, ctx, input)
+}
+
 // Input must include the Task name in $.Task
 func (s *TaskState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
-		processCatcher(s.Catch,
+		processCatcher(s.Name(), s.Catch,
 			processRetrier(s.Name(), s.Retry,
 				inputOutput(
 					s.InputPath,
 					s.OutputPath,
-					withParams(
-						s.Parameters,
-						result(s.ResultPath, s.process),
+					withTaskToken(s.Resource,
+						withParams(
+							s.Parameters,
+							result(s.ResultPath,
+								withResultSelector(s.ResultSelector,
+									recordTask(s.Resource, s.TimeoutSeconds,
+										withTimeout(s.TimeoutSeconds, s.HeartbeatSeconds, s.process),
+									),
+								),
+							),
+						),
 					),
 				),
 			),
@@ -74,7 +107,7 @@ func (s *TaskState) Execute(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *TaskState) Validate() error {
-	s.SetType(to.Strp("Task"))
+	setType(s, "Task")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
@@ -94,10 +127,18 @@ func (s *TaskState) Validate() error {
 		}
 	}
 
+	if err := timeoutValid(s.TimeoutSeconds, s.HeartbeatSeconds); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
 	if err := catchValid(s.Catch); err != nil {
 		return err
 	}
 
+	if err := resultSelectorValid(s.ResultSelector); err != nil {
+		return fmt.Errorf("%v %v", errorPrefix(s), err)
+	}
+
 	if err := retryValid(s.Retry); err != nil {
 		return err
 	}
@@ -2,8 +2,11 @@ package state
 
 import (
 	"context"
+	"fmt"
 	"testing"
+	"time"
 
+	"github.com/coinbase/step/handler"
 	"github.com/coinbase/step/utils/to"
 	"github.com/stretchr/testify/assert"
 )
@@ -110,6 +113,40 @@ func Test_TaskState_Catch_Works(t *testing.T) {
 	}, t)
 }
 
+type errorRecorder struct {
+	recorded []string
+}
+
+func (r *errorRecorder) Retried(stateName string, retrier int, err error, attempt int, interval time.Duration) {
+	r.recorded = append(r.recorded, fmt.Sprintf("%v Retry[%v] %v", stateName, retrier, attempt))
+}
+
+func (r *errorRecorder) Caught(stateName string, catcher int, err error, next string) {
+	r.recorded = append(r.recorded, fmt.Sprintf("%v Catch[%v] %v", stateName, catcher, next))
+}
+
+func Test_TaskState_ErrorRecorder(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Retry": [{ "ErrorEquals": ["TestError"], "MaxAttempts": 1 }],
+		"Catch": [{ "ErrorEquals": ["NotTestError"], "Next": "Fail" }, { "ErrorEquals": ["TestError"], "Next": "Fail" }]
+	}`), ThrowTestErrorHandler, t)
+
+	recorder := &errorRecorder{}
+	ctx := WithRetryAttempts(WithErrorRecorder(context.Background(), recorder), NewRetryAttempts(nil))
+
+	// An ErrorRecorder is not a TransitionRecorder
+	assert.Nil(t, ContextTransitionRecorder(ctx))
+
+	for i := 0; i < 2; i++ {
+		_, _, err := state.Execute(ctx, map[string]interface{}{})
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"TestState Retry[0] 1", "TestState Catch[1] Fail"}, recorder.recorded)
+}
+
 func Test_TaskState_Catch_Doesnt_Catch(t *testing.T) {
 	state := parseValidTaskState([]byte(`{
 		"Next": "Pass",
@@ -157,6 +194,36 @@ func Test_TaskState_Retry_Works(t *testing.T) {
 	assert.Equal(t, 3, *calls)
 }
 
+func Test_TaskState_Retry_Attempts_Per_Execution(t *testing.T) {
+	th, calls := countCalls(ThrowTestErrorHandler)
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Retry": [{
+			"ErrorEquals": ["TestError"],
+			"MaxAttempts": 1
+		}]
+	}`), th, t)
+
+	// Each Execution has its own attempts, the Retrier itself is not changed
+	for i := 0; i < 2; i++ {
+		attempts := NewRetryAttempts(nil)
+		ctx := WithRetryAttempts(context.Background(), attempts)
+
+		_, next, err := state.Execute(ctx, map[string]interface{}{})
+		assert.NoError(t, err)
+		assert.Equal(t, "TestState", *next)
+		assert.Equal(t, map[string][]int{"TestState": {1}}, attempts.Attempts())
+
+		_, _, err = state.Execute(ctx, map[string]interface{}{})
+		assert.Error(t, err)
+	}
+
+	assert.Equal(t, 4, *calls)
+	assert.Equal(t, 0, state.Retry[0].attempts)
+}
+
 func Test_TaskState_Catch_AND_Retry_Works(t *testing.T) {
 	th, calls := countCalls(ThrowTestErrorHandler)
 
@@ -344,3 +411,189 @@ func Test_TaskState_InputPath_and_Parameters(t *testing.T) {
 		Output: map[string]interface{}{"x": "AHAH", "Task": "Noop", "Input": "AHAH"},
 	}, t)
 }
+
+func Test_TaskState_Retry_Waits_With_Backoff(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"Retry": [{
+			"ErrorEquals": ["TestError"],
+			"IntervalSeconds": 3,
+			"BackoffRate": 2.5,
+			"MaxAttempts": 2
+		}]
+	}`), ThrowTestErrorHandler, t)
+
+	start := time.Now()
+	clock := NewFakeClock(start)
+	ctx := WithClock(context.Background(), clock)
+
+	_, next, err := state.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, state.Name(), next)
+	assert.Equal(t, start.Add(3*time.Second), clock.Now())
+
+	_, next, err = state.Execute(ctx, map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, state.Name(), next)
+	assert.Equal(t, start.Add(10500*time.Millisecond), clock.Now())
+
+	_, _, err = state.Execute(ctx, map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Equal(t, start.Add(10500*time.Millisecond), clock.Now())
+}
+
+func Test_TaskState_TimeoutSeconds_Catch(t *testing.T) {
+	blocks := func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 1,
+		"Catch": [{
+			"ErrorEquals": ["States.Timeout"],
+			"Next": "TimedOut"
+		}]
+	}`), blocks, t)
+
+	testState(state, stateTestData{
+		Output: map[string]interface{}{"Error": "States.Timeout", "Cause": "exceeded TimeoutSeconds(1) or HeartbeatSeconds(0)"},
+		Next:   to.Strp("TimedOut"),
+	}, t)
+}
+
+func Test_TaskState_TimeoutSeconds_Waits_For_Handler(t *testing.T) {
+	returned := false
+	late := func(ctx context.Context, input interface{}) (interface{}, error) {
+		<-ctx.Done()
+		time.Sleep(100 * time.Millisecond)
+		returned = true
+		return map[string]interface{}{"late": true}, nil
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 1
+	}`), late, t)
+
+	// The handler is not abandoned, and its result after the timeout is ignored
+	testState(state, stateTestData{
+		Error: to.Strp("States.Timeout"),
+	}, t)
+	assert.True(t, returned)
+}
+
+func Test_TaskState_HeartbeatSeconds(t *testing.T) {
+	heartbeats := func(ctx context.Context, input interface{}) (interface{}, error) {
+		for i := 0; i < 4; i++ {
+			time.Sleep(400 * time.Millisecond)
+			if err := handler.Heartbeat(ctx); err != nil {
+				return nil, err
+			}
+		}
+		return map[string]interface{}{"done": true}, nil
+	}
+
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"TimeoutSeconds": 3,
+		"HeartbeatSeconds": 1
+	}`), heartbeats, t)
+
+	testState(state, stateTestData{
+		Output: map[string]interface{}{"done": true},
+	}, t)
+
+	noHeartbeat := func(ctx context.Context, input interface{}) (interface{}, error) {
+		time.Sleep(1500 * time.Millisecond)
+		return map[string]interface{}{"done": true}, nil
+	}
+
+	state = parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"HeartbeatSeconds": 1
+	}`), noHeartbeat, t)
+
+	testState(state, stateTestData{
+		Error: to.Strp("States.Timeout"),
+	}, t)
+}
+
+func Test_TaskState_Validate_TimeoutSeconds(t *testing.T) {
+	state := parseTaskState([]byte(`{ "Next": "Pass", "Resource": "test", "TimeoutSeconds": 1, "HeartbeatSeconds": 1 }`), t)
+	assert.Error(t, state.Validate())
+
+	state = parseTaskState([]byte(`{ "Next": "Pass", "Resource": "test", "TimeoutSeconds": -1 }`), t)
+	assert.Error(t, state.Validate())
+}
+
+func Test_TaskState_ResultSelector(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "resource",
+		"ResultSelector": {
+			"z.$": "$.z",
+			"static": "s",
+			"greeting.$": "States.Format('z is {}', $.z)"
+		},
+		"ResultPath": "$.result"
+	}`), ReturnMapTestHandler, t)
+
+	testState(state, stateTestData{
+		Input: map[string]interface{}{"a": "b"},
+		Output: map[string]interface{}{
+			"a": "b",
+			"result": map[string]interface{}{
+				"z":        "y",
+				"static":   "s",
+				"greeting": "z is y",
+			},
+		},
+	}, t)
+}
+
+func Test_TaskState_ResultSelector_Errors(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "resource",
+		"ResultSelector": { "missing.$": "$.missing" }
+	}`), ReturnMapTestHandler, t)
+
+	_, _, err := state.Execute(context.Background(), map[string]interface{}{})
+	assert.Error(t, err)
+	assert.Regexp(t, "ResultSelector Error", err.Error())
+
+	state = parseTaskState([]byte(`{ "Next": "Pass", "Resource": "resource", "ResultSelector": "$.z" }`), t)
+	err = state.Validate()
+	assert.Error(t, err)
+	assert.Regexp(t, "ResultSelector must be an object", err.Error())
+}
+
+func Test_TaskState_Does_Not_Change_Input(t *testing.T) {
+	state := parseValidTaskState([]byte(`{
+		"Next": "Pass",
+		"Resource": "test",
+		"ResultPath": "$.a.r"
+	}`), ReturnMapTestHandler, t)
+
+	input := map[string]interface{}{"a": map[string]interface{}{"b": "c"}}
+
+	output, _, err := state.Execute(nil, input)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": "c", "r": map[string]interface{}{"z": "y"}}}, output)
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": "c"}}, input)
+
+	// Merging a map output into the input makes a new map too
+	state = parseValidTaskState([]byte(`{ "Next": "Pass", "Resource": "test" }`), ReturnMapTestHandler, t)
+
+	output, _, err = state.Execute(nil, input)
+	assert.NoError(t, err)
+	assert.Equal(t, "y", output.(map[string]interface{})["z"])
+	assert.Equal(t, map[string]interface{}{"a": map[string]interface{}{"b": "c"}}, input)
+}
@@ -0,0 +1,231 @@
+package state
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coinbase/step/errors"
+	"github.com/coinbase/step/handler"
+)
+
+// Callbacks holds the Tasks of ".waitForTaskToken" Resources waiting for their task token,
+// it receives SendTaskSuccess, SendTaskFailure and SendTaskHeartbeat like the AWS API
+type Callbacks struct {
+	lock    sync.Mutex
+	waiting map[string]*callback
+	order   []string
+	changed chan struct{} // closed and replaced whenever a token starts waiting
+}
+
+type callback struct {
+	done chan callbackResult // buffered so a callback can arrive before the Task waits
+
+	lock      sync.Mutex
+	heartbeat func() error // set while the Task waits with HeartbeatSeconds
+}
+
+type callbackResult struct {
+	output interface{}
+	err    error
+}
+
+func NewCallbacks() *Callbacks {
+	return &Callbacks{
+		waiting: map[string]*callback{},
+		changed: make(chan struct{}),
+	}
+}
+
+// SendTaskSuccess completes the Task waiting for token with output
+func (c *Callbacks) SendTaskSuccess(token string, output interface{}) error {
+	cb, err := c.remove(token)
+	if err != nil {
+		return err
+	}
+
+	output, err = copyJSON(output)
+	if err != nil {
+		return err
+	}
+
+	cb.done <- callbackResult{output: output}
+	return nil
+}
+
+// SendTaskFailure fails the Task waiting for token with the Error and Cause
+func (c *Callbacks) SendTaskFailure(token string, errorName string, cause string) error {
+	cb, err := c.remove(token)
+	if err != nil {
+		return err
+	}
+
+	cb.done <- callbackResult{err: errors.StatesError{Name: errorName, Cause: cause}}
+	return nil
+}
+
+// SendTaskHeartbeat extends the HeartbeatSeconds deadline of the Task waiting for token
+func (c *Callbacks) SendTaskHeartbeat(token string) error {
+	c.lock.Lock()
+	cb, ok := c.waiting[token]
+	c.lock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("TaskDoesNotExist: Task Token %q is not waiting", token)
+	}
+
+	cb.lock.Lock()
+	heartbeat := cb.heartbeat
+	cb.lock.Unlock()
+
+	if heartbeat == nil {
+		return nil
+	}
+	return heartbeat()
+}
+
+// Tokens returns the task tokens waiting for a callback, oldest first
+func (c *Callbacks) Tokens() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]string{}, c.order...)
+}
+
+// WaitForTaskToken blocks until a task token is waiting and returns the oldest
+func (c *Callbacks) WaitForTaskToken(ctx context.Context) (string, error) {
+	for {
+		c.lock.Lock()
+		changed := c.changed
+		if len(c.order) > 0 {
+			token := c.order[0]
+			c.lock.Unlock()
+			return token, nil
+		}
+		c.lock.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+func (c *Callbacks) add(token string) *callback {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cb := &callback{done: make(chan callbackResult, 1)}
+	c.waiting[token] = cb
+	c.order = append(c.order, token)
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+
+	return cb
+}
+
+func (c *Callbacks) remove(token string) (*callback, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cb, ok := c.waiting[token]
+	if !ok {
+		return nil, fmt.Errorf("TaskDoesNotExist: Task Token %q is not waiting", token)
+	}
+
+	delete(c.waiting, token)
+	for i, t := range c.order {
+		if t == token {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			break
+		}
+	}
+
+	return cb, nil
+}
+
+type callbacksKey struct{}
+
+// WithCallbacks returns a Context holding the Callbacks ".waitForTaskToken" Tasks wait on
+func WithCallbacks(ctx context.Context, callbacks *Callbacks) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, callbacksKey{}, callbacks)
+}
+
+// ContextCallbacks returns the Callbacks from ctx, or nil
+func ContextCallbacks(ctx context.Context) *Callbacks {
+	if ctx == nil {
+		return nil
+	}
+
+	callbacks, _ := ctx.Value(callbacksKey{}).(*Callbacks)
+	return callbacks
+}
+
+func isWaitForTaskToken(resource *string) bool {
+	return resource != nil && strings.HasSuffix(*resource, ".waitForTaskToken")
+}
+
+type taskTokenKey struct{}
+
+// withTaskToken generates the task token of a ".waitForTaskToken" Resource as $$.Task.Token,
+// and waits for its callback once exec has called the handler
+func withTaskToken(resource *string, exec Execution) Execution {
+	return func(ctx context.Context, input interface{}) (interface{}, *string, error) {
+		if !isWaitForTaskToken(resource) {
+			return exec(ctx, input)
+		}
+
+		callbacks := ContextCallbacks(ctx)
+		if callbacks == nil {
+			return nil, nil, fmt.Errorf("%v requires Callbacks to send the task token to", *resource)
+		}
+
+		token, err := newUUID()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		contextObject := map[string]interface{}{}
+		for k, v := range ContextObject(ctx) {
+			contextObject[k] = v
+		}
+		contextObject["Task"] = map[string]interface{}{"Token": token}
+
+		cb := callbacks.add(token)
+		defer callbacks.remove(token)
+
+		ctx = WithContextObject(ctx, contextObject)
+		ctx = context.WithValue(ctx, taskTokenKey{}, cb)
+
+		return exec(ctx, input)
+	}
+}
+
+// waitForCallback returns the output sent to the task token, ctx bounds it with the Task TimeoutSeconds
+// and with HeartbeatSeconds extended by SendTaskHeartbeat
+func waitForCallback(ctx context.Context, heartbeatSeconds int) (interface{}, error) {
+	cb, ok := ctx.Value(taskTokenKey{}).(*callback)
+	if !ok {
+		return nil, fmt.Errorf("no task token to wait for")
+	}
+
+	if heartbeatSeconds > 0 {
+		cb.lock.Lock()
+		cb.heartbeat = func() error {
+			return handler.Heartbeat(ctx)
+		}
+		cb.lock.Unlock()
+	}
+
+	select {
+	case result := <-cb.done:
+		return result.output, result.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
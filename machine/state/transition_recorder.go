@@ -0,0 +1,25 @@
+package state
+
+import (
+	"context"
+)
+
+// TransitionRecorder is an ErrorRecorder that also records the Choice used by index,
+// with -1 the Default of a Choice, so it knows why every State transitions
+type TransitionRecorder interface {
+	ErrorRecorder
+	Chose(stateName string, choice int, next string)
+}
+
+// WithTransitionRecorder returns a Context holding the TransitionRecorder States report to,
+// it is also the ErrorRecorder of the Context
+func WithTransitionRecorder(ctx context.Context, recorder TransitionRecorder) context.Context {
+	return WithErrorRecorder(ctx, recorder)
+}
+
+// ContextTransitionRecorder returns the TransitionRecorder from ctx, or nil if there is none
+// or the ErrorRecorder of ctx does not record Choices
+func ContextTransitionRecorder(ctx context.Context) TransitionRecorder {
+	recorder, _ := ContextErrorRecorder(ctx).(TransitionRecorder)
+	return recorder
+}
@@ -6,7 +6,6 @@ import (
 	"time"
 
 	"github.com/coinbase/step/jsonpath"
-	"github.com/coinbase/step/utils/to"
 )
 
 type WaitState struct {
@@ -29,28 +28,42 @@ type WaitState struct {
 }
 
 func (s *WaitState) process(ctx context.Context, input interface{}) (interface{}, *string, error) {
-
-	if s.SecondsPath != nil {
-		// Validate the path exists
-		_, err := s.SecondsPath.GetNumber(input)
+	clock := ContextClock(ctx)
+
+	var duration time.Duration
+	switch {
+	case s.Seconds != nil:
+		duration = secondsDuration(*s.Seconds)
+	case s.SecondsPath != nil:
+		seconds, err := s.SecondsPath.GetNumber(input)
 		if err != nil {
 			return nil, nil, err
 		}
-
-	} else if s.TimestampPath != nil {
-		// Validate the path exists
-		_, err := s.TimestampPath.GetTime(input)
+		duration = secondsDuration(*seconds)
+	case s.Timestamp != nil:
+		duration = s.Timestamp.Sub(clock.Now())
+	case s.TimestampPath != nil:
+		timestamp, err := s.TimestampPath.GetTime(input)
 		if err != nil {
 			return nil, nil, err
 		}
+		duration = timestamp.Sub(clock.Now())
 	}
 
-	// Always sleep the same amount of time, as this is a simulation
-	time.Sleep(50 * time.Millisecond)
+	// A Timestamp in the past does not wait
+	if duration > 0 {
+		if err := clock.Sleep(ctx, duration); err != nil {
+			return nil, nil, err
+		}
+	}
 
 	return input, nextState(s.Next, s.End), nil
 }
 
+func secondsDuration(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
+
 func (s *WaitState) Execute(ctx context.Context, input interface{}) (output interface{}, next *string, err error) {
 	return processError(s,
 		inputOutput(
@@ -62,7 +75,7 @@ func (s *WaitState) Execute(ctx context.Context, input interface{}) (output inte
 }
 
 func (s *WaitState) Validate() error {
-	s.SetType(to.Strp("Wait"))
+	setType(s, "Wait")
 
 	if err := ValidateNameAndType(s); err != nil {
 		return fmt.Errorf("%v %v", errorPrefix(s), err)
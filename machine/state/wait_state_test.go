@@ -1,7 +1,9 @@
 package state
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -34,3 +36,31 @@ func Test_WaitState_SecondsPath(t *testing.T) {
 	_, _, err = state.Execute(nil, map[string]interface{}{})
 	assert.Error(t, err)
 }
+
+func Test_WaitState_Advances_Clock(t *testing.T) {
+	start := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		json  string
+		input map[string]interface{}
+		waits time.Duration
+	}{
+		{`{"Seconds": 10.5, "Next": "Public"}`, nil, 10500 * time.Millisecond},
+		{`{"SecondsPath": "$.s", "Next": "Public"}`, map[string]interface{}{"s": 30.0}, 30 * time.Second},
+		{`{"Timestamp": "2018-01-01T01:00:00Z", "Next": "Public"}`, nil, time.Hour},
+		{`{"TimestampPath": "$.t", "Next": "Public"}`, map[string]interface{}{"t": "2018-01-02T00:00:00Z"}, 24 * time.Hour},
+		// In the past so no wait
+		{`{"Timestamp": "2017-01-01T00:00:00Z", "Next": "Public"}`, nil, 0},
+	}
+
+	for _, test := range tests {
+		clock := NewFakeClock(start)
+		state := parseWaitState([]byte(test.json), t)
+		assert.NoError(t, state.Validate())
+
+		_, next, err := state.Execute(WithClock(context.Background(), clock), test.input)
+		assert.NoError(t, err)
+		assert.Equal(t, "Public", *next)
+		assert.Equal(t, start.Add(test.waits), clock.Now(), test.json)
+	}
+}
@@ -3,14 +3,19 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/coinbase/step/aws"
 	"github.com/coinbase/step/machine"
+	"github.com/coinbase/step/machine/state"
 
 	"github.com/coinbase/step/bifrost"
 	"github.com/coinbase/step/client"
 	"github.com/coinbase/step/deployer"
+	"github.com/coinbase/step/local"
 	"github.com/coinbase/step/utils/run"
 	"github.com/coinbase/step/utils/to"
 )
@@ -29,6 +34,20 @@ func main() {
 	dotCommand := flag.NewFlagSet("dot", flag.ExitOnError)
 	dotStates := dotCommand.String("states", "{}", "State Machine JSON")
 
+	debugCommand := flag.NewFlagSet("debug", flag.ExitOnError)
+	debugStates := debugCommand.String("states", "", "State Machine JSON file")
+	debugInput := debugCommand.String("input", "", "Input JSON file")
+	debugBreak := debugCommand.String("break", "", "comma separated State names to pause at, default pauses at every State")
+
+	replayCommand := flag.NewFlagSet("replay", flag.ExitOnError)
+	replayStates := replayCommand.String("states", "", "State Machine JSON file")
+	replayExecution := replayCommand.String("execution", "", "ARN of the AWS execution to replay")
+
+	localCommand := flag.NewFlagSet("local", flag.ExitOnError)
+	localPort := localCommand.Int("port", 8083, "port to serve the Step Functions API on")
+	localLambda := localCommand.String("lambda", default_name, "lambda name of the deployer Task handlers")
+	localFakes := localCommand.Bool("fakes", false, "fake the Lambda, SQS, SNS, DynamoDB and S3 integrations in memory")
+
 	// Other Subcommands
 	bootstrapCommand := flag.NewFlagSet("bootstrap", flag.ExitOnError)
 	deployCommand := flag.NewFlagSet("deploy", flag.ExitOnError)
@@ -67,16 +86,28 @@ func main() {
 		jsonCommand.Parse(os.Args[2:])
 	case "dot":
 		dotCommand.Parse(os.Args[2:])
+	case "debug":
+		debugCommand.Parse(os.Args[2:])
+	case "replay":
+		replayCommand.Parse(os.Args[2:])
+	case "local":
+		localCommand.Parse(os.Args[2:])
 	case "bootstrap":
 		bootstrapCommand.Parse(os.Args[2:])
 	case "deploy":
 		deployCommand.Parse(os.Args[2:])
 	default:
-		fmt.Println("Usage of step: step <json|bootstrap|deploy|dot> <args> (No args starts Lambda)")
+		fmt.Println("Usage of step: step <json|bootstrap|deploy|dot|debug|replay|local> <args> (No args starts Lambda)")
 		fmt.Println("json")
 		jsonCommand.PrintDefaults()
 		fmt.Println("dot")
 		dotCommand.PrintDefaults()
+		fmt.Println("debug")
+		debugCommand.PrintDefaults()
+		fmt.Println("replay")
+		replayCommand.PrintDefaults()
+		fmt.Println("local")
+		localCommand.PrintDefaults()
 		fmt.Println("bootstrap")
 		bootstrapCommand.PrintDefaults()
 		fmt.Println("deploy")
@@ -89,6 +120,13 @@ func main() {
 		run.JSON(deployer.StateMachine())
 	} else if dotCommand.Parsed() {
 		run.Dot(machine.FromJSON([]byte(*dotStates)))
+	} else if debugCommand.Parsed() {
+		debugRun(debugStates, debugInput, debugBreak)
+	} else if replayCommand.Parsed() {
+		state_machine, err := machine.ParseFile(*replayStates)
+		run.Replay(state_machine, err, (&aws.Clients{}).SFNClient(nil, nil, nil), replayExecution)
+	} else if localCommand.Parsed() {
+		localRun(*localPort, *localLambda, *localFakes)
 	} else if bootstrapCommand.Parsed() {
 		r := newRelease(
 			bootstrapProject,
@@ -139,6 +177,43 @@ func deployRun(release *deployer.Release, zip *string, deployer_arn *string) {
 	check(err)
 }
 
+func debugRun(states *string, input_file *string, breakpoints *string) {
+	input := to.Strp("{}")
+	if *input_file != "" {
+		raw, err := ioutil.ReadFile(*input_file)
+		if err != nil {
+			fmt.Println("ERROR", err)
+			os.Exit(1)
+		}
+		input = to.Strp(string(raw))
+	}
+
+	breaks := []string{}
+	for _, name := range strings.Split(*breakpoints, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			breaks = append(breaks, name)
+		}
+	}
+
+	state_machine, err := machine.ParseFile(*states)
+	run.Debug(state_machine, err, input, breaks)
+}
+
+func localRun(port int, lambda string, fakes bool) {
+	server := local.NewServer()
+	server.RegisterLambda(lambda, deployer.TaskHandlers())
+
+	if fakes {
+		server.Integrations = state.NewFakeServices().Integrations()
+	}
+
+	fmt.Printf("Serving the Step Functions API on :%v\n", port)
+	if err := server.ListenAndServe(port); err != nil {
+		fmt.Println("ERROR", err)
+		os.Exit(1)
+	}
+}
+
 func newRelease(project *string, config *string, lambda *string, step *string, bucket *string, states *string, region *string, account_id *string) *deployer.Release {
 	return &deployer.Release{
 		Release: bifrost.Release{
@@ -0,0 +1,217 @@
+package run
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coinbase/step/jsonpath"
+	"github.com/coinbase/step/machine"
+	"github.com/coinbase/step/machine/state"
+	"github.com/coinbase/step/utils/to"
+)
+
+var debugHelp = `Commands:
+  s, step               execute the current state and pause at the next
+  c, continue           run until the next breakpoint
+  i, input              print the input of the current state
+  o, output             print the output of the last state
+  p, print <path>       print the JSONPath of the input, e.g. p $.a.b
+  set [<path>] <json>   overwrite the input, or the value at path
+  b, break <state>      add a breakpoint on a state
+  q, quit               stop the execution
+  h, help               print this help`
+
+// Debug executes the state machine pausing at the breakpoint states,
+// with no breakpoints it pauses at every state. Tasks use the DefaultHandler
+func Debug(stateMachine *machine.StateMachine, err error, input *string, breakpoints []string) {
+	if err != nil {
+		fmt.Println("ERROR", err)
+		os.Exit(1)
+	}
+
+	stateMachine.SetDefaultHandler()
+
+	exec, err := debug(stateMachine, input, breakpoints, os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Println("ERROR", err)
+	}
+
+	if exec != nil {
+		fmt.Println(exec.OutputJSON)
+	}
+
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func debug(stateMachine *machine.StateMachine, input *string, breakpoints []string, in io.Reader, out io.Writer) (*machine.Execution, error) {
+	d := &debugger{
+		states:      stateMachine.States,
+		breakpoints: map[string]bool{},
+		stepping:    len(breakpoints) == 0,
+		in:          bufio.NewScanner(in),
+		out:         out,
+	}
+
+	for _, name := range breakpoints {
+		if err := d.addBreakpoint(name); err != nil {
+			return nil, err
+		}
+	}
+
+	if input == nil || strings.TrimSpace(*input) == "" {
+		input = to.Strp("{}")
+	}
+
+	// The debugger is only for this Execution, the StateMachine is unchanged
+	ctx := machine.WithBreakpoint(machine.WithObserver(context.Background(), d), d.pause)
+	return stateMachine.ExecuteContext(ctx, input)
+}
+
+// debugger pauses before states to read commands, it observes the outputs of states
+type debugger struct {
+	machine.NoopObserver
+
+	states      machine.States
+	breakpoints map[string]bool
+	stepping    bool
+
+	in  *bufio.Scanner
+	out io.Writer
+
+	lastOutput interface{}
+}
+
+func (d *debugger) OnStateExited(event machine.StateEvent) {
+	d.lastOutput = event.Output
+}
+
+// addBreakpoint pauses before the State name
+func (d *debugger) addBreakpoint(name string) error {
+	if _, ok := d.states[name]; !ok {
+		return fmt.Errorf("Breakpoint Unknown State: %v", name)
+	}
+
+	d.breakpoints[name] = true
+	return nil
+}
+
+func (d *debugger) pause(s state.State, input interface{}) (interface{}, error) {
+	if !d.stepping && !d.breakpoints[*s.Name()] {
+		return input, nil
+	}
+
+	fmt.Fprintf(d.out, "Paused at %v (%v)\n", *s.Name(), *s.GetType())
+
+	for {
+		fmt.Fprint(d.out, "(step) ")
+		if !d.in.Scan() {
+			// No more commands, run to the end
+			d.stepping = false
+			d.breakpoints = map[string]bool{}
+			return input, nil
+		}
+
+		command, args := splitCommand(d.in.Text())
+
+		switch command {
+		case "":
+		case "s", "step":
+			d.stepping = true
+			return input, nil
+		case "c", "continue":
+			d.stepping = false
+			return input, nil
+		case "i", "input":
+			d.printJSON(input)
+		case "o", "output":
+			d.printJSON(d.lastOutput)
+		case "p", "print":
+			path, err := jsonpath.NewPath(args)
+			if err != nil {
+				fmt.Fprintln(d.out, "ERROR", err)
+				continue
+			}
+
+			value, err := path.Get(input)
+			if err != nil {
+				fmt.Fprintln(d.out, "ERROR", err)
+				continue
+			}
+			d.printJSON(value)
+		case "set":
+			updated, err := setInput(input, args)
+			if err != nil {
+				fmt.Fprintln(d.out, "ERROR", err)
+				continue
+			}
+			input = updated
+		case "b", "break":
+			if err := d.addBreakpoint(args); err != nil {
+				fmt.Fprintln(d.out, "ERROR", err)
+			}
+		case "q", "quit":
+			return nil, fmt.Errorf("Debugger Quit at %v", *s.Name())
+		case "h", "help":
+			fmt.Fprintln(d.out, debugHelp)
+		default:
+			fmt.Fprintf(d.out, "Unknown Command %q\n%v\n", command, debugHelp)
+		}
+	}
+}
+
+func (d *debugger) printJSON(value interface{}) {
+	str, err := to.PrettyJSON(value)
+	if err != nil {
+		fmt.Fprintln(d.out, "ERROR", err)
+		return
+	}
+	fmt.Fprintln(d.out, str)
+}
+
+func splitCommand(line string) (string, string) {
+	parts := strings.SplitN(strings.TrimSpace(line), " ", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], strings.TrimSpace(parts[1])
+}
+
+// setInput replaces the input with the JSON in args, or if args starts with a path the value at that path
+func setInput(input interface{}, args string) (interface{}, error) {
+	if !strings.HasPrefix(args, "$") {
+		return parseJSON(args)
+	}
+
+	pathStr, valueStr := splitCommand(args)
+	path, err := jsonpath.NewPath(pathStr)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := parseJSON(valueStr)
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := path.Set(input, value)
+	if err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+func parseJSON(str string) (interface{}, error) {
+	var value interface{}
+	if err := json.Unmarshal([]byte(str), &value); err != nil {
+		return nil, fmt.Errorf("Invalid JSON: %v", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,74 @@
+package run
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/step/machine"
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+var debugMachine = `{
+  "StartAt": "First",
+  "States": {
+    "First": { "Type": "Pass", "Result": "one", "ResultPath": "$.first", "Next": "Second" },
+    "Second": { "Type": "Pass", "Result": "two", "ResultPath": "$.second", "End": true }
+  }
+}`
+
+func Test_Debug_Step_Inspect_And_Set(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(debugMachine))
+	assert.NoError(t, err)
+
+	commands := strings.Join([]string{
+		"p $.a",
+		"step",
+		"output",
+		"set $.a 2",
+		"continue",
+	}, "\n")
+
+	var out bytes.Buffer
+	exec, err := debug(sm, to.Strp(`{"a": 1}`), nil, strings.NewReader(commands), &out)
+	assert.NoError(t, err)
+
+	assert.Equal(t, map[string]interface{}{"a": 2.0, "first": "one", "second": "two"}, exec.Output)
+	assert.Contains(t, out.String(), "Paused at First (Pass)")
+	assert.Contains(t, out.String(), "Paused at Second (Pass)")
+	assert.Contains(t, out.String(), `"first": "one"`)
+}
+
+func Test_Debug_Breakpoints(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(debugMachine))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	_, err = debug(sm, nil, []string{"Second"}, strings.NewReader("set {}\nquit\n"), &out)
+	assert.Error(t, err)
+	assert.Regexp(t, "Debugger Quit at Second", err.Error())
+	assert.NotContains(t, out.String(), "Paused at First")
+
+	_, err = debug(sm, nil, []string{"Unknown"}, strings.NewReader(""), &out)
+	assert.Error(t, err)
+}
+
+func Test_Debug_Break_Command(t *testing.T) {
+	sm, err := machine.FromJSON([]byte(debugMachine))
+	assert.NoError(t, err)
+
+	var out bytes.Buffer
+	_, err = debug(sm, nil, nil, strings.NewReader("b Missing\nb Second\ncontinue\nquit\n"), &out)
+	assert.Error(t, err)
+	assert.Regexp(t, "Debugger Quit at Second", err.Error())
+	assert.Contains(t, out.String(), "ERROR Breakpoint Unknown State: Missing")
+
+	// The machine is not changed by debugging
+	assert.Nil(t, sm.Breakpoint)
+	assert.Empty(t, sm.Observers)
+
+	exec, err := sm.Execute(map[string]interface{}{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"First", "Second"}, exec.Path())
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/coinbase/step/jsonpath"
 	"github.com/coinbase/step/utils/to"
 
 	"github.com/coinbase/step/machine/state"
@@ -87,6 +88,50 @@ func processState(s state.State) string {
 			strs = append(strs, fmt.Sprintf(`%q -> _End`, name))
 		}
 
+	case *state.ParallelState:
+		sstate := s.(*state.ParallelState)
+		strs = append(strs, fmt.Sprintf(`%q [shape=parallelogram, fillcolor="#FBFBFB", label="%v (%v)"]`, name, name, len(sstate.Branches)))
+
+		if sstate.Retry != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> %q [color="#F9E4D1"]`, name, name))
+		}
+
+		if sstate.Catch != nil {
+			for _, c := range sstate.Catch {
+				strs = append(strs, fmt.Sprintf(`%q -> %q [color="#F9E4D1", label=%q]`, name, *c.Next, strings.Join(to.StrSlice(c.ErrorEquals), ",")))
+			}
+		}
+
+		if sstate.Next != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> %q [weight=100]`, name, *sstate.Next))
+		}
+
+		if sstate.End != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> _End`, name))
+		}
+
+	case *state.MapState:
+		sstate := s.(*state.MapState)
+		strs = append(strs, fmt.Sprintf(`%q [shape=parallelogram, fillcolor="#FBFBFB", label="%v (Map)"]`, name, name))
+
+		if sstate.Retry != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> %q [color="#F9E4D1"]`, name, name))
+		}
+
+		if sstate.Catch != nil {
+			for _, c := range sstate.Catch {
+				strs = append(strs, fmt.Sprintf(`%q -> %q [color="#F9E4D1", label=%q]`, name, *c.Next, strings.Join(to.StrSlice(c.ErrorEquals), ",")))
+			}
+		}
+
+		if sstate.Next != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> %q [weight=100]`, name, *sstate.Next))
+		}
+
+		if sstate.End != nil {
+			strs = append(strs, fmt.Sprintf(`%q -> _End`, name))
+		}
+
 	case *state.ChoiceState:
 		sstate := s.(*state.ChoiceState)
 		strs = append(strs, fmt.Sprintf(`%q [shape=diamond, fillcolor="#FBFBFB"]`, name))
@@ -144,14 +189,14 @@ func choiceStr(cr state.ChoiceRule) string {
 
 	if cr.Or != nil {
 		strs := []string{}
-		for _, a := range cr.And {
+		for _, a := range cr.Or {
 			strs = append(strs, choiceStr(*a))
 		}
 		return strings.Join(strs, " || ")
 	}
 
 	if cr.Not != nil {
-		return fmt.Sprintf("!%v", *cr.Variable)
+		return fmt.Sprintf("!(%v)", choiceStr(*cr.Not))
 	}
 
 	op := ""
@@ -221,5 +266,55 @@ func choiceStr(cr state.ChoiceRule) string {
 		op = fmt.Sprintf(">=%v", *cr.TimestampGreaterThanEquals)
 	}
 
+	if cr.StringMatches != nil {
+		op = fmt.Sprintf(" matches %v", *cr.StringMatches)
+	}
+
+	// Paths
+	for _, p := range []struct {
+		op   string
+		path *jsonpath.Path
+	}{
+		{"=", cr.StringEqualsPath},
+		{"<", cr.StringLessThanPath},
+		{">", cr.StringGreaterThanPath},
+		{"<=", cr.StringLessThanEqualsPath},
+		{">=", cr.StringGreaterThanEqualsPath},
+		{"=", cr.NumericEqualsPath},
+		{"<", cr.NumericLessThanPath},
+		{">", cr.NumericGreaterThanPath},
+		{"<=", cr.NumericLessThanEqualsPath},
+		{">=", cr.NumericGreaterThanEqualsPath},
+		{"=", cr.BooleanEqualsPath},
+		{"=", cr.TimestampEqualsPath},
+		{"<", cr.TimestampLessThanPath},
+		{">", cr.TimestampGreaterThanPath},
+		{"<=", cr.TimestampLessThanEqualsPath},
+		{">=", cr.TimestampGreaterThanEqualsPath},
+	} {
+		if p.path != nil {
+			op = fmt.Sprintf("%v%v", p.op, p.path.String())
+		}
+	}
+
+	// Type tests
+	for _, test := range []struct {
+		name  string
+		value *bool
+	}{
+		{"present", cr.IsPresent},
+		{"null", cr.IsNull},
+		{"string", cr.IsString},
+		{"numeric", cr.IsNumeric},
+		{"boolean", cr.IsBoolean},
+		{"timestamp", cr.IsTimestamp},
+	} {
+		if test.value != nil && *test.value {
+			op = fmt.Sprintf(" is %v", test.name)
+		} else if test.value != nil {
+			op = fmt.Sprintf(" is not %v", test.name)
+		}
+	}
+
 	return fmt.Sprintf("%v%v", cr.Variable.String(), op)
 }
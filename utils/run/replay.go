@@ -0,0 +1,55 @@
+package run
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/coinbase/step/aws"
+	"github.com/coinbase/step/execution"
+	"github.com/coinbase/step/machine"
+)
+
+// Replay downloads the history of an AWS execution, replays it against the state machine
+// and reports the first State where they diverge, exiting 1 if they do
+func Replay(stateMachine *machine.StateMachine, err error, sfnc aws.SFNAPI, executionArn *string) {
+	if err != nil {
+		fmt.Println("ERROR", err)
+		os.Exit(1)
+	}
+
+	diverged, err := replay(stateMachine, sfnc, executionArn, os.Stdout)
+	if err != nil {
+		fmt.Println("ERROR", err)
+		os.Exit(1)
+	}
+
+	if diverged {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func replay(stateMachine *machine.StateMachine, sfnc aws.SFNAPI, executionArn *string, out io.Writer) (bool, error) {
+	history, err := execution.GetHistory(sfnc, executionArn)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := stateMachine.Replay(history)
+	if err != nil {
+		return false, err
+	}
+
+	fmt.Fprintf(out, "Recorded: %v\n", strings.Join(result.ExpectedPath, " -> "))
+	fmt.Fprintf(out, "Local:    %v\n", strings.Join(result.Execution.Path(), " -> "))
+
+	if result.Divergence == nil {
+		fmt.Fprintln(out, "No divergence")
+		return false, nil
+	}
+
+	fmt.Fprintln(out, result.Divergence.String())
+	return true, nil
+}
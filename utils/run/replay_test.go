@@ -0,0 +1,40 @@
+package run
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/step/aws/mocks"
+	"github.com/coinbase/step/machine"
+	"github.com/coinbase/step/utils/to"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Replay_Reports_Divergence(t *testing.T) {
+	recorded, err := machine.FromJSON([]byte(debugMachine))
+	assert.NoError(t, err)
+
+	exec, err := recorded.Execute(map[string]interface{}{"a": 1})
+	assert.NoError(t, err)
+
+	sfnc := &mocks.MockSFNClient{GetExecutionHistoryResp: exec.HistoryOutput(false)}
+
+	// The same machine does not diverge
+	var out bytes.Buffer
+	diverged, err := replay(recorded, sfnc, to.Strp("arn"), &out)
+	assert.NoError(t, err)
+	assert.False(t, diverged)
+	assert.Contains(t, out.String(), "Recorded: First -> Second")
+	assert.Contains(t, out.String(), "No divergence")
+
+	// A changed definition does
+	changed, err := machine.FromJSON([]byte(strings.Replace(debugMachine, `"Result": "two"`, `"Result": "three"`, 1)))
+	assert.NoError(t, err)
+
+	out.Reset()
+	diverged, err = replay(changed, sfnc, to.Strp("arn"), &out)
+	assert.NoError(t, err)
+	assert.True(t, diverged)
+	assert.Contains(t, out.String(), `Output diverged at State "Second" (1)`)
+}
@@ -38,6 +38,11 @@ func StrSlice(strps []*string) []string {
 
 // Take from aws-lambda-go.Function#lambdaErrorResponse
 func ErrorType(invokeError error) string {
+	// Errors can define their own type e.g. States.Timeout
+	if typed, ok := invokeError.(interface{ ErrorType() string }); ok {
+		return typed.ErrorType()
+	}
+
 	var errorName string
 	if errorType := reflect.TypeOf(invokeError); errorType.Kind() == reflect.Ptr {
 		errorName = errorType.Elem().Name()